@@ -0,0 +1,253 @@
+package main
+
+import (
+	"container/list"
+	mrand "math/rand"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const maxShuffleVariants = 16
+
+func newResponseCache(max int) *responseCache {
+	return &responseCache{
+		max:     max,
+		entries: make(map[responseCacheKey]*responseCacheEntry),
+		byName:  make(map[string]map[responseCacheKey]struct{}),
+		lru:     list.New(),
+	}
+}
+
+// responseCacheKeyFor returns the cache key for req, or false when the query
+// cannot be answered from a shared packed response.
+func responseCacheKeyFor(req *dns.Msg) (responseCacheKey, bool) {
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		return responseCacheKey{}, false
+	}
+	q := req.Question[0]
+	name := normalizeName(q.Name)
+	if strings.ToLower(queryOwnerName(q.Name)) != name {
+		return responseCacheKey{}, false
+	}
+
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+
+	return responseCacheKey{
+		Name:   name,
+		Qtype:  q.Qtype,
+		Qclass: q.Qclass,
+		DO:     do,
+	}, true
+}
+
+func (c *responseCache) get(key responseCacheKey) *responseCacheEntry {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		c.removeLocked(e)
+		return nil
+	}
+	c.lru.MoveToFront(e.elem)
+	return e
+}
+
+// put stores e unless current reports that the snapshot it was built from has
+// already been replaced. The check runs under the cache lock, and store
+// watchers invalidate under the same lock after publishing, so a response
+// built from a stale snapshot can never outlive its invalidation.
+func (c *responseCache) put(e *responseCacheEntry, current func() bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !current() {
+		return
+	}
+	if prev, ok := c.entries[e.key]; ok {
+		c.removeLocked(prev)
+	}
+
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	keys := c.byName[e.key.Name]
+	if keys == nil {
+		keys = make(map[responseCacheKey]struct{}, 1)
+		c.byName[e.key.Name] = keys
+	}
+	keys[e.key] = struct{}{}
+
+	for c.max > 0 && len(c.entries) > c.max {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			break
+		}
+		c.removeLocked(oldest.Value.(*responseCacheEntry))
+	}
+}
+
+// invalidate drops cached responses affected by a store change: every entry
+// for a touched name, and every entry at or below a touched zone apex.
+func (c *responseCache) invalidate(change storeChange) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range change.Names {
+		c.removeNameLocked(name)
+	}
+	if len(change.Zones) == 0 {
+		return
+	}
+	for name := range c.byName {
+		for _, zone := range change.Zones {
+			if dns.IsSubDomain(zone, name) {
+				c.removeNameLocked(name)
+				break
+			}
+		}
+	}
+}
+
+func (c *responseCache) len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *responseCache) removeNameLocked(name string) {
+	for key := range c.byName[name] {
+		if e, ok := c.entries[key]; ok {
+			c.removeLocked(e)
+		}
+	}
+}
+
+func (c *responseCache) removeLocked(e *responseCacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	if keys := c.byName[e.key.Name]; keys != nil {
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.byName, e.key.Name)
+		}
+	}
+}
+
+// newResponseCacheEntry packs resp once per answer ordering. When the answer
+// carries a shuffled A or AAAA RRset, each rotation of that RRset is packed
+// as its own variant so cached answers keep spreading load across members.
+func newResponseCacheEntry(key responseCacheKey, resp *dns.Msg) (*responseCacheEntry, error) {
+	e := &responseCacheEntry{
+		key:     key,
+		rcode:   resp.Rcode,
+		answers: len(resp.Answer),
+	}
+
+	msg := resp.Copy()
+	msg.Compress = true
+	for _, answer := range answerVariants(resp.Answer) {
+		msg.Answer = answer
+		wire, err := msg.Pack()
+		if err != nil {
+			return nil, err
+		}
+		e.variants = append(e.variants, wire)
+	}
+	return e, nil
+}
+
+// render returns a copy of a packed variant patched for req: the message ID,
+// the RD and CD flags copied by SetReply, and the question name so the
+// query's original case is echoed. Answer owner names are compression
+// pointers to the question, so they pick up the same case.
+func (e *responseCacheEntry) render(req *dns.Msg) dnsResult {
+	src := e.variants[0]
+	if len(e.variants) > 1 {
+		src = e.variants[mrand.Intn(len(e.variants))]
+	}
+
+	wire := make([]byte, len(src))
+	copy(wire, src)
+
+	wire[0] = byte(req.Id >> 8)
+	wire[1] = byte(req.Id)
+	wire[2] &^= 0x01
+	if req.RecursionDesired {
+		wire[2] |= 0x01
+	}
+	wire[3] &^= 0x10
+	if req.CheckingDisabled {
+		wire[3] |= 0x10
+	}
+
+	qname := make([]byte, 256)
+	if n, err := dns.PackDomainName(queryOwnerName(req.Question[0].Name), qname, 0, nil, false); err == nil && 12+n <= len(wire) {
+		copy(wire[12:12+n], qname[:n])
+	}
+
+	return dnsResult{Wire: wire, Rcode: e.rcode, Answers: e.answers, Cached: true}
+}
+
+func answerVariants(answer []dns.RR) [][]dns.RR {
+	start, end := shuffledRun(answer)
+	n := end - start
+	if n < 2 {
+		return [][]dns.RR{answer}
+	}
+	if n > maxShuffleVariants {
+		n = maxShuffleVariants
+	}
+
+	out := make([][]dns.RR, 0, n)
+	for r := 0; r < n; r++ {
+		variant := make([]dns.RR, 0, len(answer))
+		variant = append(variant, answer[:start]...)
+		variant = append(variant, answer[start+r:end]...)
+		variant = append(variant, answer[start:start+r]...)
+		variant = append(variant, answer[end:]...)
+		out = append(out, variant)
+	}
+	return out
+}
+
+// shuffledRun returns the bounds of the longest run of consecutive A or AAAA
+// records of the same type, which is the RRset resolveDNS shuffles.
+func shuffledRun(answer []dns.RR) (int, int) {
+	bestStart, bestEnd := 0, 0
+	for i := 0; i < len(answer); {
+		t := answer[i].Header().Rrtype
+		j := i + 1
+		for j < len(answer) && answer[j].Header().Rrtype == t {
+			j++
+		}
+		if (t == dns.TypeA || t == dns.TypeAAAA) && j-i > bestEnd-bestStart {
+			bestStart, bestEnd = i, j
+		}
+		i = j
+	}
+	return bestStart, bestEnd
+}
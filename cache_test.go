@@ -0,0 +1,215 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newCachedTestServer(t *testing.T) *server {
+	t.Helper()
+	s := newTestServer(t)
+	s.cache = newResponseCache(100)
+	s.data.subscribe(s.cache.invalidate)
+
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	return s
+}
+
+func answerMsg(t *testing.T, s *server, qname string, qtype uint16, id uint16) (*dns.Msg, dnsResult) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+	req.Id = id
+
+	res, err := s.answerDNS(req)
+	if err != nil {
+		t.Fatalf("answerDNS: %v", err)
+	}
+	var out dns.Msg
+	if err := out.Unpack(res.Wire); err != nil {
+		t.Fatalf("unpack cached response: %v", err)
+	}
+	return &out, res
+}
+
+func TestResponseCacheHitPatchesIDAndCase(t *testing.T) {
+	s := newCachedTestServer(t)
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1})
+
+	first, res := answerMsg(t, s, "app.example.com.", dns.TypeA, 100)
+	if res.Cached {
+		t.Fatal("first query should miss the cache")
+	}
+	if first.Id != 100 || len(first.Answer) != 1 {
+		t.Fatalf("unexpected first response: %v", first)
+	}
+
+	second, res := answerMsg(t, s, "ApP.ExAmPlE.CoM.", dns.TypeA, 200)
+	if !res.Cached {
+		t.Fatal("second query should hit the cache")
+	}
+	if second.Id != 200 {
+		t.Fatalf("expected patched id 200, got %d", second.Id)
+	}
+	if second.Question[0].Name != "ApP.ExAmPlE.CoM." || second.Answer[0].Header().Name != "ApP.ExAmPlE.CoM." {
+		t.Fatalf("expected query case echoed, got q=%s owner=%s", second.Question[0].Name, second.Answer[0].Header().Name)
+	}
+	if !second.Authoritative || !second.Response {
+		t.Fatal("cached response lost header flags")
+	}
+}
+
+func TestResponseCacheInvalidatedOnRecordChange(t *testing.T) {
+	s := newCachedTestServer(t)
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1})
+	s.data.setRecord(aRecord{Name: "other.example.com", Zone: "example.com", IP: "198.51.100.20", TTL: 25, Version: 1})
+
+	answerMsg(t, s, "app.example.com.", dns.TypeA, 1)
+	answerMsg(t, s, "other.example.com.", dns.TypeA, 1)
+	answerMsg(t, s, "missing.example.com.", dns.TypeA, 1)
+	if got := s.cache.len(); got != 3 {
+		t.Fatalf("expected 3 cached responses, got %d", got)
+	}
+
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.11", TTL: 25, Version: 2})
+	if got := s.cache.len(); got != 2 {
+		t.Fatalf("expected only the changed name to be invalidated, got %d entries", got)
+	}
+
+	msg, res := answerMsg(t, s, "app.example.com.", dns.TypeA, 2)
+	if res.Cached {
+		t.Fatal("changed name should not be served from cache")
+	}
+	if a := msg.Answer[0].(*dns.A); a.A.String() != "198.51.100.11" {
+		t.Fatalf("expected updated answer, got %s", a.A)
+	}
+
+	s.data.addRecord(aRecord{Name: "missing.example.com", Zone: "example.com", IP: "198.51.100.30", TTL: 25, Version: 2})
+	msg, _ = answerMsg(t, s, "missing.example.com.", dns.TypeA, 3)
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 1 {
+		t.Fatalf("expected new record to replace cached NXDOMAIN, got rcode=%d answers=%d", msg.Rcode, len(msg.Answer))
+	}
+}
+
+func TestResponseCacheInvalidatedOnZoneChange(t *testing.T) {
+	s := newCachedTestServer(t)
+	answerMsg(t, s, "missing.example.com.", dns.TypeA, 1)
+	answerMsg(t, s, "outside.example.net.", dns.TypeA, 1)
+
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 2})
+	if got := s.cache.len(); got != 1 {
+		t.Fatalf("expected zone change to invalidate only its names, got %d entries", got)
+	}
+
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1})
+	msg, _ := answerMsg(t, s, "outside.example.net.", dns.TypeA, 2)
+	if msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN once zone exists, got %d", msg.Rcode)
+	}
+}
+
+func TestResponseCacheKeepsShuffling(t *testing.T) {
+	s := newCachedTestServer(t)
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"} {
+		s.data.addRecord(aRecord{Name: "pool.example.com", Zone: "example.com", IP: ip, TTL: 25, Version: 1})
+	}
+
+	firsts := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		msg, _ := answerMsg(t, s, "pool.example.com.", dns.TypeA, uint16(i))
+		if len(msg.Answer) != 4 {
+			t.Fatalf("expected 4 answers, got %d", len(msg.Answer))
+		}
+		firsts[msg.Answer[0].(*dns.A).A.String()] = true
+	}
+	if len(firsts) < 2 {
+		t.Fatalf("expected cached answers to vary in order, saw first=%v", firsts)
+	}
+}
+
+func TestResponseCacheRejectsStaleFill(t *testing.T) {
+	c := newResponseCache(10)
+	key := responseCacheKey{Name: "app.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	resp := new(dns.Msg)
+	resp.SetQuestion(key.Name, key.Qtype)
+
+	e, err := newResponseCacheEntry(key, resp)
+	if err != nil {
+		t.Fatalf("newResponseCacheEntry: %v", err)
+	}
+	c.put(e, func() bool { return false })
+	if c.get(key) != nil {
+		t.Fatal("entry built from a replaced snapshot must not be cached")
+	}
+}
+
+func TestResponseCacheEvictsOldest(t *testing.T) {
+	c := newResponseCache(2)
+	for _, name := range []string{"a.example.com.", "b.example.com.", "c.example.com."} {
+		key := responseCacheKey{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		resp := new(dns.Msg)
+		resp.SetQuestion(name, dns.TypeA)
+		e, err := newResponseCacheEntry(key, resp)
+		if err != nil {
+			t.Fatalf("newResponseCacheEntry: %v", err)
+		}
+		c.put(e, func() bool { return true })
+	}
+	if c.len() != 2 {
+		t.Fatalf("expected cache bounded at 2, got %d", c.len())
+	}
+	if c.get(responseCacheKey{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}) != nil {
+		t.Fatal("expected oldest entry to be evicted")
+	}
+}
+
+func BenchmarkAnswerDNS(b *testing.B) {
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := &server{data: newStore()}
+			if cached {
+				s.cache = newResponseCache(100)
+				s.data.subscribe(s.cache.invalidate)
+			}
+			s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1})
+			for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+				s.data.addRecord(aRecord{Name: "pool.example.com", Zone: "example.com", IP: ip, TTL: 25, Version: 1})
+			}
+			req := new(dns.Msg)
+			req.SetQuestion("pool.example.com.", dns.TypeA)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.answerDNS(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseCacheHonoursRecordExpiry(t *testing.T) {
+	s := newCachedTestServer(t)
+	s.data.setRecord(aRecord{Name: "tmp.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+
+	if _, res := answerMsg(t, s, "tmp.example.com.", dns.TypeA, 1); res.Answers != 1 {
+		t.Fatalf("expected live answer, got %d answers", res.Answers)
+	}
+	if _, res := answerMsg(t, s, "tmp.example.com.", dns.TypeA, 2); !res.Cached {
+		t.Fatal("second query should hit the cache")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	msg, res := answerMsg(t, s, "tmp.example.com.", dns.TypeA, 3)
+	if res.Cached || msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected uncached NXDOMAIN after expiry, cached=%t rcode=%d", res.Cached, msg.Rcode)
+	}
+}
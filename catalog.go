@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	catalogStateKey = "catalog"
+	catalogVersion  = "2"
+	catalogTTL      = 3600
+)
+
+// newCatalogZone returns an RFC 9432 catalog zone named name. Its members are
+// the configured zones; it is not itself stored as a zone.
+func newCatalogZone(name, scheme string) *catalogZone {
+	c := &catalogZone{name: normalizeName(name), scheme: scheme}
+	c.snap.Store(&catalogSnapshot{members: map[string]struct{}{}})
+	return c
+}
+
+// owns reports whether name is at or below the catalog apex. It is nil-safe
+// so callers need not check whether catalogs are enabled.
+func (c *catalogZone) owns(name string) bool {
+	return c != nil && dns.IsSubDomain(c.name, name)
+}
+
+// update recomputes the member list from view and advances the serial when
+// it changed. It reports whether the catalog changed.
+func (c *catalogZone) update(view *storeView, now time.Time) (catalogSnapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cur := c.snap.Load()
+	members := make([]string, 0, len(view.zones))
+	for zone := range view.zones {
+		if zone != c.name {
+			members = append(members, zone)
+		}
+	}
+	sort.Strings(members)
+	if cur.Serial != 0 && slices.Equal(cur.Members, members) {
+		return *cur, false
+	}
+
+	next := &catalogSnapshot{
+		Serial:  nextSerial(c.scheme, cur.Serial, now),
+		Members: members,
+		members: make(map[string]struct{}, len(members)),
+	}
+	for _, zone := range members {
+		next.members[zone] = struct{}{}
+	}
+	c.snap.Store(next)
+	return *next, true
+}
+
+// restore installs a snapshot saved before a restart.
+func (c *catalogZone) restore(v string) error {
+	var snap catalogSnapshot
+	if err := json.Unmarshal([]byte(v), &snap); err != nil {
+		return fmt.Errorf("decode catalog state: %w", err)
+	}
+	snap.members = make(map[string]struct{}, len(snap.Members))
+	for _, zone := range snap.Members {
+		snap.members[zone] = struct{}{}
+	}
+	c.snap.Store(&snap)
+	return nil
+}
+
+// stale reports whether any of zones has a different membership in view
+// than in the published catalog, so unrelated serial bumps skip the rebuild.
+func (c *catalogZone) stale(view *storeView, zones []string) bool {
+	snap := c.snap.Load()
+	for _, zone := range zones {
+		if zone == c.name {
+			continue
+		}
+		_, inView := view.zones[zone]
+		_, inCatalog := snap.members[zone]
+		if inView != inCatalog {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *catalogZone) soa() *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: c.name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: catalogTTL},
+		Ns:      "invalid.",
+		Mbox:    "invalid.",
+		Serial:  c.snap.Load().Serial,
+		Refresh: soaDefaultRefresh,
+		Retry:   soaDefaultRetry,
+		Expire:  soaDefaultExpire,
+		Minttl:  catalogTTL,
+	}
+}
+
+// records returns the whole catalog zone, SOA first: the apex NS, the
+// schema version and one PTR per member under zones.<catalog>.
+func (c *catalogZone) records() []dns.RR {
+	snap := c.snap.Load()
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: catalogTTL}
+	}
+
+	out := make([]dns.RR, 0, len(snap.Members)+3)
+	out = append(out,
+		c.soa(),
+		&dns.NS{Hdr: hdr(c.name, dns.TypeNS), Ns: "invalid."},
+		&dns.TXT{Hdr: hdr("version."+c.name, dns.TypeTXT), Txt: []string{catalogVersion}},
+	)
+	for _, zone := range snap.Members {
+		out = append(out, &dns.PTR{Hdr: hdr(catalogMemberLabel(zone)+".zones."+c.name, dns.TypePTR), Ptr: zone})
+	}
+	return out
+}
+
+// catalogMemberLabel is the RFC 9432 unique member label: a hash of the
+// zone name, so every node derives the same label.
+func catalogMemberLabel(zone string) string {
+	sum := sha1.Sum([]byte(normalizeName(zone)))
+	return hex.EncodeToString(sum[:])
+}
+
+// answer resolves a query inside the catalog zone. Names that only own
+// records below them, such as zones.<catalog>, are empty non-terminals and
+// get NODATA rather than NXDOMAIN (RFC 8020).
+func (c *catalogZone) answer(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	if len(req.Question) == 0 {
+		return resp
+	}
+
+	q := req.Question[0]
+	name := normalizeName(q.Name)
+	exists := false
+	for _, rr := range c.records() {
+		if rr.Header().Name != name {
+			if dns.IsSubDomain(name, rr.Header().Name) {
+				exists = true
+			}
+			continue
+		}
+		exists = true
+		if q.Qtype == dns.TypeANY || q.Qtype == rr.Header().Rrtype {
+			resp.Answer = append(resp.Answer, rr)
+		}
+	}
+	if len(resp.Answer) == 0 {
+		if !exists {
+			resp.Rcode = dns.RcodeNameError
+		}
+		resp.Ns = append(resp.Ns, c.soa())
+	}
+	return resp
+}
+
+// restoreCatalog loads the catalog saved before a restart and reconciles it
+// with the loaded zones, bumping the serial only if membership changed.
+func (s *server) restoreCatalog() error {
+	if s.catalog == nil {
+		return nil
+	}
+	s.writes.flush()
+	v, err := s.persist.loadNodeState(catalogStateKey)
+	if err != nil {
+		return err
+	}
+	if v != "" {
+		if err := s.catalog.restore(v); err != nil {
+			return err
+		}
+	}
+	s.refreshCatalog(s.data.view(), time.Now().UTC())
+	return nil
+}
+
+// refreshCatalog rebuilds the catalog from view and queues a save when
+// membership changed. It runs under the store lock when called from a
+// watcher, so the save goes through the write queue, which keeps saves in
+// catalog serial order.
+func (s *server) refreshCatalog(view *storeView, now time.Time) bool {
+	snap, changed := s.catalog.update(view, now)
+	if !changed {
+		return false
+	}
+	s.writes.enqueue(func() {
+		b, err := json.Marshal(snap)
+		if err == nil {
+			err = s.persist.saveNodeState(catalogStateKey, string(b), now)
+		}
+		if err != nil {
+			s.persistFailed("node_state", err)
+		}
+	})
+	return true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newCatalogTestServer(t *testing.T) *server {
+	t.Helper()
+	s := newTestServer(t)
+	s.cfg.CatalogZone = "catalog.invalid."
+	s.catalog = newCatalogZone(s.cfg.CatalogZone, serialSchemeCounter)
+	if err := s.restoreCatalog(); err != nil {
+		t.Fatalf("restoreCatalog: %v", err)
+	}
+	s.data.subscribe(s.handleZoneChange)
+	return s
+}
+
+func catalogMembers(c *catalogZone) map[string]string {
+	out := make(map[string]string)
+	for _, rr := range c.records() {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			out[ptr.Ptr] = ptr.Hdr.Name
+		}
+	}
+	return out
+}
+
+func TestCatalogTracksZones(t *testing.T) {
+	s := newCatalogTestServer(t)
+	r := s.newRouter()
+	initial := s.catalog.snap.Load().Serial
+	if initial == 0 {
+		t.Fatal("expected an initial catalog serial")
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/zones/example.net", strings.NewReader(`{"propagate":false}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for zone put, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	members := catalogMembers(s.catalog)
+	want := catalogMemberLabel("example.net.") + ".zones.catalog.invalid."
+	if members["example.net."] != want {
+		t.Fatalf("expected example.net member at %s, got %v", want, members)
+	}
+	afterPut := s.catalog.snap.Load().Serial
+	if afterPut != initial+1 {
+		t.Fatalf("expected catalog serial %d, got %d", initial+1, afterPut)
+	}
+
+	// Zones learned from peers are members too.
+	ev := syncEvent{OriginNode: "peer", Op: "zone", Version: 1, EventTime: time.Now().UTC(),
+		ZoneConfig: &zoneConfig{Zone: "example.org.", NS: []string{"ns1.example.org."}, SOATTL: 60, Serial: 5}}
+	body, _ := json.Marshal(ev)
+	req = httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+	req.Header.Set("X-Sync-Token", "sync-token")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for sync zone, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, ok := catalogMembers(s.catalog)["example.org."]; !ok {
+		t.Fatal("expected synced zone in catalog")
+	}
+	afterSync := s.catalog.snap.Load().Serial
+	if afterSync != afterPut+1 {
+		t.Fatalf("expected catalog serial %d, got %d", afterPut+1, afterSync)
+	}
+
+	// Record changes bump the member zone but leave the catalog alone.
+	req = httptest.NewRequest(http.MethodPut, "/v1/records/app.example.net", strings.NewReader(`{"ip":"198.51.100.5","zone":"example.net"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for record put, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if got := s.catalog.snap.Load().Serial; got != afterSync {
+		t.Fatalf("expected catalog serial to stay %d, got %d", afterSync, got)
+	}
+}
+
+func TestCatalogRestoreKeepsSerial(t *testing.T) {
+	s := newCatalogTestServer(t)
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"ns1.example.net"}, SOATTL: 60, Serial: 1, UpdatedAt: time.Now().UTC()})
+	want := s.catalog.snap.Load().Serial
+
+	s.catalog = newCatalogZone("catalog.invalid.", serialSchemeCounter)
+	if err := s.restoreCatalog(); err != nil {
+		t.Fatalf("restoreCatalog: %v", err)
+	}
+	if got := s.catalog.snap.Load().Serial; got != want {
+		t.Fatalf("expected restored serial %d, got %d", want, got)
+	}
+}
+
+func TestCatalogAnswers(t *testing.T) {
+	s := newCatalogTestServer(t)
+
+	req := new(dns.Msg)
+	req.SetQuestion("version.catalog.invalid.", dns.TypeTXT)
+	resp := s.resolveDNS(req)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.TXT).Txt[0] != catalogVersion {
+		t.Fatalf("expected catalog version TXT, got %v", resp.Answer)
+	}
+
+	req.SetQuestion("missing.catalog.invalid.", dns.TypeA)
+	resp = s.resolveDNS(req)
+	if resp.Rcode != dns.RcodeNameError || len(resp.Ns) != 1 {
+		t.Fatalf("expected NXDOMAIN with SOA, got rcode=%d ns=%v", resp.Rcode, resp.Ns)
+	}
+
+	// zones.<catalog> only has member records below it.
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"ns1.example.net"}, SOATTL: 60, Serial: 1, UpdatedAt: time.Now().UTC()})
+	req.SetQuestion("zones.catalog.invalid.", dns.TypePTR)
+	resp = s.resolveDNS(req)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("expected NODATA with SOA for empty non-terminal, got rcode=%d answer=%v ns=%v", resp.Rcode, resp.Answer, resp.Ns)
+	}
+}
+
+func TestHTTPZoneRejectsCatalogName(t *testing.T) {
+	s := newCatalogTestServer(t)
+	r := s.newRouter()
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/zones/zones.catalog.invalid", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", resp.Code)
+	}
+}
+
+func TestCatalogDropsDeletedZones(t *testing.T) {
+	s := newCatalogTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"ns1.example.net"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	before := s.catalog.snap.Load().Serial
+
+	s.deleteZone(changeAuthor{}, "example.net", 2, now, false, nil)
+	if _, ok := catalogMembers(s.catalog)["example.net."]; ok {
+		t.Fatal("expected deleted zone to leave the catalog")
+	}
+	if got := s.catalog.snap.Load().Serial; got != before+1 {
+		t.Fatalf("expected catalog serial %d, got %d", before+1, got)
+	}
+}
+
+func TestZonesExportIncludesCatalog(t *testing.T) {
+	s := newCatalogTestServer(t)
+	h := s.newRouter()
+	apiRequest(t, h, http.MethodPut, "/v1/zones/example.net", `{"ns":["ns1.example.net."],"propagate":false}`, nil)
+
+	rr := apiRequest(t, h, http.MethodGet, "/v1/zones/export", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	tr := tar.NewReader(bytes.NewReader(rr.Body.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			t.Fatal("expected catalog.invalid.zone in the export")
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		if hdr.Name != "catalog.invalid.zone" {
+			continue
+		}
+		body, _ := io.ReadAll(tr)
+		if !strings.Contains(string(body), catalogMemberLabel("example.net.")+".zones.catalog.invalid.") {
+			t.Fatalf("expected example.net in exported catalog, got:\n%s", body)
+		}
+		return
+	}
+}
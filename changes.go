@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBatchChanges bounds the operations in one POST /v1/changes request.
+const maxBatchChanges = 1000
+
+// handleChanges applies a list of record operations atomically. Every
+// operation is validated before any is applied; the records, any zones they
+// create and the zone serial bumps are then published in one store
+// transaction, persisted in one SQLite transaction and replicated as one
+// batch event.
+func (s *server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	var req changesRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(req.Changes) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "changes is required"})
+		return
+	}
+	if len(req.Changes) > maxBatchChanges {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d changes per batch", maxBatchChanges)})
+		return
+	}
+
+	now := time.Now().UTC()
+	version := now.UnixNano()
+	changes := make([]recordChange, 0, len(req.Changes))
+	var guards []precondition
+	var guarded []int
+	for i, c := range req.Changes {
+		rc, err := s.buildChange(c, version, now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("change %d: %v", i, err)})
+			return
+		}
+		name, recordType := changeTarget(rc)
+		if t := requestAPIToken(r); !t.allowsName(name) || !t.allowsZone(rc.Zone) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": fmt.Sprintf("change %d: token may not change %s in zone %s", i, name, rc.Zone), "change": i})
+			return
+		}
+		changes = append(changes, rc)
+		if match := parseETags(c.IfMatch); match != nil {
+			guards = append(guards, precondition{Name: name, Type: recordType, Match: match})
+			guarded = append(guarded, i)
+		}
+		if match := parseETags(c.IfZoneMatch); match != nil {
+			guards = append(guards, precondition{Zone: rc.Zone, Match: match})
+			guarded = append(guarded, i)
+		}
+	}
+
+	// Zones the batch writes into are created in the same transaction.
+	view := s.data.view()
+	newZones := make(map[string]zoneConfig)
+	for i, c := range changes {
+		if c.Op != "set" && c.Op != "add" {
+			continue
+		}
+		if _, ok := view.getZone(c.Zone); ok {
+			continue
+		}
+		if _, ok := newZones[c.Zone]; ok {
+			continue
+		}
+		z := zoneConfig{Zone: c.Zone}
+		if err := s.ensureZoneDefaults(&z, now); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("change %d: %v", i, err)})
+			return
+		}
+		newZones[c.Zone] = z
+	}
+
+	var zones []zoneConfig
+	etags := make([]string, len(changes))
+	applied, met := s.data.applyChanges(s.requestAuthor(r, "batch"), guards, changes, func(tx *storeTxn, applied []bool) {
+		for i, c := range changes {
+			etags[i] = tx.view.rrsetETag(changeTarget(c))
+		}
+		touched := make(map[string]struct{})
+		for _, z := range newZones {
+			if tx.upsertZone(z) {
+				touched[normalizeName(z.Zone)] = struct{}{}
+			}
+		}
+		for zone := range changedZones(changes, applied) {
+			if _, ok := tx.bumpZoneSerial(zone, now); ok {
+				touched[zone] = struct{}{}
+			}
+		}
+		zones = tx.view.zoneConfigs(touched)
+	})
+	if !met {
+		resp := map[string]any{"error": "precondition failed"}
+		view := s.data.view()
+		if j := failedGuard(view, guards); j >= 0 {
+			resp["error"] = fmt.Sprintf("change %d: precondition failed", guarded[j])
+			resp["change"] = guarded[j]
+			resp["etag"] = guards[j].etag(view)
+		}
+		writeJSON(w, http.StatusPreconditionFailed, resp)
+		return
+	}
+	if err := s.persist.applyChanges(changes, applied, zones); err != nil {
+		s.persistFailed("apply_changes", err)
+	}
+
+	results := make([]map[string]any, 0, len(changes))
+	var replicate []recordChange
+	for i, c := range changes {
+		name, recordType := changeTarget(c)
+		results = append(results, map[string]any{"op": c.Op, "name": name, "type": recordType, "changed": applied[i], "etag": etags[i]})
+		if applied[i] {
+			replicate = append(replicate, c)
+		}
+	}
+	if zones == nil {
+		zones = []zoneConfig{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version": version,
+		"applied": len(replicate),
+		"results": results,
+		"zones":   zones,
+	})
+
+	if shouldPropagate(req.Propagate) && len(replicate) > 0 {
+		go s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "batch", Version: version, EventTime: now, Changes: replicate, Zones: zones, Actor: requestActor(r)})
+	}
+}
+
+// buildChange validates one batch operation the way the single-record
+// endpoints do.
+func (s *server) buildChange(c changeRequest, version int64, now time.Time) (recordChange, error) {
+	op := strings.ToLower(strings.TrimSpace(c.Op))
+	name := normalizeName(c.Name)
+	if name == "." {
+		return recordChange{}, errors.New("name is required")
+	}
+
+	switch op {
+	case "set", "add", "remove":
+		rec, err := s.buildRecordFromRequest(name, upsertRecordRequest{
+			IP:        c.IP,
+			Type:      c.Type,
+			Text:      c.Text,
+			Target:    c.Target,
+			Priority:  c.Priority,
+			TTL:       c.TTL,
+			Zone:      c.Zone,
+			ExpiresAt: c.ExpiresAt,
+			LeaseTTL:  c.LeaseTTL,
+		}, now)
+		if err != nil {
+			return recordChange{}, err
+		}
+		rec.Version = version
+		return recordChange{Op: op, Record: &rec, Zone: rec.Zone, Version: version}, nil
+	case "delete":
+		recordType := strings.ToUpper(strings.TrimSpace(c.Type))
+		if !validDeleteType(recordType) {
+			return recordChange{}, errors.New("delete type must be A, AAAA, TXT, CNAME or MX")
+		}
+		zone := ""
+		if z, ok := s.data.bestZone(name); ok {
+			zone = z.Zone
+		}
+		return recordChange{Op: op, Name: name, Type: recordType, Zone: zone, Version: version}, nil
+	}
+	return recordChange{}, errors.New("op must be set, add, remove or delete")
+}
+
+// applySyncBatch applies a replicated batch atomically. The origin's zone
+// configs are adopted where they are newer than the local ones, and zones
+// missing here are created from them; a batch may carry zones alone.
+// Changes for zones deleted here after the origin made them are dropped.
+// An invalid change rejects the whole batch.
+func (s *server) applySyncBatch(author changeAuthor, ev syncEvent) (int, error) {
+	if len(ev.Changes) == 0 && len(ev.Zones) == 0 {
+		return 0, errors.New("changes or zones required for batch op")
+	}
+	origin := make(map[string]*zoneConfig, len(ev.Zones))
+	for i := range ev.Zones {
+		origin[normalizeName(ev.Zones[i].Zone)] = &ev.Zones[i]
+	}
+
+	changes := make([]recordChange, 0, len(ev.Changes))
+	for i, c := range ev.Changes {
+		if c.Version == 0 {
+			c.Version = ev.Version
+		}
+		switch c.Op {
+		case "set", "add", "remove":
+			if c.Record == nil {
+				return 0, fmt.Errorf("change %d: record required for %s", i, c.Op)
+			}
+			rec, err := s.normalizeRecordInput(*c.Record)
+			if err != nil {
+				return 0, fmt.Errorf("change %d: %v", i, err)
+			}
+			rec.Version = c.Version
+			if rec.TTL == 0 {
+				rec.TTL = s.cfg.DefaultTTL
+			}
+			rec.Source = ev.OriginNode
+			rec.UpdatedAt = ev.EventTime
+			c.Record = &rec
+			c.Zone = rec.Zone
+		case "delete":
+			c.Name = normalizeName(c.Name)
+			c.Type = strings.ToUpper(strings.TrimSpace(c.Type))
+			if !validDeleteType(c.Type) {
+				return 0, fmt.Errorf("change %d: delete type must be A, AAAA, TXT, CNAME or MX", i)
+			}
+			c.Zone = normalizeName(c.Zone)
+		default:
+			return 0, fmt.Errorf("change %d: unsupported op", i)
+		}
+		if s.syncZoneDeleted(origin[c.Zone]) {
+			continue
+		}
+		changes = append(changes, c)
+	}
+
+	now := time.Now().UTC()
+	var zones []zoneConfig
+	applied, _ := s.data.applyChanges(author, nil, changes, func(tx *storeTxn, applied []bool) {
+		touched := make(map[string]struct{})
+		// The origin's zone configs replace local ones they are newer than.
+		for _, z := range ev.Zones {
+			z.Zone = normalizeName(z.Zone)
+			if cur, ok := tx.view.zones[z.Zone]; ok && !serialGreater(z.Serial, cur.Serial) {
+				continue
+			}
+			applySOADefaults(&z)
+			if tx.upsertZone(z) {
+				touched[z.Zone] = struct{}{}
+			}
+		}
+		// Zones the origin sent no config for bump under their own scheme.
+		for zone := range changedZones(changes, applied) {
+			if origin[zone] != nil {
+				continue
+			}
+			if _, ok := tx.bumpZoneSerial(zone, now); ok {
+				touched[zone] = struct{}{}
+			}
+		}
+		zones = tx.view.zoneConfigs(touched)
+	})
+	if err := s.persist.applyChanges(changes, applied, zones); err != nil {
+		s.persistFailed("apply_changes", err)
+	}
+
+	n := 0
+	for _, ok := range applied {
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// commitChange applies one record change and its zone serial bump in one
+// store transaction, provided every guard holds, and persists both under
+// op. It returns the updated zone config when the record changed, the
+// RRset's ETag afterwards, and false with the current ETag when a guard
+// failed. The change is logged as made by r's caller.
+func (s *server) commitChange(r *http.Request, op string, c recordChange, guards []precondition, now time.Time) (*zoneConfig, string, bool) {
+	var zones []zoneConfig
+	var etag string
+	changes := []recordChange{c}
+	applied, met := s.data.applyChanges(s.requestAuthor(r, op), guards, changes, func(tx *storeTxn, applied []bool) {
+		etag = tx.view.rrsetETag(changeTarget(c))
+		if !applied[0] || c.Zone == "" {
+			return
+		}
+		if z, ok := tx.bumpZoneSerial(c.Zone, now); ok {
+			zones = append(zones, z)
+		}
+	})
+	if !met {
+		view := s.data.view()
+		if j := failedGuard(view, guards); j >= 0 {
+			etag = guards[j].etag(view)
+		}
+		return nil, etag, false
+	}
+	if applied[0] {
+		if err := s.persist.applyChanges(changes, applied, zones); err != nil {
+			s.persistFailed(op, err)
+		}
+	}
+	if len(zones) == 0 {
+		return nil, etag, true
+	}
+	return &zones[0], etag, true
+}
+
+// changeTarget returns the RRset a change writes: the record's name and
+// type, or the name and optional type of a delete.
+func changeTarget(c recordChange) (string, string) {
+	if c.Record != nil {
+		return c.Record.Name, c.Record.Type
+	}
+	return c.Name, c.Type
+}
+
+// changedZones returns the zones of the changes that were applied.
+func changedZones(changes []recordChange, applied []bool) map[string]struct{} {
+	out := make(map[string]struct{})
+	for i, c := range changes {
+		if applied[i] && c.Zone != "" && c.Zone != "." {
+			out[normalizeName(c.Zone)] = struct{}{}
+		}
+	}
+	return out
+}
+
+func validDeleteType(recordType string) bool {
+	switch recordType {
+	case "", "A", "AAAA", "TXT", "CNAME", "MX":
+		return true
+	}
+	return false
+}
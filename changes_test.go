@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestChangesApplyAtomically(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	s.data.setRecord(aRecord{Name: "old.example.com.", Type: "A", IP: "192.0.2.1", TTL: 20, Zone: "example.com.", Version: 1})
+
+	rr := apiRequest(t, h, http.MethodPost, "/v1/changes?propagate=false", `{"propagate":false,"changes":[
+		{"op":"set","name":"app.example.com","ip":"192.0.2.10"},
+		{"op":"add","name":"app.example.com","type":"TXT","text":"hello"},
+		{"op":"set","name":"www.example.net","ip":"198.51.100.1","zone":"example.net."},
+		{"op":"delete","name":"old.example.com","type":"A"}
+	]}`, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Version int64        `json:"version"`
+		Applied int          `json:"applied"`
+		Zones   []zoneConfig `json:"zones"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Applied != 4 || len(resp.Zones) != 2 {
+		t.Fatalf("unexpected response: %s", rr.Body.String())
+	}
+
+	view := s.data.view()
+	if got := view.getRecords("app.example.com.", dns.TypeANY); len(got) != 2 {
+		t.Fatalf("expected A and TXT for app, got %+v", got)
+	}
+	for _, rec := range view.getRecords("app.example.com.", dns.TypeANY) {
+		if rec.Version != resp.Version {
+			t.Fatalf("expected batch version on every record, got %+v", rec)
+		}
+	}
+	if view.hasName("old.example.com.") {
+		t.Fatal("expected old.example.com deleted")
+	}
+	if _, ok := view.getZone("example.net."); !ok {
+		t.Fatal("expected example.net created by the batch")
+	}
+
+	// One serial bump per zone regardless of the number of changes in it.
+	z, _ := view.getZone("example.com.")
+	rr = apiRequest(t, h, http.MethodPost, "/v1/changes?propagate=false", `{"changes":[
+		{"op":"set","name":"a.example.com","ip":"192.0.2.2"},
+		{"op":"set","name":"b.example.com","ip":"192.0.2.3"}
+	]}`, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	next, _ := s.data.getZone("example.com.")
+	if next.Serial != nextSerial(z.SerialScheme, z.Serial, time.Now().UTC()) {
+		t.Fatalf("expected a single serial bump from %d, got %d", z.Serial, next.Serial)
+	}
+
+	reloaded := newStore()
+	if err := s.persist.loadIntoStore(reloaded); err != nil {
+		t.Fatalf("loadIntoStore: %v", err)
+	}
+	if got := reloaded.getRecords("app.example.com.", dns.TypeANY); len(got) != 2 {
+		t.Fatalf("expected batch persisted, got %+v", got)
+	}
+	if reloaded.hasName("old.example.com.") {
+		t.Fatal("expected delete persisted")
+	}
+	if rz, ok := reloaded.getZone("example.com."); !ok || rz.Serial != next.Serial {
+		t.Fatalf("expected persisted serial %d, got %+v", next.Serial, rz)
+	}
+}
+
+func TestChangesRejectWholeBatch(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+
+	rr := apiRequest(t, h, http.MethodPost, "/v1/changes?propagate=false", `{"changes":[
+		{"op":"set","name":"ok.example.com","ip":"192.0.2.10"},
+		{"op":"set","name":"bad.example.com","ip":"not-an-ip"}
+	]}`, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if s.data.hasName("ok.example.com.") {
+		t.Fatal("expected nothing applied when one change is invalid")
+	}
+
+	for _, body := range []string{
+		`{"changes":[]}`,
+		`{"changes":[{"op":"rename","name":"x.example.com"}]}`,
+		`{"changes":[{"op":"delete","name":"x.example.com","type":"SRV"}]}`,
+	} {
+		if rr := apiRequest(t, h, http.MethodPost, "/v1/changes?propagate=false", body, nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestSyncBatchEvent(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	now := time.Now().UTC()
+	s.data.setRecord(aRecord{Name: "old.example.com.", Type: "A", IP: "192.0.2.1", TTL: 20, Zone: "example.com.", Version: 1})
+
+	origin := zoneConfig{Zone: "example.net.", NS: []string{"ns1.example.net."}, Serial: 42}
+	ev := syncEvent{
+		OriginNode: "peer",
+		Op:         "batch",
+		Version:    10,
+		EventTime:  now,
+		Changes: []recordChange{
+			{Op: "set", Record: &aRecord{Name: "www.example.net", Type: "A", IP: "198.51.100.1", Zone: "example.net."}},
+			{Op: "add", Record: &aRecord{Name: "www.example.net", Type: "TXT", Text: "hi", Zone: "example.net."}},
+			{Op: "delete", Name: "old.example.com.", Type: "A", Zone: "example.com."},
+		},
+		Zones: []zoneConfig{origin},
+	}
+	body, _ := json.Marshal(ev)
+	req := httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+	req.Header.Set("X-Sync-Token", "sync-token")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	view := s.data.view()
+	recs := view.getRecords("www.example.net.", dns.TypeANY)
+	if len(recs) != 2 {
+		t.Fatalf("expected batch records applied, got %+v", recs)
+	}
+	for _, rec := range recs {
+		if rec.Version != 10 || rec.Source != "peer" || rec.TTL != 20 {
+			t.Fatalf("unexpected synced record: %+v", rec)
+		}
+	}
+	if view.hasName("old.example.com.") {
+		t.Fatal("expected synced delete applied")
+	}
+	if z, ok := view.getZone("example.net."); !ok || z.Serial != 42 {
+		t.Fatalf("expected origin zone config adopted, got %+v", z)
+	}
+
+	// A zones-only batch, like an import that changed no records, replaces
+	// an existing zone's config when its serial is newer and is ignored
+	// otherwise.
+	sendZones := func(cfg zoneConfig) {
+		t.Helper()
+		body, _ := json.Marshal(syncEvent{OriginNode: "peer", Op: "batch", Version: 12, EventTime: now, Zones: []zoneConfig{cfg}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+		req.Header.Set("X-Sync-Token", "sync-token")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200 for zones-only batch, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	sendZones(zoneConfig{Zone: "example.net.", NS: []string{"ns2.example.net."}, Serial: 43})
+	sendZones(zoneConfig{Zone: "example.net.", NS: []string{"ns3.example.net."}, Serial: 41})
+	if z, _ := s.data.getZone("example.net."); z.Serial != 43 || len(z.NS) != 1 || z.NS[0] != "ns2.example.net." {
+		t.Fatalf("expected newer zone config adopted and stale one ignored, got %+v", z)
+	}
+
+	// A batch with an invalid change is rejected without applying anything.
+	bad := syncEvent{OriginNode: "peer", Op: "batch", Version: 11, EventTime: now, Changes: []recordChange{
+		{Op: "set", Record: &aRecord{Name: "new.example.com", Type: "A", IP: "192.0.2.5"}},
+		{Op: "set"},
+	}}
+	body, _ = json.Marshal(bad)
+	req = httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+	req.Header.Set("X-Sync-Token", "sync-token")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if s.data.hasName("new.example.com.") {
+		t.Fatal("expected invalid batch not applied")
+	}
+}
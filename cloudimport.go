@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Import formats accepted by POST /v1/zones/{zone}/import.
+const (
+	importFormatZone       = "zone"
+	importFormatCloudflare = "cloudflare"
+	importFormatRoute53    = "route53"
+)
+
+// cloudflareTTLAuto is the TTL Cloudflare reports for "automatic".
+const cloudflareTTLAuto = 1
+
+// cloudflareRecord is one entry of Cloudflare's DNS records API
+// (GET /zones/{id}/dns_records) or its JSON export.
+type cloudflareRecord struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Content  string  `json:"content"`
+	TTL      uint32  `json:"ttl"`
+	Priority *uint16 `json:"priority"`
+	Proxied  bool    `json:"proxied"`
+}
+
+// route53RecordSet is one entry of `aws route53 list-resource-record-sets`.
+type route53RecordSet struct {
+	Name            string `json:"Name"`
+	Type            string `json:"Type"`
+	TTL             uint32 `json:"TTL"`
+	SetIdentifier   string `json:"SetIdentifier"`
+	ResourceRecords []struct {
+		Value string `json:"Value"`
+	} `json:"ResourceRecords"`
+	AliasTarget *struct {
+		DNSName string `json:"DNSName"`
+	} `json:"AliasTarget"`
+}
+
+// parseImport parses body in format for zone.
+func parseImport(format, zone string, r io.Reader, defaultTTL uint32) (zoneFile, error) {
+	switch format {
+	case importFormatZone:
+		return parseZoneFile(zone, r, defaultTTL)
+	case importFormatCloudflare:
+		return parseCloudflareRecords(zone, r, defaultTTL)
+	case importFormatRoute53:
+		return parseRoute53RecordSets(zone, r)
+	}
+	return zoneFile{}, errors.New("format must be zone, cloudflare or route53")
+}
+
+// parseCloudflareRecords reads Cloudflare DNS records JSON: either the API
+// response with a "result" array or a bare array of records. Automatic TTLs
+// become defaultTTL. Proxied records import their origin content.
+func parseCloudflareRecords(zone string, r io.Reader, defaultTTL uint32) (zoneFile, error) {
+	var records []cloudflareRecord
+	if err := decodeProviderJSON(r, "result", &records); err != nil {
+		return zoneFile{}, fmt.Errorf("decode cloudflare records: %w", err)
+	}
+
+	var out zoneFile
+	for _, cf := range records {
+		ttl := cf.TTL
+		if ttl == 0 || ttl == cloudflareTTLAuto {
+			ttl = defaultTTL
+		}
+		name := normalizeName(cf.Name)
+		rrtype := strings.ToUpper(strings.TrimSpace(cf.Type))
+
+		var rr dns.RR
+		var err error
+		switch rrtype {
+		case "TXT":
+			rr = &dns.TXT{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+				Txt: chunkTXT(unquoteTXT(cf.Content)),
+			}
+		case "MX":
+			pref := uint16(0)
+			if cf.Priority != nil {
+				pref = *cf.Priority
+			}
+			rr, err = dns.NewRR(fmt.Sprintf("%s %d IN MX %d %s", name, ttl, pref, cf.Content))
+		default:
+			rr, err = dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, rrtype, cf.Content))
+		}
+		if err != nil || rr == nil {
+			out.Skipped = append(out.Skipped, importIssue{Name: name, Type: rrtype, Reason: "record type is not supported or content does not parse"})
+			continue
+		}
+		out.add(zone, rr)
+	}
+	dropProviderApex(zone, &out)
+	return out, nil
+}
+
+// parseRoute53RecordSets reads `aws route53 list-resource-record-sets`
+// output, or a bare array of record sets. Values are in presentation form,
+// so each one is parsed as a resource record. Alias records and routing
+// policies have no equivalent here and are reported.
+func parseRoute53RecordSets(zone string, r io.Reader) (zoneFile, error) {
+	var sets []route53RecordSet
+	if err := decodeProviderJSON(r, "ResourceRecordSets", &sets); err != nil {
+		return zoneFile{}, fmt.Errorf("decode route53 record sets: %w", err)
+	}
+
+	var out zoneFile
+	for _, set := range sets {
+		// Route 53 escapes a wildcard label as \052.
+		name := normalizeName(strings.ReplaceAll(set.Name, `\052`, "*"))
+		rrtype := strings.ToUpper(strings.TrimSpace(set.Type))
+		switch {
+		case set.AliasTarget != nil:
+			out.Skipped = append(out.Skipped, importIssue{Name: name, Type: rrtype, Reason: "alias records are not supported"})
+			continue
+		case set.SetIdentifier != "":
+			out.Skipped = append(out.Skipped, importIssue{Name: name, Type: rrtype, Reason: "routing policies are not supported"})
+			continue
+		}
+		for _, v := range set.ResourceRecords {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, set.TTL, rrtype, v.Value))
+			if err != nil || rr == nil {
+				out.Skipped = append(out.Skipped, importIssue{Name: name, Type: rrtype, Reason: "record type is not supported or value does not parse"})
+				continue
+			}
+			out.add(zone, rr)
+		}
+	}
+	dropProviderApex(zone, &out)
+	return out, nil
+}
+
+// decodeProviderJSON decodes either an object holding the list under key or
+// a bare JSON array into out.
+func decodeProviderJSON(r io.Reader, key string, out any) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return json.Unmarshal(body, out)
+	}
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		return err
+	}
+	list, ok := wrapper[key]
+	if !ok {
+		return fmt.Errorf("missing %q list", key)
+	}
+	return json.Unmarshal(list, out)
+}
+
+// dropProviderApex reports the provider's own apex SOA and NS instead of
+// adopting them, since moving here replaces those name servers.
+func dropProviderApex(zone string, f *zoneFile) {
+	if f.SOA != nil {
+		f.Skipped = append(f.Skipped, importIssue{Name: zone, Type: "SOA", Reason: "provider SOA is not imported"})
+		f.SOA = nil
+	}
+	for range f.NS {
+		f.Skipped = append(f.Skipped, importIssue{Name: zone, Type: "NS", Reason: "provider name servers are not imported"})
+	}
+	f.NS = nil
+}
+
+// unquoteTXT turns TXT content in presentation form ("a" "b") into the
+// text a zone file import would store; unquoted content is returned as is.
+func unquoteTXT(v string) string {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, `"`) {
+		return v
+	}
+	rr, err := dns.NewRR(". IN TXT " + v)
+	if err != nil || rr == nil {
+		return v
+	}
+	return strings.Join(rr.(*dns.TXT).Txt, "")
+}
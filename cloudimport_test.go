@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testCloudflareRecords = `{
+  "success": true,
+  "result": [
+    {"id": "1", "zone_name": "example.net", "name": "example.net", "type": "A", "content": "198.51.100.1", "proxied": true, "ttl": 1},
+    {"id": "2", "zone_name": "example.net", "name": "www.example.net", "type": "CNAME", "content": "example.net", "proxied": false, "ttl": 300},
+    {"id": "3", "zone_name": "example.net", "name": "example.net", "type": "MX", "content": "mail.example.net", "priority": 20, "ttl": 3600},
+    {"id": "4", "zone_name": "example.net", "name": "example.net", "type": "TXT", "content": "\"v=spf1 \" \"-all\"", "ttl": 1},
+    {"id": "5", "zone_name": "example.net", "name": "_dmarc.example.net", "type": "TXT", "content": "v=DMARC1; p=none", "ttl": 1},
+    {"id": "6", "zone_name": "example.net", "name": "_sip._tcp.example.net", "type": "SRV", "content": "10 5 5060 sip.example.net", "ttl": 1},
+    {"id": "7", "zone_name": "example.net", "name": "v6.example.net", "type": "AAAA", "content": "2001:db8::1", "ttl": 120}
+  ]
+}`
+
+const testRoute53RecordSets = `{
+  "ResourceRecordSets": [
+    {"Name": "example.net.", "Type": "NS", "TTL": 172800, "ResourceRecords": [{"Value": "ns-1.awsdns-01.com."}, {"Value": "ns-2.awsdns-02.net."}]},
+    {"Name": "example.net.", "Type": "SOA", "TTL": 900, "ResourceRecords": [{"Value": "ns-1.awsdns-01.com. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400"}]},
+    {"Name": "example.net.", "Type": "A", "TTL": 300, "ResourceRecords": [{"Value": "198.51.100.1"}, {"Value": "198.51.100.2"}]},
+    {"Name": "example.net.", "Type": "MX", "TTL": 300, "ResourceRecords": [{"Value": "10 mail.example.net."}]},
+    {"Name": "example.net.", "Type": "TXT", "TTL": 300, "ResourceRecords": [{"Value": "\"v=spf1 include:amazonses.com -all\""}]},
+    {"Name": "\\052.example.net.", "Type": "A", "TTL": 300, "ResourceRecords": [{"Value": "198.51.100.3"}]},
+    {"Name": "cdn.example.net.", "Type": "A", "AliasTarget": {"HostedZoneId": "Z2FDTNDATAQYW2", "DNSName": "d111.cloudfront.net.", "EvaluateTargetHealth": false}},
+    {"Name": "api.example.net.", "Type": "A", "SetIdentifier": "eu", "Weight": 10, "TTL": 60, "ResourceRecords": [{"Value": "198.51.100.4"}]}
+  ]
+}`
+
+func TestParseCloudflareRecords(t *testing.T) {
+	parsed, err := parseCloudflareRecords("example.net.", strings.NewReader(testCloudflareRecords), 20)
+	if err != nil {
+		t.Fatalf("parseCloudflareRecords: %v", err)
+	}
+	if len(parsed.Records) != 6 || len(parsed.Skipped) != 1 || parsed.Skipped[0].Type != "SRV" {
+		t.Fatalf("expected six records and SRV skipped, got %+v / %+v", parsed.Records, parsed.Skipped)
+	}
+	byKey := make(map[string]aRecord)
+	for _, rec := range parsed.Records {
+		byKey[rec.Name+"/"+rec.Type] = rec
+	}
+	if rec := byKey["example.net./A"]; rec.IP != "198.51.100.1" || rec.TTL != 20 {
+		t.Fatalf("expected automatic TTL to become the default, got %+v", rec)
+	}
+	if rec := byKey["example.net./MX"]; rec.Priority != 20 || rec.Target != "mail.example.net." {
+		t.Fatalf("unexpected MX: %+v", rec)
+	}
+	if rec := byKey["example.net./TXT"]; rec.Text != "v=spf1 -all" {
+		t.Fatalf("expected quoted TXT to be joined, got %q", rec.Text)
+	}
+	if rec := byKey["_dmarc.example.net./TXT"]; rec.Text != "v=DMARC1; p=none" {
+		t.Fatalf("expected unquoted TXT as is, got %q", rec.Text)
+	}
+
+	// A bare array is accepted too.
+	parsed, err = parseCloudflareRecords("example.net.", strings.NewReader(`[{"name":"example.net","type":"A","content":"198.51.100.9","ttl":60}]`), 20)
+	if err != nil || len(parsed.Records) != 1 {
+		t.Fatalf("expected bare array to parse, got %+v err=%v", parsed.Records, err)
+	}
+}
+
+func TestParseRoute53RecordSets(t *testing.T) {
+	parsed, err := parseRoute53RecordSets("example.net.", strings.NewReader(testRoute53RecordSets))
+	if err != nil {
+		t.Fatalf("parseRoute53RecordSets: %v", err)
+	}
+	if parsed.SOA != nil || len(parsed.NS) != 0 {
+		t.Fatalf("provider SOA and NS must not be adopted, got %v %v", parsed.SOA, parsed.NS)
+	}
+	if len(parsed.Records) != 4 {
+		t.Fatalf("expected two A, MX and TXT records, got %+v", parsed.Records)
+	}
+	reasons := make(map[string]bool)
+	for _, issue := range parsed.Skipped {
+		reasons[issue.Reason] = true
+	}
+	for _, want := range []string{"provider SOA is not imported", "provider name servers are not imported", "wildcard owners are not supported", "alias records are not supported", "routing policies are not supported"} {
+		if !reasons[want] {
+			t.Fatalf("expected skipped reason %q, got %+v", want, parsed.Skipped)
+		}
+	}
+	for _, rec := range parsed.Records {
+		if rec.Type == "TXT" && rec.Text != "v=spf1 include:amazonses.com -all" {
+			t.Fatalf("unexpected TXT: %q", rec.Text)
+		}
+	}
+}
+
+func TestHTTPCloudImportDryRun(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+
+	post := func(query, body string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/zones/example.net/import?propagate=false&"+query, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		var out map[string]any
+		_ = json.Unmarshal(resp.Body.Bytes(), &out)
+		return resp, out
+	}
+
+	resp, out := post("format=route53&dry_run=true", testRoute53RecordSets)
+	if resp.Code != http.StatusOK || out["dry_run"] != true || len(out["records"].([]any)) != 4 {
+		t.Fatalf("unexpected dry run: %d %v", resp.Code, out)
+	}
+	if _, ok := s.data.getZone("example.net"); ok || s.data.hasName("example.net") {
+		t.Fatal("dry run must not write")
+	}
+
+	resp, out = post("format=route53", testRoute53RecordSets)
+	if resp.Code != http.StatusOK || out["imported"].(float64) != 4 {
+		t.Fatalf("unexpected import: %d %v", resp.Code, out)
+	}
+	if len(s.data.getRecords("example.net", 1)) != 2 {
+		t.Fatal("expected both A records imported")
+	}
+
+	resp, out = post("format=cloudflare&mode=replace&dry_run=true", testCloudflareRecords)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected dry run: %d %v", resp.Code, out)
+	}
+	if n := len(out["remove"].([]any)); n != 3 {
+		t.Fatalf("expected replace preview to remove three records, got %d: %v", n, out["remove"])
+	}
+
+	if resp, _ := post("format=bind", testZoneFile); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", resp.Code)
+	}
+	if resp, _ := post("format=cloudflare", `{"errors":[]}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a result list, got %d", resp.Code)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
@@ -146,11 +147,42 @@ type userDomainRecords struct {
 	Rows []userGroupedRow
 }
 
+type statCount struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+type endpointStats struct {
+	Endpoint string
+	Queries  uint64
+	Dropped  uint64
+	Error    string
+}
+
+type zoneStatsRow struct {
+	Zone   string
+	Total  uint64
+	QTypes string
+}
+
+type clusterStats struct {
+	Window     string
+	Queries    uint64
+	Endpoints  []endpointStats
+	TopNames   []statCount
+	NXDomain   []statCount
+	TopRecords []statCount
+	Zones      []zoneStatsRow
+}
+
 type appData struct {
 	User        user
 	Message     string
 	Results     []actionResult
 	States      []endpointState
+	Stats       *clusterStats
 	UserGrouped []userDomainRecords
 	RecordsInfo string
 	Endpoints   []endpoint
@@ -225,6 +257,7 @@ func main() {
 			r.Post("/admin/domains/assign", s.handleAdminDomainAssign)
 			r.Post("/admin/domains/transfer", s.handleAdminDomainTransfer)
 			r.Post("/admin/actions/query-state", s.handleAdminQueryState)
+			r.Post("/admin/actions/query-stats", s.handleAdminQueryStats)
 			r.Post("/admin/actions/zone-upsert", s.handleAdminZoneUpsert)
 			r.Post("/admin/actions/record-upsert", s.handleAdminRecordUpsert)
 			r.Post("/admin/actions/record-add", s.handleAdminRecordAdd)
@@ -357,6 +390,10 @@ func (s *server) handleApp(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) renderApp(w http.ResponseWriter, r *http.Request, message string, results []actionResult, states []endpointState) {
+	_ = s.tplApp.Execute(w, s.buildAppData(r, message, results, states))
+}
+
+func (s *server) buildAppData(r *http.Request, message string, results []actionResult, states []endpointState) appData {
 	u := currentUser(r)
 
 	allDomains, _ := s.listAllDomains()
@@ -376,7 +413,7 @@ func (s *server) renderApp(w http.ResponseWriter, r *http.Request, message strin
 		}
 	}
 
-	_ = s.tplApp.Execute(w, appData{
+	return appData{
 		User:        u,
 		Message:     message,
 		Results:     results,
@@ -388,7 +425,7 @@ func (s *server) renderApp(w http.ResponseWriter, r *http.Request, message strin
 		AllDomains:  allDomains,
 		UserDomains: userDomains,
 		Now:         time.Now().UTC().Format(time.RFC3339),
-	})
+	}
 }
 
 func (s *server) patchUserSSE(w http.ResponseWriter, r *http.Request, u user, message string, results []actionResult) {
@@ -660,6 +697,16 @@ func (s *server) handleAdminQueryState(w http.ResponseWriter, r *http.Request) {
 	s.renderApp(w, r, "query-state done", nil, states)
 }
 
+func (s *server) handleAdminQueryStats(w http.ResponseWriter, r *http.Request) {
+	window := strings.TrimSpace(r.FormValue("window"))
+	if window == "" {
+		window = "5m"
+	}
+	data := s.buildAppData(r, "query-stats done", nil, nil)
+	data.Stats = s.queryStatsAll(window)
+	_ = s.tplApp.Execute(w, data)
+}
+
 func (s *server) handleAdminZoneUpsert(w http.ResponseWriter, r *http.Request) {
 	zone := normalizeDomain(strings.TrimSpace(r.FormValue("zone")))
 	ns1 := strings.TrimSpace(r.FormValue("ns1"))
@@ -1142,6 +1189,117 @@ func (s *server) queryStateAll() []endpointState {
 	return out
 }
 
+// queryStatsAll fetches /v1/stats from every endpoint and sums the results.
+// Each edge only sees its own share of queries, so cluster-wide top lists are
+// the per-name sums of the edge sketches.
+func (s *server) queryStatsAll(window string) *clusterStats {
+	out := &clusterStats{Window: window}
+	eps, err := s.listEndpoints()
+	if err != nil {
+		out.Endpoints = []endpointStats{{Error: err.Error()}}
+		return out
+	}
+
+	type edgeReport struct {
+		Queries    uint64                       `json:"queries"`
+		Dropped    uint64                       `json:"dropped"`
+		TopNames   []statCount                  `json:"top_names"`
+		NXDomain   []statCount                  `json:"nxdomain"`
+		TopRecords []statCount                  `json:"top_records"`
+		Zones      map[string]map[string]uint64 `json:"zones"`
+	}
+	reports := make([]edgeReport, len(eps))
+	out.Endpoints = make([]endpointStats, len(eps))
+	var wg sync.WaitGroup
+	for i, ep := range eps {
+		wg.Add(1)
+		go func(i int, ep endpoint) {
+			defer wg.Done()
+			out.Endpoints[i].Endpoint = ep.Name
+			if err := s.fetchJSON(ep, "/v1/stats?limit=50&window="+url.QueryEscape(window), &reports[i]); err != nil {
+				out.Endpoints[i].Error = err.Error()
+				return
+			}
+			out.Endpoints[i].Queries = reports[i].Queries
+			out.Endpoints[i].Dropped = reports[i].Dropped
+		}(i, ep)
+	}
+	wg.Wait()
+
+	var names, nxdomain, records [][]statCount
+	zones := make(map[string]map[string]uint64)
+	for i, rep := range reports {
+		if out.Endpoints[i].Error != "" {
+			continue
+		}
+		out.Queries += rep.Queries
+		names = append(names, rep.TopNames)
+		nxdomain = append(nxdomain, rep.NXDomain)
+		records = append(records, rep.TopRecords)
+		for zone, types := range rep.Zones {
+			if zones[zone] == nil {
+				zones[zone] = make(map[string]uint64)
+			}
+			for qtype, n := range types {
+				zones[zone][qtype] += n
+			}
+		}
+	}
+	out.TopNames = mergeStatCounts(names, 20)
+	out.NXDomain = mergeStatCounts(nxdomain, 20)
+	out.TopRecords = mergeStatCounts(records, 20)
+
+	for zone, types := range zones {
+		row := zoneStatsRow{Zone: zone}
+		qtypes := make([]string, 0, len(types))
+		for qtype, n := range types {
+			row.Total += n
+			qtypes = append(qtypes, fmt.Sprintf("%s=%d", qtype, n))
+		}
+		sort.Strings(qtypes)
+		row.QTypes = strings.Join(qtypes, " ")
+		out.Zones = append(out.Zones, row)
+	}
+	sort.Slice(out.Zones, func(i, j int) bool {
+		if out.Zones[i].Total != out.Zones[j].Total {
+			return out.Zones[i].Total > out.Zones[j].Total
+		}
+		return out.Zones[i].Zone < out.Zones[j].Zone
+	})
+	return out
+}
+
+func mergeStatCounts(lists [][]statCount, limit int) []statCount {
+	merged := make(map[string]*statCount)
+	for _, list := range lists {
+		for _, c := range list {
+			key := c.Name + "|" + c.Type
+			m := merged[key]
+			if m == nil {
+				m = &statCount{Name: c.Name, Type: c.Type}
+				merged[key] = m
+			}
+			m.Count += c.Count
+			m.Error += c.Error
+		}
+	}
+
+	out := make([]statCount, 0, len(merged))
+	for _, c := range merged {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name+out[i].Type < out[j].Name+out[j].Type
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
 func (s *server) periodicEdgeSync() {
 	if s.syncEvery <= 0 {
 		return
@@ -1263,24 +1421,39 @@ func (s *server) queryEndpointState(ep endpoint) endpointState {
 		st.Zones = append(st.Zones, zoneView{Zone: z.Zone, NS: z.NS, SOATTL: z.SOATTL})
 	}
 
-	var rr struct {
-		Records []struct {
-			Name     string `json:"name"`
-			Type     string `json:"type"`
-			IP       string `json:"ip"`
-			Text     string `json:"text"`
-			Target   string `json:"target"`
-			Priority uint16 `json:"priority"`
-			TTL      uint32 `json:"ttl"`
-			Zone     string `json:"zone"`
-		} `json:"records"`
-	}
-	if err := s.fetchJSON(ep, "/v1/records", &rr); err != nil {
-		st.Error = "records fetch failed: " + err.Error()
-		return st
+	type recordItem struct {
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		IP       string `json:"ip"`
+		Text     string `json:"text"`
+		Target   string `json:"target"`
+		Priority uint16 `json:"priority"`
+		TTL      uint32 `json:"ttl"`
+		Zone     string `json:"zone"`
+	}
+	var records []recordItem
+	cursor := ""
+	for {
+		var rr struct {
+			Records    []recordItem `json:"records"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		path := "/v1/records?limit=1000"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		if err := s.fetchJSON(ep, path, &rr); err != nil {
+			st.Error = "records fetch failed: " + err.Error()
+			return st
+		}
+		records = append(records, rr.Records...)
+		if rr.NextCursor == "" {
+			break
+		}
+		cursor = rr.NextCursor
 	}
 
-	for _, rec := range rr.Records {
+	for _, rec := range records {
 		value := rec.IP
 		switch rec.Type {
 		case "TXT":
@@ -1697,6 +1870,37 @@ const appHTML = `<!doctype html>
           {{end}}
         </section>
         {{end}}
+
+        {{with .Stats}}
+        <section class="panel">
+          <h2>Query Statistics ({{.Window}})</h2>
+          <div class="helper">{{.Queries}} queries across {{len .Endpoints}} endpoints. Counts are summed from per-edge sketches and may overestimate.</div>
+          <table>
+            <thead><tr><th>Endpoint</th><th>Queries</th><th>Dropped</th><th>Status</th></tr></thead>
+            <tbody>{{range .Endpoints}}<tr><td>{{.Endpoint}}</td><td>{{.Queries}}</td><td>{{.Dropped}}</td><td>{{if .Error}}<span class="bad">{{.Error}}</span>{{else}}<span class="ok">OK</span>{{end}}</td></tr>{{end}}</tbody>
+          </table>
+          <h3>Top Names</h3>
+          <table>
+            <thead><tr><th>Name</th><th>Queries</th></tr></thead>
+            <tbody>{{range .TopNames}}<tr><td class="mono">{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</tbody>
+          </table>
+          <h3>NXDOMAIN Hotspots</h3>
+          <table>
+            <thead><tr><th>Name</th><th>Queries</th></tr></thead>
+            <tbody>{{range .NXDomain}}<tr><td class="mono">{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</tbody>
+          </table>
+          <h3>Record Hits</h3>
+          <table>
+            <thead><tr><th>Name</th><th>Type</th><th>Hits</th></tr></thead>
+            <tbody>{{range .TopRecords}}<tr><td class="mono">{{.Name}}</td><td>{{.Type}}</td><td>{{.Count}}</td></tr>{{end}}</tbody>
+          </table>
+          <h3>Query Types per Zone</h3>
+          <table>
+            <thead><tr><th>Zone</th><th>Queries</th><th>Types</th></tr></thead>
+            <tbody>{{range .Zones}}<tr><td class="mono">{{.Zone}}</td><td>{{.Total}}</td><td class="mono">{{.QTypes}}</td></tr>{{end}}</tbody>
+          </table>
+        </section>
+        {{end}}
       </main>
 
       {{if eq .User.Role "admin"}}
@@ -1756,6 +1960,10 @@ const appHTML = `<!doctype html>
         <section class="panel stack">
           <h3>Admin DNS Actions</h3>
           <form method="post" action="/admin/actions/query-state"><button type="submit">Query State</button></form>
+          <form method="post" action="/admin/actions/query-stats" class="stack">
+            <select name="window"><option>1m</option><option selected>5m</option><option>15m</option><option>1h</option></select>
+            <button type="submit">Query Stats</button>
+          </form>
           <form method="post" action="/admin/actions/zone-upsert" class="stack">
             <input name="zone" placeholder="cloudroof.eu" required>
             <input name="ns1" placeholder="snail.cloudroof.eu" required>
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -33,20 +34,56 @@ func loadConfig() config {
 		syncToken = apiToken
 	}
 
+	serialScheme := strings.ToLower(envOrDefault("SOA_SERIAL_SCHEME", serialSchemeUnix))
+	if !validSerialScheme(serialScheme) {
+		log.Printf("warning: SOA_SERIAL_SCHEME %q is not unix, date or counter; using unix", serialScheme)
+		serialScheme = serialSchemeUnix
+	}
+
+	catalogZone := normalizeName(strings.TrimSpace(os.Getenv("CATALOG_ZONE")))
+	if catalogZone == "." {
+		catalogZone = ""
+	}
+
 	return config{
-		NodeID:        nodeID,
-		HTTPListen:    envOrDefault("HTTP_LISTEN", ":8080"),
-		DNSUDPListen:  envOrDefault("DNS_UDP_LISTEN", ":53"),
-		DNSTCPListen:  envOrDefault("DNS_TCP_LISTEN", ":53"),
-		DBPath:        envOrDefault("DB_PATH", "dns.db"),
-		MigrationsDir: envOrDefault("MIGRATIONS_DIR", "migrations"),
-		DebugLog:      envOrDefaultBool("DEBUG_LOG", false),
-		APIToken:      apiToken,
-		SyncToken:     syncToken,
-		Peers:         splitCSV(os.Getenv("PEERS")),
-		DefaultTTL:    envOrDefaultUint32("DEFAULT_TTL", 20),
-		DefaultZone:   defaultZone,
-		DefaultNS:     defaultNS,
+		NodeID:         nodeID,
+		HTTPListen:     envOrDefault("HTTP_LISTEN", ":8080"),
+		DNSUDPListen:   splitCSV(envOrDefault("DNS_UDP_LISTEN", ":53")),
+		DNSTCPListen:   splitCSV(envOrDefault("DNS_TCP_LISTEN", ":53")),
+		DNSUDPWorkers:  envWorkerCount("DNS_UDP_WORKERS", 1),
+		DNSTLSListen:   splitCSV(os.Getenv("DNS_TLS_LISTEN")),
+		TLSCertFile:    strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
+		TLSKeyFile:     strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		DoTIdleTimeout: envOrDefaultDuration("DOT_IDLE_TIMEOUT", 10*time.Second),
+		DoTKeepAlive:   envOrDefaultDuration("DOT_KEEPALIVE", 30*time.Second),
+		DoTMaxQueries:  int(envOrDefaultUint32("DOT_MAX_QUERIES", 1000)),
+		DNSQUICListen:  splitCSV(os.Getenv("DNS_QUIC_LISTEN")),
+		DoQIdleTimeout: envOrDefaultDuration("DOQ_IDLE_TIMEOUT", 30*time.Second),
+		HTTP3Listen:    splitCSV(os.Getenv("HTTP3_LISTEN")),
+		DoHCORSOrigins: splitCSV(os.Getenv("DOH_CORS_ORIGINS")),
+		DNSTapOutput:   strings.TrimSpace(os.Getenv("DNSTAP_OUTPUT")),
+		DNSTapBuffer:   envOrDefaultUint32("DNSTAP_BUFFER", 10000),
+		Metrics:        envOrDefaultBool("METRICS", true),
+		MetricsListen:  strings.TrimSpace(os.Getenv("METRICS_LISTEN")),
+		Stats:          envOrDefaultBool("STATS", true),
+		DBPath:         envOrDefault("DB_PATH", "dns.db"),
+		MigrationsDir:  envOrDefault("MIGRATIONS_DIR", "migrations"),
+		DebugLog:       envOrDefaultBool("DEBUG_LOG", false),
+		APIToken:       apiToken,
+		SyncToken:      syncToken,
+		Peers:          splitCSV(os.Getenv("PEERS")),
+		DefaultTTL:     envOrDefaultUint32("DEFAULT_TTL", 20),
+		DefaultZone:    defaultZone,
+		DefaultNS:      defaultNS,
+		DNSCache:       envOrDefaultBool("DNS_CACHE", true),
+		DNSCacheSize:   envOrDefaultUint32("DNS_CACHE_SIZE", 10000),
+		SerialScheme:   serialScheme,
+		ExpirySweep:    envOrDefaultDuration("EXPIRY_SWEEP_INTERVAL", 10*time.Second),
+		DrainGrace:     envOrDefaultDuration("DRAIN_GRACE", time.Minute),
+		DrainHook:      strings.TrimSpace(os.Getenv("DRAIN_HOOK")),
+		CatalogZone:    catalogZone,
+		AXFRAllow:      parseAXFRAllow(splitCSV(os.Getenv("AXFR_ALLOW"))),
+		NotifyTargets:  splitCSV(os.Getenv("NOTIFY_TARGETS")),
 		SyncHTTPClient: &http.Client{
 			Timeout: 2 * time.Second,
 		},
@@ -60,6 +97,10 @@ func (c config) defaultNSForZone(_ string) []string {
 	return nil
 }
 
+func (c config) tlsEnabled() bool {
+	return len(c.DNSTLSListen) > 0 || len(c.DNSQUICListen) > 0 || len(c.HTTP3Listen) > 0
+}
+
 func splitCSV(v string) []string {
 	v = strings.TrimSpace(v)
 	if v == "" {
@@ -100,6 +141,39 @@ func envOrDefaultUint32(key string, fallback uint32) uint32 {
 	return uint32(n)
 }
 
+// envWorkerCount parses a positive worker count, or "auto" for one worker per
+// CPU.
+func envWorkerCount(key string, fallback int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	if strings.EqualFold(v, "auto") {
+		return runtime.NumCPU()
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+
+	return n
+}
+
+func envOrDefaultDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+
+	return d
+}
+
 func envOrDefaultBool(key string, fallback bool) bool {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"runtime"
+	"testing"
+)
 
 func TestLoadConfigDefaultsAndFallbacks(t *testing.T) {
 	t.Setenv("API_TOKEN", "api")
@@ -51,3 +54,26 @@ func TestDefaultNSForZoneUsesConfiguredHostnames(t *testing.T) {
 		t.Fatalf("unexpected configured NS values: %#v", ns)
 	}
 }
+
+func TestLoadConfigDNSListeners(t *testing.T) {
+	t.Setenv("DNS_UDP_LISTEN", "192.0.2.1:53, [2001:db8::1]:53")
+	t.Setenv("DNS_TCP_LISTEN", "")
+	t.Setenv("DNS_UDP_WORKERS", "auto")
+
+	cfg := loadConfig()
+
+	if len(cfg.DNSUDPListen) != 2 || cfg.DNSUDPListen[1] != "[2001:db8::1]:53" {
+		t.Fatalf("unexpected UDP listen addresses: %#v", cfg.DNSUDPListen)
+	}
+	if len(cfg.DNSTCPListen) != 1 || cfg.DNSTCPListen[0] != ":53" {
+		t.Fatalf("unexpected default TCP listen addresses: %#v", cfg.DNSTCPListen)
+	}
+	if cfg.DNSUDPWorkers != runtime.NumCPU() {
+		t.Fatalf("expected one UDP worker per CPU, got %d", cfg.DNSUDPWorkers)
+	}
+
+	t.Setenv("DNS_UDP_WORKERS", "0")
+	if got := loadConfig().DNSUDPWorkers; got != 1 {
+		t.Fatalf("expected invalid worker count to fall back to 1, got %d", got)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	mrand "math/rand"
@@ -14,54 +16,256 @@ import (
 )
 
 func (s *server) runDNS(ctx context.Context, network string) error {
-	addr := s.cfg.DNSUDPListen
-	if network == "tcp" {
-		addr = s.cfg.DNSTCPListen
+	addrs := s.cfg.DNSUDPListen
+	workers := s.cfg.DNSUDPWorkers
+	switch network {
+	case "tcp":
+		addrs = s.cfg.DNSTCPListen
+		workers = 1
+	case "tcp-tls":
+		addrs = s.cfg.DNSTLSListen
+		workers = 1
+		if s.certs == nil {
+			return errors.New("dns/tcp-tls: TLS certificate is not configured")
+		}
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > 1 && !reusePortSupported {
+		log.Printf("warning: SO_REUSEPORT is not supported on this platform, using one dns/%s listener per address", network)
+		workers = 1
+	}
+
+	lc := net.ListenConfig{}
+	if workers > 1 {
+		lc.Control = reusePortControl
+	}
+	if network == "tcp-tls" {
+		lc.KeepAlive = s.cfg.DoTKeepAlive
+	}
+
+	servers := make([]*dns.Server, 0, len(addrs)*workers)
+	closeAll := func() {
+		for _, srv := range servers {
+			if srv.PacketConn != nil {
+				_ = srv.PacketConn.Close()
+			}
+			if srv.Listener != nil {
+				_ = srv.Listener.Close()
+			}
+		}
 	}
 
-	mux := dns.NewServeMux()
-	mux.HandleFunc(".", s.handleDNS)
+	for _, addr := range addrs {
+		for worker := 0; worker < workers; worker++ {
+			dnsServer := &dns.Server{Net: network}
+			var bound string
+			switch network {
+			case "tcp":
+				ln, err := lc.Listen(ctx, network, addr)
+				if err != nil {
+					closeAll()
+					return fmt.Errorf("dns/%s listen %s: %w", network, addr, err)
+				}
+				dnsServer.Listener = ln
+				bound = ln.Addr().String()
+			case "tcp-tls":
+				ln, err := lc.Listen(ctx, "tcp", addr)
+				if err != nil {
+					closeAll()
+					return fmt.Errorf("dns/%s listen %s: %w", network, addr, err)
+				}
+				dnsServer.Listener = tls.NewListener(ln, s.tlsConfig("dot"))
+				dnsServer.IdleTimeout = func() time.Duration { return s.cfg.DoTIdleTimeout }
+				dnsServer.MaxTCPQueries = s.cfg.DoTMaxQueries
+				bound = ln.Addr().String()
+			default:
+				pc, err := lc.ListenPacket(ctx, network, addr)
+				if err != nil {
+					closeAll()
+					return fmt.Errorf("dns/%s listen %s: %w", network, addr, err)
+				}
+				dnsServer.PacketConn = pc
+				bound = pc.LocalAddr().String()
+			}
+			// Later workers join the first socket's port, which matters when
+			// the configured address asks for an ephemeral port.
+			addr = bound
 
-	dnsServer := &dns.Server{Addr: addr, Net: network, Handler: mux}
-	go func() {
-		<-ctx.Done()
-		_ = dnsServer.ShutdownContext(context.Background())
-	}()
+			l := &dnsListener{network: network, addr: bound, worker: worker, pad: network == "tcp-tls"}
+			dnsServer.Handler = s.dnsHandler(l)
+			s.registerListener(l)
+			servers = append(servers, dnsServer)
+		}
+	}
 
-	if err := dnsServer.ListenAndServe(); err != nil {
-		return fmt.Errorf("dns/%s listen: %w", network, err)
+	errCh := make(chan error, len(servers))
+	for _, dnsServer := range servers {
+		go func(srv *dns.Server) { errCh <- srv.ActivateAndServe() }(dnsServer)
+	}
+
+	select {
+	case <-ctx.Done():
+		for _, srv := range servers {
+			_ = srv.ShutdownContext(context.Background())
+		}
+		return nil
+	case err := <-errCh:
+		for _, srv := range servers {
+			_ = srv.ShutdownContext(context.Background())
+		}
+		if err != nil {
+			return fmt.Errorf("dns/%s serve: %w", network, err)
+		}
+		return nil
 	}
-	return nil
 }
 
-func (s *server) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
+func (s *server) dnsHandler(l *dnsListener) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		l.queries.Add(1)
+		if err := s.serveDNS(w, req, l); err != nil {
+			l.errors.Add(1)
+			return
+		}
+		l.responses.Add(1)
+	})
+}
+
+func (s *server) registerListener(l *dnsListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *server) listenerStats() []dnsListenerStats {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	out := make([]dnsListenerStats, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		out = append(out, dnsListenerStats{
+			Network:   l.network,
+			Addr:      l.addr,
+			Worker:    l.worker,
+			Queries:   l.queries.Load(),
+			Responses: l.responses.Load(),
+			Errors:    l.errors.Load(),
+		})
+	}
+	return out
+}
+
+func (s *server) serveDNS(w dns.ResponseWriter, req *dns.Msg, l *dnsListener) error {
+	queryTime := time.Now()
 	if s.cfg.DebugLog {
 		log.Printf("dns query remote=%s id=%d q=%s", w.RemoteAddr().String(), req.Id, formatDNSQuestions(req.Question))
 	}
-	resp := s.resolveDNS(req)
+	if isTransferQuery(req) && l.network != "udp" {
+		return s.serveTransfer(w, req, l)
+	}
+	res, err := s.answerDNS(req)
+	if err != nil {
+		log.Printf("dns response encode failed remote=%s id=%d: %v", w.RemoteAddr().String(), req.Id, err)
+		return err
+	}
 	if s.cfg.DebugLog {
-		log.Printf("dns response remote=%s id=%d rcode=%d answers=%d", w.RemoteAddr().String(), resp.Id, resp.Rcode, len(resp.Answer))
+		log.Printf("dns response remote=%s id=%d rcode=%d answers=%d cached=%t", w.RemoteAddr().String(), req.Id, res.Rcode, res.Answers, res.Cached)
 	}
-	_ = w.WriteMsg(resp)
+	if l.pad {
+		res.Wire = padResponse(req, res.Wire)
+	}
+	if _, err := w.Write(res.Wire); err != nil {
+		return err
+	}
+	s.observeQuery(l.network, req, res.Rcode, res.Answers, queryTime)
+	s.tap.log(l.network, w.RemoteAddr(), w.LocalAddr(), req, queryTime, res.Wire)
+	return nil
+}
+
+// answerDNS resolves req and returns the packed response, serving it from the
+// response cache when possible.
+func (s *server) answerDNS(req *dns.Msg) (dnsResult, error) {
+	if resp := s.drainRefused(req); resp != nil {
+		wire, err := resp.Pack()
+		if err != nil {
+			return dnsResult{}, err
+		}
+		return dnsResult{Wire: wire, Rcode: resp.Rcode}, nil
+	}
+
+	if isTransferQuery(req) {
+		// Zone transfers need a stream; serveDNS handles them on TCP and DoT.
+		resp := new(dns.Msg)
+		resp.SetRcode(req, dns.RcodeRefused)
+		wire, err := resp.Pack()
+		if err != nil {
+			return dnsResult{}, err
+		}
+		return dnsResult{Wire: wire, Rcode: resp.Rcode}, nil
+	}
+
+	key, cacheable := responseCacheKeyFor(req)
+	if cacheable && s.catalog.owns(key.Name) {
+		// Catalog contents change without touching the store's names, so
+		// the response cache would never see them invalidated.
+		cacheable = false
+	}
+	if !cacheable || s.cache == nil {
+		resp := s.resolveDNS(req)
+		wire, err := resp.Pack()
+		if err != nil {
+			return dnsResult{}, err
+		}
+		return dnsResult{Wire: wire, Rcode: resp.Rcode, Answers: len(resp.Answer)}, nil
+	}
+
+	if e := s.cache.get(key); e != nil {
+		return e.render(req), nil
+	}
+
+	view := s.data.view()
+	canonical := req.Copy()
+	canonical.Question[0].Name = key.Name
+	e, err := newResponseCacheEntry(key, s.resolveDNSView(canonical, view))
+	if err != nil {
+		return dnsResult{}, err
+	}
+	e.expires = view.nameExpiry(key.Name)
+	s.cache.put(e, func() bool { return s.data.view() == view })
+
+	res := e.render(req)
+	res.Cached = false
+	return res, nil
 }
 
 func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
+	return s.resolveDNSView(req, s.data.view())
+}
+
+func (s *server) resolveDNSView(req *dns.Msg, view *storeView) *dns.Msg {
+	if len(req.Question) > 0 && s.catalog.owns(normalizeName(req.Question[0].Name)) {
+		return s.catalog.answer(req)
+	}
+
 	resp := new(dns.Msg)
 	resp.SetReply(req)
 	resp.Authoritative = true
 
 	for _, q := range req.Question {
 		name := normalizeName(q.Name)
+		owner := queryOwnerName(q.Name)
 
 		switch q.Qtype {
 		case dns.TypeA, dns.TypeANY:
 			aAnswers := make([]dns.RR, 0, 4)
 			hasDirectAnswer := false
-			for _, rec := range s.data.getRecords(name, q.Qtype) {
+			for _, rec := range view.getRecords(name, q.Qtype) {
 				if rec.Type == "A" {
 					hasDirectAnswer = true
 					rr := &dns.A{
-						Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: rec.TTL},
 						A:   net.ParseIP(rec.IP).To4(),
 					}
 					if rr.A != nil {
@@ -72,7 +276,7 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 					ip := net.ParseIP(rec.IP)
 					if ip != nil && ip.To4() == nil {
 						resp.Answer = append(resp.Answer, &dns.AAAA{
-							Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: rec.TTL},
+							Hdr:  dns.RR_Header{Name: owner, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: rec.TTL},
 							AAAA: ip,
 						})
 					}
@@ -80,21 +284,21 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 				if rec.Type == "TXT" && q.Qtype == dns.TypeANY {
 					hasDirectAnswer = true
 					resp.Answer = append(resp.Answer, &dns.TXT{
-						Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: rec.TTL},
 						Txt: chunkTXT(rec.Text),
 					})
 				}
 				if rec.Type == "CNAME" && q.Qtype == dns.TypeANY {
 					hasDirectAnswer = true
 					resp.Answer = append(resp.Answer, &dns.CNAME{
-						Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
 						Target: normalizeName(rec.Target),
 					})
 				}
 				if rec.Type == "MX" && q.Qtype == dns.TypeANY {
 					hasDirectAnswer = true
 					resp.Answer = append(resp.Answer, &dns.MX{
-						Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: rec.TTL},
 						Mx:         normalizeName(rec.Target),
 						Preference: rec.Priority,
 					})
@@ -103,9 +307,9 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 			shuffleRR(aAnswers)
 			resp.Answer = append(resp.Answer, aAnswers...)
 			if q.Qtype == dns.TypeA && !hasDirectAnswer {
-				for _, rec := range s.data.getRecords(name, dns.TypeCNAME) {
+				for _, rec := range view.getRecords(name, dns.TypeCNAME) {
 					resp.Answer = append(resp.Answer, &dns.CNAME{
-						Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
 						Target: normalizeName(rec.Target),
 					})
 				}
@@ -113,56 +317,56 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 		case dns.TypeAAAA:
 			aaaaAnswers := make([]dns.RR, 0, 4)
 			hasDirectAnswer := false
-			for _, rec := range s.data.getRecords(name, q.Qtype) {
+			for _, rec := range view.getRecords(name, q.Qtype) {
 				ip := net.ParseIP(rec.IP)
 				if ip == nil || ip.To4() != nil {
 					continue
 				}
 				hasDirectAnswer = true
 				aaaaAnswers = append(aaaaAnswers, &dns.AAAA{
-					Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: rec.TTL},
+					Hdr:  dns.RR_Header{Name: owner, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: rec.TTL},
 					AAAA: ip,
 				})
 			}
 			shuffleRR(aaaaAnswers)
 			resp.Answer = append(resp.Answer, aaaaAnswers...)
 			if !hasDirectAnswer {
-				for _, rec := range s.data.getRecords(name, dns.TypeCNAME) {
+				for _, rec := range view.getRecords(name, dns.TypeCNAME) {
 					resp.Answer = append(resp.Answer, &dns.CNAME{
-						Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
 						Target: normalizeName(rec.Target),
 					})
 				}
 			}
 		case dns.TypeTXT:
 			hasDirectAnswer := false
-			for _, rec := range s.data.getRecords(name, q.Qtype) {
+			for _, rec := range view.getRecords(name, q.Qtype) {
 				hasDirectAnswer = true
 				resp.Answer = append(resp.Answer, &dns.TXT{
-					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: rec.TTL},
+					Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: rec.TTL},
 					Txt: chunkTXT(rec.Text),
 				})
 			}
 			if !hasDirectAnswer {
-				for _, rec := range s.data.getRecords(name, dns.TypeCNAME) {
+				for _, rec := range view.getRecords(name, dns.TypeCNAME) {
 					resp.Answer = append(resp.Answer, &dns.CNAME{
-						Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
+						Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
 						Target: normalizeName(rec.Target),
 					})
 				}
 			}
 		case dns.TypeCNAME:
-			for _, rec := range s.data.getRecords(name, q.Qtype) {
+			for _, rec := range view.getRecords(name, q.Qtype) {
 				resp.Answer = append(resp.Answer, &dns.CNAME{
-					Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
+					Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rec.TTL},
 					Target: normalizeName(rec.Target),
 				})
 			}
 		case dns.TypeMX:
 			mxAnswers := make([]*dns.MX, 0, 4)
-			for _, rec := range s.data.getRecords(name, q.Qtype) {
+			for _, rec := range view.getRecords(name, q.Qtype) {
 				mxAnswers = append(mxAnswers, &dns.MX{
-					Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: rec.TTL},
+					Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: rec.TTL},
 					Mx:         normalizeName(rec.Target),
 					Preference: rec.Priority,
 				})
@@ -177,17 +381,21 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 				resp.Answer = append(resp.Answer, rr)
 			}
 		case dns.TypeNS:
-			if zone, ok := s.data.getZone(name); ok {
+			if zone, ok := view.getZone(name); ok {
 				for _, ns := range zone.NS {
 					resp.Answer = append(resp.Answer, &dns.NS{
-						Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: zone.SOATTL},
+						Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: zone.SOATTL},
 						Ns:  ns,
 					})
 				}
 			}
 		case dns.TypeSOA:
-			if zone, ok := s.data.bestZone(name); ok {
-				resp.Answer = append(resp.Answer, soaForZone(zone))
+			if zone, ok := view.bestZone(name); ok {
+				soa := soaForZone(zone)
+				if zone.Zone == name {
+					soa.Header().Name = owner
+				}
+				resp.Answer = append(resp.Answer, soa)
 			}
 		}
 	}
@@ -200,13 +408,13 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 			firstType = req.Question[0].Qtype
 		}
 
-		if zone, ok := s.data.bestZone(firstQ); ok {
-			if s.data.hasName(firstQ) && (firstType == dns.TypeA || firstType == dns.TypeAAAA || firstType == dns.TypeTXT || firstType == dns.TypeCNAME || firstType == dns.TypeMX || firstType == dns.TypeANY) {
+		if zone, ok := view.bestZone(firstQ); ok {
+			if view.hasName(firstQ) && (firstType == dns.TypeA || firstType == dns.TypeAAAA || firstType == dns.TypeTXT || firstType == dns.TypeCNAME || firstType == dns.TypeMX || firstType == dns.TypeANY) {
 				resp.Rcode = dns.RcodeSuccess
-				resp.Ns = append(resp.Ns, soaForZone(zone))
+				resp.Ns = append(resp.Ns, negativeSOAForZone(zone))
 			} else {
 				resp.Rcode = dns.RcodeNameError
-				resp.Ns = append(resp.Ns, soaForZone(zone))
+				resp.Ns = append(resp.Ns, negativeSOAForZone(zone))
 			}
 		} else {
 			resp.Rcode = dns.RcodeRefused
@@ -216,6 +424,22 @@ func (s *server) resolveDNS(req *dns.Msg) *dns.Msg {
 	return resp
 }
 
+func isTransferQuery(req *dns.Msg) bool {
+	if len(req.Question) != 1 {
+		return false
+	}
+	qtype := req.Question[0].Qtype
+	return qtype == dns.TypeAXFR || qtype == dns.TypeIXFR
+}
+
+func queryOwnerName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "."
+	}
+	return dns.Fqdn(name)
+}
+
 func shuffleRR(records []dns.RR) {
 	if len(records) < 2 {
 		return
@@ -248,20 +472,39 @@ func chunkTXT(v string) []string {
 	return out
 }
 
+// negativeSOAForZone is the SOA placed in the authority section of NXDOMAIN
+// and NODATA answers. Its TTL is the lesser of the SOA TTL and the negative
+// TTL, as RFC 2308 section 3 requires.
+func negativeSOAForZone(z zoneConfig) dns.RR {
+	soa := soaForZone(z).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
 func soaForZone(z zoneConfig) dns.RR {
-	mname := z.Zone
-	if len(z.NS) > 0 {
+	applySOADefaults(&z)
+	mname := z.MName
+	if mname == "" && len(z.NS) > 0 {
 		mname = z.NS[0]
 	}
+	if mname == "" {
+		mname = z.Zone
+	}
+	mbox := z.Mbox
+	if mbox == "" {
+		mbox = "hostmaster." + z.Zone
+	}
 
 	return &dns.SOA{
 		Hdr:     dns.RR_Header{Name: z.Zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: z.SOATTL},
 		Ns:      mname,
-		Mbox:    "hostmaster." + z.Zone,
+		Mbox:    mbox,
 		Serial:  z.Serial,
-		Refresh: 30,
-		Retry:   30,
-		Expire:  300,
-		Minttl:  z.SOATTL,
+		Refresh: z.Refresh,
+		Retry:   z.Retry,
+		Expire:  z.Expire,
+		Minttl:  z.NegativeTTL,
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -200,3 +201,110 @@ func TestResolveDNSMXRecord(t *testing.T) {
 		t.Fatalf("expected MX sorted by preference, got %d then %d", mx1.Preference, mx2.Preference)
 	}
 }
+
+func TestResolveDNSPreservesQueryNameCase(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeANY, dns.TypeNS, dns.TypeSOA} {
+		qname := "aPp.ExAmPlE.cOm."
+		if qtype == dns.TypeNS || qtype == dns.TypeSOA {
+			qname = "ExAmPlE.cOm."
+		}
+		req := new(dns.Msg)
+		req.SetQuestion(qname, qtype)
+
+		resp := s.resolveDNS(req)
+		if len(resp.Question) != 1 || resp.Question[0].Name != qname {
+			t.Fatalf("expected question %q echoed, got %#v", qname, resp.Question)
+		}
+		if len(resp.Answer) == 0 {
+			t.Fatalf("expected answers for %s", dns.TypeToString[qtype])
+		}
+		for _, rr := range resp.Answer {
+			if rr.Header().Name != qname {
+				t.Fatalf("expected owner %q for %s, got %q", qname, dns.TypeToString[qtype], rr.Header().Name)
+			}
+		}
+	}
+}
+
+func waitForListeners(t *testing.T, s *server, n int) []dnsListenerStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats := s.listenerStats(); len(stats) >= n {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d dns listeners, got %d", n, len(s.listenerStats()))
+	return nil
+}
+
+func TestRunDNSMultipleListeners(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.DNSUDPListen = []string{"127.0.0.1:0", "127.0.0.1:0"}
+	s.cfg.DNSTCPListen = []string{"127.0.0.1:0"}
+	s.cfg.DNSUDPWorkers = 2
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.runDNS(ctx, "udp") }()
+	go func() { errCh <- s.runDNS(ctx, "tcp") }()
+
+	stats := waitForListeners(t, s, 5)
+	udpAddrs := make(map[string]int)
+	for _, l := range stats {
+		if l.Network == "udp" {
+			udpAddrs[l.Addr]++
+		}
+	}
+	if len(udpAddrs) != 2 {
+		t.Fatalf("expected two distinct UDP addresses, got %v", udpAddrs)
+	}
+	for addr, n := range udpAddrs {
+		if n != 2 {
+			t.Fatalf("expected two workers sharing %s, got %d", addr, n)
+		}
+	}
+
+	for _, l := range stats {
+		if l.Worker != 0 {
+			continue
+		}
+		c := &dns.Client{Net: l.Network, Timeout: time.Second}
+		req := new(dns.Msg)
+		req.SetQuestion("app.example.com.", dns.TypeA)
+		resp, _, err := c.Exchange(req, l.Addr)
+		if err != nil {
+			t.Fatalf("%s exchange with %s: %v", l.Network, l.Addr, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("expected one answer from %s %s, got %d", l.Network, l.Addr, len(resp.Answer))
+		}
+	}
+
+	perAddr := make(map[string]uint64)
+	for _, l := range s.listenerStats() {
+		perAddr[l.Network+"/"+l.Addr] += l.Responses
+	}
+	for key, n := range perAddr {
+		if n != 1 {
+			t.Fatalf("expected one response counted on %s, got %d", key, n)
+		}
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatalf("runDNS returned error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	dnstapVersion      = "dns-server"
+	dnstapCloseTimeout = 2 * time.Second
+)
+
+// dnstapProtocolDoQ is SocketProtocol DOQ from the current dnstap.proto, which
+// the generated bindings predate.
+const dnstapProtocolDoQ dnstap.SocketProtocol = 7
+
+// newDNSTapLogger starts a dnstap writer for output, which is "unix:/path",
+// "tcp:host:port" or "file:/path". Socket outputs connect lazily and
+// reconnect on failure; the file is created up front.
+func newDNSTapLogger(output, identity string, buffer int) (*dnstapLogger, error) {
+	kind, target, ok := strings.Cut(output, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("dnstap output %q: expected unix:, tcp: or file: prefix", output)
+	}
+
+	var w dnstap.Writer
+	switch kind {
+	case "unix", "tcp":
+		addr, err := resolveDNSTapAddr(kind, target)
+		if err != nil {
+			return nil, fmt.Errorf("dnstap output %q: %w", output, err)
+		}
+		w = dnstap.NewSocketWriter(addr, &dnstap.SocketWriterOptions{
+			Timeout:       5 * time.Second,
+			FlushTimeout:  time.Second,
+			RetryInterval: 5 * time.Second,
+			Dialer:        &net.Dialer{Timeout: 5 * time.Second},
+			Logger:        log.Default(),
+		})
+	case "file":
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("dnstap output %q: %w", output, err)
+		}
+		fw, err := dnstap.NewWriter(f, nil)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("dnstap output %q: %w", output, err)
+		}
+		w = &dnstapFileWriter{Writer: fw, file: f}
+	default:
+		return nil, fmt.Errorf("dnstap output %q: unsupported kind %q", output, kind)
+	}
+
+	t := &dnstapLogger{
+		output:   output,
+		identity: []byte(identity),
+		events:   make(chan dnstapEvent, buffer),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run(w)
+	return t, nil
+}
+
+func resolveDNSTapAddr(kind, target string) (net.Addr, error) {
+	if kind == "unix" {
+		return &net.UnixAddr{Name: target, Net: "unix"}, nil
+	}
+	return net.ResolveTCPAddr("tcp", target)
+}
+
+// log queues one query/response pair. It never blocks: when the writer falls
+// behind, the event is dropped and counted instead.
+func (t *dnstapLogger) log(network string, client, server net.Addr, req *dns.Msg, queryTime time.Time, resp []byte) {
+	if t == nil {
+		return
+	}
+	ev := dnstapEvent{
+		network:      network,
+		client:       addrPort(client),
+		server:       addrPort(server),
+		query:        req,
+		queryTime:    queryTime,
+		response:     resp,
+		responseTime: time.Now(),
+	}
+	select {
+	case t.events <- ev:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// close drains the queued events and closes the output, giving up after
+// dnstapCloseTimeout when the collector is unreachable. Events logged
+// afterwards are dropped.
+func (t *dnstapLogger) close() {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-time.After(dnstapCloseTimeout):
+	}
+}
+
+func (t *dnstapLogger) stats() dnstapStats {
+	return dnstapStats{
+		Output:  t.output,
+		Sent:    t.sent.Load(),
+		Dropped: t.dropped.Load(),
+		Errors:  t.errors.Load(),
+	}
+}
+
+func (t *dnstapLogger) run(w dnstap.Writer) {
+	defer close(t.done)
+	defer func() { _ = w.Close() }()
+
+	flusher, _ := w.(interface{ Flush() error })
+	write := func(ev dnstapEvent) {
+		for _, frame := range t.frames(ev) {
+			if _, err := w.WriteFrame(frame); err != nil {
+				t.errors.Add(1)
+				continue
+			}
+			t.sent.Add(1)
+		}
+		if flusher != nil && len(t.events) == 0 {
+			if err := flusher.Flush(); err != nil {
+				t.errors.Add(1)
+			}
+		}
+	}
+
+	for {
+		select {
+		case ev := <-t.events:
+			write(ev)
+		case <-t.stop:
+			for {
+				select {
+				case ev := <-t.events:
+					write(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// frames encodes ev as an AUTH_QUERY and an AUTH_RESPONSE message. Packing
+// and marshaling happen here, off the query path.
+func (t *dnstapLogger) frames(ev dnstapEvent) [][]byte {
+	query, err := ev.query.Pack()
+	if err != nil {
+		t.errors.Add(1)
+		return nil
+	}
+
+	family := dnstap.SocketFamily_INET
+	if ev.client.Addr().Is6() && !ev.client.Addr().Is4In6() {
+		family = dnstap.SocketFamily_INET6
+	}
+	protocol := dnstapProtocol(ev.network)
+
+	base := func(typ dnstap.Message_Type) *dnstap.Message {
+		m := &dnstap.Message{
+			Type:           &typ,
+			SocketFamily:   &family,
+			SocketProtocol: &protocol,
+			QueryTimeSec:   proto.Uint64(uint64(ev.queryTime.Unix())),
+			QueryTimeNsec:  proto.Uint32(uint32(ev.queryTime.Nanosecond())),
+		}
+		if ev.client.IsValid() {
+			m.QueryAddress = ev.client.Addr().Unmap().AsSlice()
+			m.QueryPort = proto.Uint32(uint32(ev.client.Port()))
+		}
+		if ev.server.IsValid() {
+			m.ResponseAddress = ev.server.Addr().Unmap().AsSlice()
+			m.ResponsePort = proto.Uint32(uint32(ev.server.Port()))
+		}
+		return m
+	}
+
+	q := base(dnstap.Message_AUTH_QUERY)
+	q.QueryMessage = query
+
+	r := base(dnstap.Message_AUTH_RESPONSE)
+	r.QueryMessage = query
+	r.ResponseMessage = ev.response
+	r.ResponseTimeSec = proto.Uint64(uint64(ev.responseTime.Unix()))
+	r.ResponseTimeNsec = proto.Uint32(uint32(ev.responseTime.Nanosecond()))
+
+	out := make([][]byte, 0, 2)
+	for _, m := range []*dnstap.Message{q, r} {
+		frame, err := proto.Marshal(&dnstap.Dnstap{
+			Identity: t.identity,
+			Version:  []byte(dnstapVersion),
+			Type:     dnstap.Dnstap_MESSAGE.Enum(),
+			Message:  m,
+		})
+		if err != nil {
+			t.errors.Add(1)
+			continue
+		}
+		out = append(out, frame)
+	}
+	return out
+}
+
+func dnstapProtocol(network string) dnstap.SocketProtocol {
+	switch network {
+	case "tcp":
+		return dnstap.SocketProtocol_TCP
+	case "tcp-tls":
+		return dnstap.SocketProtocol_DOT
+	case "doh", "h3":
+		return dnstap.SocketProtocol_DOH
+	case "doq":
+		return dnstapProtocolDoQ
+	default:
+		return dnstap.SocketProtocol_UDP
+	}
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case nil:
+		return netip.AddrPort{}
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap
+}
+
+// dnstapFileWriter closes the underlying file along with the frame stream.
+type dnstapFileWriter struct {
+	dnstap.Writer
+	file *os.File
+}
+
+func (w *dnstapFileWriter) Flush() error {
+	if f, ok := w.Writer.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (w *dnstapFileWriter) Close() error {
+	return errors.Join(w.Writer.Close(), w.file.Close())
+}
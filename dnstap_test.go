@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+func readDNSTapFile(t *testing.T, path string) []*dnstap.Message {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read dnstap file: %v", err)
+	}
+	r, err := dnstap.NewReader(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("open dnstap reader: %v", err)
+	}
+	dec := dnstap.NewDecoder(r, 1<<16)
+
+	var out []*dnstap.Message
+	for {
+		var frame dnstap.Dnstap
+		if err := dec.Decode(&frame); err != nil {
+			break
+		}
+		if string(frame.Identity) != "test-node" {
+			t.Fatalf("unexpected dnstap identity %q", frame.Identity)
+		}
+		out = append(out, frame.Message)
+	}
+	return out
+}
+
+func TestDNSTapLogsUDPAndDoH(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.NodeID = "test-node"
+	s.cfg.DNSUDPListen = []string{"127.0.0.1:0"}
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	tap, err := newDNSTapLogger("file:"+path, s.cfg.NodeID, 16)
+	if err != nil {
+		t.Fatalf("newDNSTapLogger: %v", err)
+	}
+	s.tap = tap
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.runDNS(ctx, "udp") }()
+	addr := waitForListeners(t, s, 1)[0].Addr
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	c := &dns.Client{Net: "udp", Timeout: time.Second}
+	if _, _, err := c.Exchange(req, addr); err != nil {
+		t.Fatalf("udp exchange: %v", err)
+	}
+
+	wire, err := req.Pack()
+	if err != nil {
+		t.Fatalf("pack dns msg: %v", err)
+	}
+	httpReq := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(wire))
+	resp := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(resp, httpReq)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 from DoH POST, got %d", resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	s.newRouter().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/resolve?name=app.example.com&type=A", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 from JSON DoH, got %d", resp.Code)
+	}
+
+	tap.close()
+	msgs := readDNSTapFile(t, path)
+	if len(msgs) != 6 {
+		t.Fatalf("expected 6 dnstap messages, got %d", len(msgs))
+	}
+
+	want := []struct {
+		typ      dnstap.Message_Type
+		protocol dnstap.SocketProtocol
+	}{
+		{dnstap.Message_AUTH_QUERY, dnstap.SocketProtocol_UDP},
+		{dnstap.Message_AUTH_RESPONSE, dnstap.SocketProtocol_UDP},
+		{dnstap.Message_AUTH_QUERY, dnstap.SocketProtocol_DOH},
+		{dnstap.Message_AUTH_RESPONSE, dnstap.SocketProtocol_DOH},
+		{dnstap.Message_AUTH_QUERY, dnstap.SocketProtocol_DOH},
+		{dnstap.Message_AUTH_RESPONSE, dnstap.SocketProtocol_DOH},
+	}
+	for i, m := range msgs {
+		if m.GetType() != want[i].typ || m.GetSocketProtocol() != want[i].protocol {
+			t.Fatalf("message %d: got %s/%s, want %s/%s", i, m.GetType(), m.GetSocketProtocol(), want[i].typ, want[i].protocol)
+		}
+		if !net.IP(m.GetQueryAddress()).Equal(net.IPv4(192, 0, 2, 1)) && !net.IP(m.GetQueryAddress()).IsLoopback() {
+			t.Fatalf("message %d: unexpected query address %v", i, net.IP(m.GetQueryAddress()))
+		}
+		if m.GetType() == dnstap.Message_AUTH_RESPONSE {
+			var out dns.Msg
+			if err := out.Unpack(m.GetResponseMessage()); err != nil || len(out.Answer) != 1 {
+				t.Fatalf("message %d: bad response message: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestDNSTapUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dnstap")
+	if err != nil {
+		t.Fatalf("mkdir temp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "tap.sock")
+
+	input, err := dnstap.NewFrameStreamSockInputFromPath(sock)
+	if err != nil {
+		t.Fatalf("listen dnstap socket: %v", err)
+	}
+	frames := make(chan []byte, 4)
+	go input.ReadInto(frames)
+
+	tap, err := newDNSTapLogger("unix:"+sock, "test-node", 16)
+	if err != nil {
+		t.Fatalf("newDNSTapLogger: %v", err)
+	}
+	defer tap.close()
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	client := &net.UDPAddr{IP: net.IPv6loopback, Port: 5353}
+	tap.log("tcp", client, nil, req, time.Now(), []byte{0, 0})
+
+	for _, typ := range []dnstap.Message_Type{dnstap.Message_AUTH_QUERY, dnstap.Message_AUTH_RESPONSE} {
+		select {
+		case frame := <-frames:
+			var dt dnstap.Dnstap
+			if err := proto.Unmarshal(frame, &dt); err != nil {
+				t.Fatalf("unmarshal frame: %v", err)
+			}
+			m := dt.Message
+			if m.GetType() != typ || m.GetSocketProtocol() != dnstap.SocketProtocol_TCP || m.GetSocketFamily() != dnstap.SocketFamily_INET6 || m.GetQueryPort() != 5353 {
+				t.Fatalf("unexpected dnstap message: %v", m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s frame", typ)
+		}
+	}
+}
+
+func TestDNSTapNeverBlocks(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "missing.sock")
+	tap, err := newDNSTapLogger("unix:"+sock, "test-node", 1)
+	if err != nil {
+		t.Fatalf("newDNSTapLogger: %v", err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		tap.log("udp", nil, nil, req, time.Now(), nil)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("logging blocked on an unreachable collector for %s", elapsed)
+	}
+	if dropped := tap.stats().Dropped; dropped < 998 {
+		t.Fatalf("expected events to be dropped, got %d", dropped)
+	}
+}
+
+func TestNewDNSTapLoggerRejectsBadOutput(t *testing.T) {
+	for _, output := range []string{"", "/var/run/tap.sock", "udp:127.0.0.1:6000", "file:"} {
+		if _, err := newDNSTapLogger(output, "test-node", 1); err == nil {
+			t.Fatalf("expected error for output %q", output)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	drainStateKey    = "drain"
+	drainHookTimeout = 30 * time.Second
+)
+
+// restoreDrain reloads drain mode saved before a restart. The hook is not run
+// again; it already ran when the node entered drain.
+func (s *server) restoreDrain() error {
+	v, err := s.persist.loadNodeState(drainStateKey)
+	if err != nil || v == "" {
+		return err
+	}
+	var st drainState
+	if err := json.Unmarshal([]byte(v), &st); err != nil {
+		return fmt.Errorf("decode drain state: %w", err)
+	}
+	if st.Draining {
+		s.drain.Store(&st)
+	}
+	return nil
+}
+
+// drainRefused returns a REFUSED reply once the drain grace period is over,
+// or nil while the node should still answer. Serving nodes pay one atomic
+// load.
+func (s *server) drainRefused(req *dns.Msg) *dns.Msg {
+	st := s.drain.Load()
+	if st == nil || time.Now().Before(st.GraceUntil) {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.SetRcode(req, dns.RcodeRefused)
+	return resp
+}
+
+// enterDrain persists and publishes drain mode. It reports false when the
+// node was already draining, in which case only the reason is updated.
+func (s *server) enterDrain(reason string, grace time.Duration, now time.Time) (drainState, bool, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	st := drainState{Draining: true, Reason: reason, Since: now, GraceUntil: now.Add(grace)}
+	entered := true
+	if cur := s.drain.Load(); cur != nil {
+		st = *cur
+		if reason != "" {
+			st.Reason = reason
+		}
+		entered = false
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return drainState{}, false, err
+	}
+	if err := s.persist.saveNodeState(drainStateKey, string(b), now); err != nil {
+		return drainState{}, false, err
+	}
+	s.drain.Store(&st)
+	return st, entered, nil
+}
+
+// leaveDrain clears drain mode. It reports false when the node was not
+// draining.
+func (s *server) leaveDrain() (drainState, bool, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	cur := s.drain.Load()
+	if cur == nil {
+		return drainState{}, false, nil
+	}
+	if err := s.persist.deleteNodeState(drainStateKey); err != nil {
+		return drainState{}, false, err
+	}
+	s.drain.Store(nil)
+	return *cur, true, nil
+}
+
+// runDrainHook runs DRAIN_HOOK through the shell with DRAIN_EVENT set to
+// "enter" or "leave", so operators can withdraw or announce routes.
+func (s *server) runDrainHook(event string, st drainState) error {
+	if s.cfg.DrainHook == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", s.cfg.DrainHook)
+	cmd.Env = append(os.Environ(),
+		"DRAIN_EVENT="+event,
+		"DRAIN_REASON="+st.Reason,
+		"NODE_ID="+s.cfg.NodeID,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("drain hook %s failed: %v: %s", event, err, strings.TrimSpace(string(out)))
+		return err
+	}
+	return nil
+}
+
+func (s *server) drainStatus() map[string]any {
+	st := s.drain.Load()
+	if st == nil {
+		return map[string]any{"draining": false, "dns_answering": true}
+	}
+	return map[string]any{
+		"draining":      true,
+		"reason":        st.Reason,
+		"since":         st.Since,
+		"grace_until":   st.GraceUntil,
+		"dns_answering": time.Now().Before(st.GraceUntil),
+	}
+}
+
+func (s *server) handleDrainStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.drainStatus())
+}
+
+func (s *server) handleDrainEnter(w http.ResponseWriter, r *http.Request) {
+	var req drainRequest
+	if err := decodeJSON(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	grace := s.cfg.DrainGrace
+	if req.GraceSec != nil {
+		grace = time.Duration(*req.GraceSec) * time.Second
+	}
+
+	st, entered, err := s.enterDrain(strings.TrimSpace(req.Reason), grace, time.Now().UTC())
+	if err != nil {
+		s.persistFailed("node_state", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if entered {
+		log.Printf("entering drain: reason=%q grace_until=%s", st.Reason, st.GraceUntil.Format(time.RFC3339))
+		s.respondDrainHook(w, "enter", st)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.drainStatus())
+}
+
+func (s *server) handleDrainLeave(w http.ResponseWriter, _ *http.Request) {
+	st, left, err := s.leaveDrain()
+	if err != nil {
+		s.persistFailed("node_state", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if left {
+		log.Printf("leaving drain")
+		s.respondDrainHook(w, "leave", st)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.drainStatus())
+}
+
+// respondDrainHook runs the hook for event and reports the new status along
+// with any hook failure. The state change stands even if the hook fails.
+func (s *server) respondDrainHook(w http.ResponseWriter, event string, st drainState) {
+	out := s.drainStatus()
+	if err := s.runDrainHook(event, st); err != nil {
+		out["hook_error"] = err.Error()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
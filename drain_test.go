@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDrainHealthAndGrace(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1})
+	r := s.newRouter()
+
+	if resp := apiRequest(t, r, http.MethodGet, "/healthz", "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected healthy node, got %d", resp.Code)
+	}
+
+	if resp := apiRequest(t, r, http.MethodPost, "/v1/drain", `{"reason":"upgrade","grace_sec":3600}`, nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 entering drain, got %d: %s", resp.Code, resp.Body.String())
+	}
+	resp := apiRequest(t, r, http.MethodGet, "/healthz", "", nil)
+	if resp.Code != http.StatusServiceUnavailable || !strings.Contains(resp.Body.String(), `"reason":"upgrade"`) {
+		t.Fatalf("expected 503 while draining, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	res, err := s.answerDNS(req)
+	if err != nil || res.Rcode != dns.RcodeSuccess || res.Answers != 1 {
+		t.Fatalf("expected DNS answers during grace, rcode=%d answers=%d err=%v", res.Rcode, res.Answers, err)
+	}
+
+	// Entering again only updates the reason; shortening grace needs a new drain.
+	apiRequest(t, r, http.MethodPost, "/v1/drain", `{"reason":"kernel"}`, nil)
+	if st := s.drain.Load(); st == nil || st.Reason != "kernel" || time.Until(st.GraceUntil) < time.Minute {
+		t.Fatalf("unexpected drain state after re-enter: %+v", st)
+	}
+
+	apiRequest(t, r, http.MethodDelete, "/v1/drain", "", nil)
+	apiRequest(t, r, http.MethodPost, "/v1/drain", `{"grace_sec":0}`, nil)
+	res, err = s.answerDNS(req)
+	if err != nil || res.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED after grace, rcode=%d err=%v", res.Rcode, err)
+	}
+	if resp := apiRequest(t, r, http.MethodGet, "/resolve?name=app.example.com", "", nil); !strings.Contains(resp.Body.String(), `"Status":5`) {
+		t.Fatalf("expected JSON DoH refused after grace, got %s", resp.Body.String())
+	}
+
+	if resp := apiRequest(t, r, http.MethodDelete, "/v1/drain", "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 leaving drain, got %d", resp.Code)
+	}
+	if resp := apiRequest(t, r, http.MethodGet, "/healthz", "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("expected healthy after leaving drain, got %d", resp.Code)
+	}
+	if res, _ := s.answerDNS(req); res.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected answers after leaving drain, got rcode %d", res.Rcode)
+	}
+}
+
+func TestDrainSurvivesRestart(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	apiRequest(t, r, http.MethodPost, "/v1/drain", `{"reason":"upgrade"}`, nil)
+
+	restarted := &server{cfg: s.cfg, data: newStore(), persist: s.persist, start: time.Now()}
+	if err := restarted.restoreDrain(); err != nil {
+		t.Fatalf("restoreDrain: %v", err)
+	}
+	st := restarted.drain.Load()
+	if st == nil || st.Reason != "upgrade" {
+		t.Fatalf("expected drain restored, got %+v", st)
+	}
+
+	apiRequest(t, restarted.newRouter(), http.MethodDelete, "/v1/drain", "", nil)
+	again := &server{cfg: s.cfg, data: newStore(), persist: s.persist, start: time.Now()}
+	if err := again.restoreDrain(); err != nil {
+		t.Fatalf("restoreDrain: %v", err)
+	}
+	if again.drain.Load() != nil {
+		t.Fatal("cleared drain must not come back after restart")
+	}
+}
+
+func TestDrainHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.log")
+	s := newTestServer(t)
+	s.cfg.DrainHook = `echo "$DRAIN_EVENT $DRAIN_REASON $NODE_ID" >> ` + out
+	r := s.newRouter()
+
+	apiRequest(t, r, http.MethodPost, "/v1/drain", `{"reason":"upgrade"}`, nil)
+	apiRequest(t, r, http.MethodPost, "/v1/drain", `{"reason":"again"}`, nil)
+	apiRequest(t, r, http.MethodDelete, "/v1/drain", "", nil)
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read hook output: %v", err)
+	}
+	if got, want := string(b), "enter upgrade test-node\nleave again test-node\n"; got != want {
+		t.Fatalf("hook output = %q, want %q", got, want)
+	}
+
+	s.cfg.DrainHook = "exit 3"
+	resp := apiRequest(t, r, http.MethodPost, "/v1/drain", "", nil)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "hook_error") {
+		t.Fatalf("expected hook failure reported, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if s.drain.Load() == nil {
+		t.Fatal("drain must stand even when the hook fails")
+	}
+}
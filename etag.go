@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// precondition is a conditional-request guard (If-Match, If-None-Match or
+// If-Zone-Match). It is checked inside the store transaction that performs
+// the write, so a concurrent writer cannot slip in between check and write.
+type precondition struct {
+	// Name and Type select an RRset; an empty Type covers every RRset of
+	// the name. Without a Name the guard applies to Zone.
+	Name string
+	Type string
+	Zone string
+	// Match lists the acceptable entity tags, "*" accepting any existing
+	// entity. NoneMatch requires that the entity does not exist.
+	Match     []string
+	NoneMatch bool
+}
+
+// etag returns the guarded entity's current tag, or "" when it does not
+// exist.
+func (p precondition) etag(v *storeView) string {
+	if p.Name != "" {
+		return v.rrsetETag(p.Name, p.Type)
+	}
+	z, ok := v.getZone(p.Zone)
+	if !ok {
+		return ""
+	}
+	return zoneETag(z)
+}
+
+func (p precondition) holds(v *storeView) bool {
+	current := p.etag(v)
+	if p.NoneMatch && current != "" {
+		return false
+	}
+	if p.Match == nil {
+		return true
+	}
+	if current == "" {
+		return false
+	}
+	for _, tag := range p.Match {
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// rrsetETag returns the entity tag of the live RRset name/recordType, or of
+// all live RRsets of name when recordType is empty, or "" when there are
+// none. Expired records the sweeper has not removed yet do not count. Every
+// write stamps records with a new version, so the tag changes with every
+// change to the set.
+func (v *storeView) rrsetETag(name, recordType string) string {
+	name = normalizeName(name)
+	recordType = strings.ToUpper(strings.TrimSpace(recordType))
+
+	var now time.Time
+	var lines []string
+	for typ, set := range v.records[shardFor(name)][name] {
+		if recordType != "" && typ != recordType {
+			continue
+		}
+		for key, rec := range set {
+			if !rec.liveAt(&now) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s|%d|%d|%d", key, rec.TTL, rec.Version, rec.ExpiresAt.UnixNano()))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+
+	h := fnv.New64a()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// zoneETag is the zone-level version: the SOA serial, which advances on
+// every change to the zone or its records.
+func zoneETag(z zoneConfig) string {
+	return `"` + strconv.FormatUint(uint64(z.Serial), 10) + `"`
+}
+
+// parseETags splits an If-Match style header into its entity tags.
+func parseETags(h string) []string {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return nil
+	}
+	var out []string
+	for _, tag := range strings.Split(h, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// recordGuards returns the preconditions of a record write: If-Match and
+// If-None-Match on the RRset name/recordType, and If-Zone-Match on zone.
+func recordGuards(r *http.Request, name, recordType, zone string) []precondition {
+	var guards []precondition
+	match := parseETags(r.Header.Get("If-Match"))
+	noneMatch := strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+	if match != nil || noneMatch {
+		guards = append(guards, precondition{Name: name, Type: recordType, Match: match, NoneMatch: noneMatch})
+	}
+	if zoneMatch := parseETags(r.Header.Get("If-Zone-Match")); zoneMatch != nil {
+		guards = append(guards, precondition{Zone: zone, Match: zoneMatch})
+	}
+	return guards
+}
+
+// zoneGuards returns the If-Match and If-None-Match preconditions of a zone
+// write.
+func zoneGuards(r *http.Request, zone string) []precondition {
+	match := parseETags(r.Header.Get("If-Match"))
+	noneMatch := strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+	if match == nil && !noneMatch {
+		return nil
+	}
+	return []precondition{{Zone: zone, Match: match, NoneMatch: noneMatch}}
+}
+
+// guardsHold checks guards against the current snapshot and answers 412 when
+// one fails. Handlers call it before side effects such as creating a zone;
+// the write itself checks the guards again atomically.
+func (s *server) guardsHold(w http.ResponseWriter, guards []precondition) bool {
+	view := s.data.view()
+	if i := failedGuard(view, guards); i >= 0 {
+		writePreconditionFailed(w, guards[i].etag(view))
+		return false
+	}
+	return true
+}
+
+func setETag(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// writePreconditionFailed answers 412 with the guarded entity's current tag.
+func writePreconditionFailed(w http.ResponseWriter, etag string) {
+	setETag(w, etag)
+	writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "precondition failed", "etag": etag})
+}
+
+// failedGuard returns the index of the first guard that does not hold in v,
+// or -1 when all hold.
+func failedGuard(v *storeView, guards []precondition) int {
+	for i, g := range guards {
+		if !g.holds(v) {
+			return i
+		}
+	}
+	return -1
+}
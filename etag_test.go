@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecordETagIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+
+	rr := apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.1","propagate":false}`, map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	created := rr.Header().Get("ETag")
+	if created == "" {
+		t.Fatal("expected ETag on write")
+	}
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.9","propagate":false}`, map[string]string{"If-None-Match": "*"}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("create over existing: expected 412, got %d", rr.Code)
+	}
+
+	rr = apiRequest(t, h, http.MethodGet, "/v1/records/app.example.com?type=A", "", nil)
+	if got := rr.Header().Get("ETag"); got != created {
+		t.Fatalf("expected GET ETag %q, got %q", created, got)
+	}
+
+	rr = apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.2","propagate":false}`, map[string]string{"If-Match": created})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	updated := rr.Header().Get("ETag")
+	if updated == "" || updated == created {
+		t.Fatalf("expected a new ETag after update, got %q", updated)
+	}
+
+	// A writer still holding the first ETag loses.
+	rr = apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.3","propagate":false}`, map[string]string{"If-Match": created})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale update: expected 412, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("ETag"); got != updated {
+		t.Fatalf("expected current ETag %q on 412, got %q", updated, got)
+	}
+	if rec, _ := s.data.getRecord("app.example.com."); rec.IP != "192.0.2.2" {
+		t.Fatalf("expected stale write rejected, got %+v", rec)
+	}
+
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/records/app.example.com/add", `{"ip":"192.0.2.4","propagate":false}`, map[string]string{"If-Match": created}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale add: expected 412, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/records/app.example.com?type=A&propagate=false", "", map[string]string{"If-Match": created}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale delete: expected 412, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/records/app.example.com?type=A&propagate=false", "", map[string]string{"If-Match": updated}); rr.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", rr.Code)
+	}
+	if s.data.hasName("app.example.com.") {
+		t.Fatal("expected record deleted")
+	}
+}
+
+func TestRecordETagIgnoresExpiredRecords(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	s.data.setRecord(aRecord{Name: "lease.example.com.", Type: "A", IP: "192.0.2.1", TTL: 20, Zone: "example.com.", Version: 1, ExpiresAt: time.Now().Add(-time.Second)})
+
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records/lease.example.com", "", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expired RRset: expected 404, got %d", rr.Code)
+	}
+	rr := apiRequest(t, h, http.MethodPut, "/v1/records/lease.example.com", `{"ip":"192.0.2.2","propagate":false}`, map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create over expired RRset: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestZoneETag(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+
+	rr := apiRequest(t, h, http.MethodPut, "/v1/zones/example.com", `{"propagate":false}`, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("zone put: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/v1/zones/example.com", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("zone get: expected 200, got %d", rr.Code)
+	}
+	zoneTag := rr.Header().Get("ETag")
+	z, _ := s.data.getZone("example.com.")
+	if zoneTag != zoneETag(z) {
+		t.Fatalf("expected zone ETag %s, got %q", zoneETag(z), zoneTag)
+	}
+
+	// Record writes bump the serial, so the zone version guards the zone.
+	rr = apiRequest(t, h, http.MethodPut, "/v1/records/a.example.com", `{"ip":"192.0.2.1","propagate":false}`, map[string]string{"If-Zone-Match": zoneTag})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("record put: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/b.example.com", `{"ip":"192.0.2.2","propagate":false}`, map[string]string{"If-Zone-Match": zoneTag}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale zone guard: expected 412, got %d", rr.Code)
+	}
+	if s.data.hasName("b.example.com.") {
+		t.Fatal("expected guarded write rejected")
+	}
+
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/zones/example.com", `{"soa_ttl":60,"propagate":false}`, map[string]string{"If-Match": zoneTag}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale zone put: expected 412, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/zones/example.com?cascade=true&propagate=false", "", map[string]string{"If-Match": zoneTag}); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale zone delete: expected 412, got %d", rr.Code)
+	}
+	current, _ := s.data.getZone("example.com.")
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/zones/example.com?cascade=true&propagate=false", "", map[string]string{"If-Match": zoneETag(current)}); rr.Code != http.StatusOK {
+		t.Fatalf("zone delete: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestChangesIfMatch(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	s.data.setRecord(aRecord{Name: "app.example.com.", Type: "A", IP: "192.0.2.1", TTL: 20, Zone: "example.com.", Version: 1})
+	current := s.data.view().rrsetETag("app.example.com.", "A")
+
+	body := `{"propagate":false,"changes":[
+		{"op":"set","name":"new.example.com","ip":"192.0.2.5"},
+		{"op":"set","name":"app.example.com","ip":"192.0.2.2","if_match":"\"stale\""}
+	]}`
+	rr := apiRequest(t, h, http.MethodPost, "/v1/changes", body, nil)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Change int    `json:"change"`
+		ETag   string `json:"etag"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Change != 1 || resp.ETag != current {
+		t.Fatalf("unexpected 412 body: %s", rr.Body.String())
+	}
+	if s.data.hasName("new.example.com.") {
+		t.Fatal("expected the whole batch rejected")
+	}
+
+	body = `{"propagate":false,"changes":[{"op":"set","name":"app.example.com","ip":"192.0.2.2","if_match":` + jsonString(current) + `}]}`
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/changes", body, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
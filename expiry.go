@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runExpirySweeper removes records past their expires_at every
+// EXPIRY_SWEEP_INTERVAL until ctx is done. The resolver already hides them;
+// the sweep reclaims memory and SQLite rows and tells peers.
+func (s *server) runExpirySweeper(ctx context.Context) {
+	s.sweepExpired(time.Now().UTC())
+
+	ticker := time.NewTicker(s.cfg.ExpirySweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sweepExpired(now.UTC())
+		}
+	}
+}
+
+// sweepExpired deletes expired records, bumping the serial of each affected
+// zone once in the same store transaction, and propagates a remove event per
+// record. It returns the number of records removed.
+func (s *server) sweepExpired(now time.Time) int {
+	expired, bumped := s.data.expireRecords(now)
+	if len(expired) == 0 {
+		return 0
+	}
+
+	zones := make(map[string]*zoneConfig, len(bumped))
+	for zone, z := range bumped {
+		if err := s.persist.upsertZone(z); err != nil {
+			s.persistFailed("upsert_zone", err)
+		}
+		zones[zone] = &z
+	}
+
+	for _, rec := range expired {
+		version := now.UnixNano()
+		if rec.Version > version {
+			version = rec.Version
+		}
+		if err := s.persist.removeRecord(rec, version); err != nil {
+			s.persistFailed("remove_record", err)
+		}
+		s.propagate(syncEvent{
+			OriginNode: s.cfg.NodeID,
+			Op:         "remove",
+			Record:     &rec,
+			Version:    version,
+			EventTime:  now,
+			ZoneConfig: zones[rec.Zone],
+		})
+	}
+
+	log.Printf("expiry sweep removed %d records", len(expired))
+	return len(expired)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestExpiredRecordsHiddenBeforeSweep(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "_acme-challenge.example.com", Zone: "example.com", Type: "TXT", Text: "token", TTL: 20, Version: 1, ExpiresAt: now.Add(-time.Second)})
+	s.data.addRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.1", TTL: 20, Version: 1, ExpiresAt: now.Add(-time.Second)})
+	s.data.addRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.2", TTL: 20, Version: 1, ExpiresAt: now.Add(time.Hour)})
+
+	req := new(dns.Msg)
+	req.SetQuestion("_acme-challenge.example.com.", dns.TypeTXT)
+	if resp := s.resolveDNS(req); resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for expired name, got %d", resp.Rcode)
+	}
+
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	resp := s.resolveDNS(req)
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "198.51.100.2" {
+		t.Fatalf("expected only the live A record, got %v", resp.Answer)
+	}
+	if got := len(s.data.listRecords()); got != 1 {
+		t.Fatalf("expected expired records hidden from list, got %d", got)
+	}
+}
+
+func TestSweepExpiredBumpsSerialWithRemoval(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, SerialScheme: serialSchemeCounter, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "_acme-challenge.example.com", Zone: "example.com", Type: "TXT", Text: "token", TTL: 20, Version: 1, ExpiresAt: now.Add(-time.Second)})
+
+	// No published snapshot may show the record gone under the old serial.
+	s.data.subscribe(func(change storeChange) {
+		z, _ := change.After.getZone("example.com")
+		if !change.After.hasName("_acme-challenge.example.com.") && z.Serial == 1 {
+			t.Errorf("snapshot without the expired record still at serial %d", z.Serial)
+		}
+	})
+	if n := s.sweepExpired(now); n != 1 {
+		t.Fatalf("expected 1 record swept, got %d", n)
+	}
+	if z, _ := s.data.getZone("example.com"); z.Serial != 2 {
+		t.Fatalf("expected serial 2 after the sweep, got %d", z.Serial)
+	}
+}
+
+func TestSweepExpiredRemovesAndPropagates(t *testing.T) {
+	var mu sync.Mutex
+	var events []syncEvent
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev syncEvent
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer peer.Close()
+
+	s := newTestServer(t)
+	s.cfg.Peers = []string{peer.URL}
+	r := s.newRouter()
+
+	put := func(name, body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/v1/records/"+name, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("put %s: expected 200, got %d: %s", name, resp.Code, resp.Body.String())
+		}
+	}
+	put("_acme-challenge.example.com", `{"type":"TXT","text":"token","lease_ttl":60,"propagate":false}`)
+	put("app.example.com", `{"ip":"198.51.100.1","propagate":false}`)
+
+	rec, ok := s.data.getRecord("_acme-challenge.example.com")
+	if !ok || rec.ExpiresAt.IsZero() {
+		t.Fatalf("expected lease to set expires_at, got %+v", rec)
+	}
+	before, _ := s.data.getZone("example.com")
+
+	if n := s.sweepExpired(time.Now().UTC()); n != 0 {
+		t.Fatalf("nothing should expire yet, removed %d", n)
+	}
+	if n := s.sweepExpired(rec.ExpiresAt.Add(time.Second)); n != 1 {
+		t.Fatalf("expected one expired record, removed %d", n)
+	}
+
+	if s.data.view().hasName("_acme-challenge.example.com") || len(s.data.view().expiredRecords(rec.ExpiresAt.Add(time.Second))) != 0 {
+		t.Fatal("expired record still in store")
+	}
+	var rows int64
+	s.persist.db.Model(&recordModel{}).Where("name = ?", "_acme-challenge.example.com.").Count(&rows)
+	if rows != 0 {
+		t.Fatalf("expected expired row deleted from SQLite, found %d", rows)
+	}
+	if _, ok := s.data.getRecord("app.example.com"); !ok {
+		t.Fatal("record without expiry must survive the sweep")
+	}
+	after, _ := s.data.getZone("example.com")
+	if !serialGreater(after.Serial, before.Serial) {
+		t.Fatalf("expected serial bump, %d -> %d", before.Serial, after.Serial)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].Op != "remove" || events[0].Record == nil || events[0].Record.Text != "token" {
+		t.Fatalf("expected one replicated remove, got %+v", events)
+	}
+	if events[0].ZoneConfig == nil || events[0].ZoneConfig.Serial != after.Serial {
+		t.Fatalf("expected remove to carry serial %d, got %+v", after.Serial, events[0].ZoneConfig)
+	}
+}
+
+func TestRecordExpiryValidation(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, body := range []string{
+		`{"ip":"198.51.100.1","expires_at":"` + past + `"}`,
+		`{"ip":"198.51.100.1","expires_at":"` + future + `","lease_ttl":60}`,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/v1/records/app.example.com", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	changeKindRRset = "rrset"
+	changeKindZone  = "zone"
+
+	// maxHistoryPage bounds the limit of one GET /v1/history page.
+	maxHistoryPage = 1000
+)
+
+type actorContextKey struct{}
+
+// withActor records the authenticated identity on the request for the
+// change log.
+func withActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), actorContextKey{}, actor))
+}
+
+// requestActor returns the identity the API authenticated r as.
+func requestActor(r *http.Request) string {
+	actor, _ := r.Context().Value(actorContextKey{}).(string)
+	return actor
+}
+
+// requestAuthor attributes a change made through the API on this node.
+func (s *server) requestAuthor(r *http.Request, reason string) changeAuthor {
+	return changeAuthor{Actor: requestActor(r), ClientIP: clientIP(r), Origin: s.cfg.NodeID, Reason: reason}
+}
+
+// syncAuthor attributes a replicated change to the actor on its origin
+// node, with the peer's address.
+func syncAuthor(r *http.Request, ev syncEvent) changeAuthor {
+	actor := ev.Actor
+	if actor == "" {
+		actor = "sync"
+	}
+	return changeAuthor{Actor: actor, ClientIP: clientIP(r), Origin: ev.OriginNode, Reason: ev.Op}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordChangeLog appends an entry for every RRset and zone config a store
+// transaction changed. It runs as a store watcher and hands the entries to
+// the write queue, so they are written in commit order without holding the
+// store lock. Serial-only zone updates are not logged; every record change
+// causes one.
+func (s *server) recordChangeLog(change storeChange) {
+	entries := changeLogEntries(change)
+	if len(entries) == 0 {
+		return
+	}
+	author := change.Author
+	if author.Actor == "" {
+		author.Actor = "system"
+	}
+	if author.Origin == "" {
+		author.Origin = s.cfg.NodeID
+	}
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].At = now
+		entries[i].Actor = author.Actor
+		entries[i].ClientIP = author.ClientIP
+		entries[i].Origin = author.Origin
+		entries[i].Reason = author.Reason
+	}
+	s.writes.enqueue(func() {
+		if err := s.persist.appendChangeLog(entries); err != nil {
+			s.persistFailed("change_log", err)
+		}
+	})
+}
+
+// changeLogEntries diffs the snapshots on either side of change.
+func changeLogEntries(change storeChange) []changeLogEntry {
+	if change.Before == nil || change.After == nil {
+		return nil
+	}
+	var out []changeLogEntry
+	for _, name := range change.Names {
+		before := change.Before.records[shardFor(name)][name]
+		after := change.After.records[shardFor(name)][name]
+		types := make([]string, 0, len(before)+len(after))
+		for typ := range before {
+			types = append(types, typ)
+		}
+		for typ := range after {
+			if _, ok := before[typ]; !ok {
+				types = append(types, typ)
+			}
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			b, a := before[typ], after[typ]
+			if rrsetEqual(b, a) {
+				continue
+			}
+			e := changeLogEntry{
+				Kind:   changeKindRRset,
+				Op:     changeOp(len(b) > 0, len(a) > 0),
+				Name:   name,
+				Type:   typ,
+				Before: rrsetRecords(b),
+				After:  rrsetRecords(a),
+			}
+			if len(e.After) > 0 {
+				e.Zone = e.After[0].Zone
+			} else if len(e.Before) > 0 {
+				e.Zone = e.Before[0].Zone
+			}
+			out = append(out, e)
+		}
+	}
+	for _, zone := range change.Zones {
+		b, hadZone := change.Before.zones[zone]
+		a, hasZone := change.After.zones[zone]
+		if hadZone == hasZone && (!hasZone || zoneSettingsEqual(b, a)) {
+			continue
+		}
+		e := changeLogEntry{Kind: changeKindZone, Op: changeOp(hadZone, hasZone), Zone: zone}
+		if hadZone {
+			e.ZoneBefore = &b
+		}
+		if hasZone {
+			e.ZoneAfter = &a
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func changeOp(existed, exists bool) string {
+	switch {
+	case !existed:
+		return "create"
+	case !exists:
+		return "delete"
+	}
+	return "update"
+}
+
+func rrsetEqual(a, b rrset) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, rec := range a {
+		if other, ok := b[key]; !ok || other != rec {
+			return false
+		}
+	}
+	return true
+}
+
+// rrsetRecords returns the records of set in key order, or nil when empty.
+func rrsetRecords(set rrset) []aRecord {
+	if len(set) == 0 {
+		return nil
+	}
+	out := make([]aRecord, 0, len(set))
+	for _, rec := range set {
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return recordKey(out[i]) < recordKey(out[j]) })
+	return out
+}
+
+// zoneSettingsEqual compares zone configs without their serial and update
+// time, which move with every record change.
+func zoneSettingsEqual(a, b zoneConfig) bool {
+	return slices.Equal(a.NS, b.NS) &&
+		a.SOATTL == b.SOATTL &&
+		a.Refresh == b.Refresh &&
+		a.Retry == b.Retry &&
+		a.Expire == b.Expire &&
+		a.NegativeTTL == b.NegativeTTL &&
+		a.Mbox == b.Mbox &&
+		a.MName == b.MName &&
+		a.SerialScheme == b.SerialScheme
+}
+
+// handleHistory lists change log entries, newest first.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := changeLogQuery{
+		Kind:  strings.ToLower(strings.TrimSpace(q.Get("kind"))),
+		Actor: strings.TrimSpace(q.Get("actor")),
+		Type:  strings.ToUpper(strings.TrimSpace(q.Get("type"))),
+		Limit: 100,
+	}
+	if v := strings.TrimSpace(q.Get("zone")); v != "" {
+		query.Zone = normalizeName(v)
+	}
+	if v := strings.TrimSpace(q.Get("name")); v != "" {
+		query.Name = normalizeName(v)
+	}
+	if query.Kind != "" && query.Kind != changeKindRRset && query.Kind != changeKindZone {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "kind must be rrset or zone"})
+		return
+	}
+	for _, p := range []struct {
+		key string
+		dst *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if v := strings.TrimSpace(q.Get(p.key)); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": p.key + " must be an RFC 3339 time"})
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryPage {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxHistoryPage)})
+			return
+		}
+		query.Limit = n
+	}
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || id == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		query.BeforeID = id
+	}
+
+	if t := requestAPIToken(r); t.restricted() {
+		switch {
+		case query.Name != "" && t.allowsName(query.Name):
+		case query.Zone != "" && len(t.Names) == 0 && t.allowsZone(query.Zone):
+		default:
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "token is limited to some zones or names; filter by one of them"})
+			return
+		}
+	}
+
+	s.writes.flush()
+	entries, err := s.persist.queryChangeLog(query)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	resp := map[string]any{"entries": entries}
+	if len(entries) == query.Limit {
+		resp["next_cursor"] = strconv.FormatUint(entries[len(entries)-1].ID, 10)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// rollbackRRset is one RRset a rollback restores, from its current records
+// to those it had at the target time.
+type rollbackRRset struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Zone    string    `json:"zone"`
+	Current []aRecord `json:"current"`
+	Restore []aRecord `json:"restore"`
+}
+
+// planRollback works out what restoring req's RRset, name or zone to req.At
+// changes. The state at a time is the "before" of the first change logged
+// after it, so RRsets and zone configs untouched since then are left alone.
+// Zones that did not exist then are not deleted.
+func (s *server) planRollback(req rollbackRequest, now time.Time) ([]rollbackRRset, []zoneConfig, error) {
+	query := changeLogQuery{Since: req.At.Add(time.Nanosecond), Ascending: true}
+	if req.Zone != "" {
+		query.Zone = req.Zone
+	} else {
+		query.Name, query.Type, query.Kind = req.Name, req.Type, changeKindRRset
+	}
+	s.writes.flush()
+	entries, err := s.persist.queryChangeLog(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	view := s.data.view()
+	seen := make(map[string]struct{})
+	rrsets := []rollbackRRset{}
+	zones := []zoneConfig{}
+	for _, e := range entries {
+		key := e.Kind + "|" + e.Zone
+		if e.Kind == changeKindRRset {
+			key = e.Kind + "|" + e.Name + "|" + e.Type
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		if e.Kind == changeKindZone {
+			if e.ZoneBefore == nil {
+				continue
+			}
+			z := *e.ZoneBefore
+			cur, ok := view.getZone(z.Zone)
+			if ok && zoneSettingsEqual(cur, z) {
+				continue
+			}
+			base := cur.Serial
+			if !ok {
+				base = s.deletedSerial(z.Zone)
+			}
+			z.Serial = nextSerial(z.SerialScheme, base, now)
+			z.UpdatedAt = now
+			zones = append(zones, z)
+			continue
+		}
+
+		restore := make([]aRecord, 0, len(e.Before))
+		for _, rec := range e.Before {
+			if !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt) {
+				continue
+			}
+			restore = append(restore, rec)
+		}
+		current := rrsetRecords(view.rrset(e.Name, e.Type))
+		if restoredEqual(current, restore) {
+			continue
+		}
+		if current == nil {
+			current = []aRecord{}
+		}
+		zone := e.Zone
+		if len(restore) > 0 {
+			zone = restore[0].Zone
+		}
+		rrsets = append(rrsets, rollbackRRset{Name: e.Name, Type: e.Type, Zone: zone, Current: current, Restore: restore})
+	}
+	return rrsets, zones, nil
+}
+
+// restoredEqual reports whether current already serves the records of
+// restore, ignoring versions and update times.
+func restoredEqual(current, restore []aRecord) bool {
+	if len(current) != len(restore) {
+		return false
+	}
+	want := make(map[string]aRecord, len(restore))
+	for _, rec := range restore {
+		want[recordKey(rec)] = rec
+	}
+	for _, rec := range current {
+		other, ok := want[recordKey(rec)]
+		if !ok || other.TTL != rec.TTL || !other.ExpiresAt.Equal(rec.ExpiresAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleRollback restores an RRset, all RRsets of a name, or a whole zone to
+// how it was at a past time. The restore is applied like a change batch:
+// atomically, with one serial bump per zone, and replicated as one batch.
+func (s *server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	var req rollbackRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	now := time.Now().UTC()
+	if err := normalizeRollbackRequest(&req, now); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	t := requestAPIToken(r)
+	if req.Zone != "" && t != nil && (!t.allowsZone(req.Zone) || len(t.Names) > 0 || !t.has(scopeZoneAdmin)) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "token may not roll back zone " + req.Zone})
+		return
+	}
+	if req.Name != "" && !t.allowsName(req.Name) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "token may not roll back " + req.Name})
+		return
+	}
+
+	rrsets, restoredZones, err := s.planRollback(req, now)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		writeJSON(w, http.StatusOK, map[string]any{"at": req.At, "dry_run": true, "rrsets": rrsets, "zones": restoredZones})
+		return
+	}
+
+	version := now.UnixNano()
+	var changes []recordChange
+	for _, set := range rrsets {
+		changes = append(changes, recordChange{Op: "delete", Name: set.Name, Type: set.Type, Zone: set.Zone, Version: version})
+	}
+	for _, set := range rrsets {
+		for _, rec := range set.Restore {
+			rec.Version = version
+			rec.UpdatedAt = now
+			rec.Source = s.cfg.NodeID
+			changes = append(changes, recordChange{Op: "add", Record: &rec, Zone: rec.Zone, Version: version})
+		}
+	}
+
+	author := s.requestAuthor(r, "rollback")
+	var zones []zoneConfig
+	applied, _ := s.data.applyChanges(author, nil, changes, func(tx *storeTxn, applied []bool) {
+		touched := make(map[string]struct{})
+		for _, z := range restoredZones {
+			if tx.upsertZone(z) {
+				touched[z.Zone] = struct{}{}
+			}
+		}
+		for zone := range changedZones(changes, applied) {
+			if _, ok := touched[zone]; ok {
+				continue
+			}
+			if _, ok := tx.bumpZoneSerial(zone, now); ok {
+				touched[zone] = struct{}{}
+			}
+		}
+		zones = tx.view.zoneConfigs(touched)
+	})
+	if err := s.persist.applyChanges(changes, applied, zones); err != nil {
+		s.persistFailed("rollback", err)
+	}
+	if zones == nil {
+		zones = []zoneConfig{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"at": req.At, "version": version, "rrsets": rrsets, "zones": zones})
+
+	if shouldPropagate(req.Propagate) && (len(changes) > 0 || len(zones) > 0) {
+		go s.propagateRollback(author, zones, changes, version, now)
+	}
+}
+
+// propagateRollback replicates a rollback as one batch event carrying the
+// record restore and the restored and bumped zone configs, so peers never
+// apply the records and zone configs in different orders.
+func (s *server) propagateRollback(author changeAuthor, zones []zoneConfig, changes []recordChange, version int64, now time.Time) {
+	s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "batch", Version: version, EventTime: now, Changes: changes, Zones: zones, Actor: author.Actor})
+}
+
+func normalizeRollbackRequest(req *rollbackRequest, now time.Time) error {
+	req.Zone = strings.TrimSpace(req.Zone)
+	req.Name = strings.TrimSpace(req.Name)
+	req.Type = strings.ToUpper(strings.TrimSpace(req.Type))
+	if (req.Zone == "") == (req.Name == "") {
+		return errors.New("exactly one of zone or name is required")
+	}
+	if req.Zone != "" {
+		if req.Type != "" {
+			return errors.New("type applies to name rollbacks only")
+		}
+		req.Zone = normalizeName(req.Zone)
+	} else {
+		req.Name = normalizeName(req.Name)
+		if !validDeleteType(req.Type) {
+			return errors.New("type must be A, AAAA, TXT, CNAME or MX")
+		}
+	}
+	if req.At.IsZero() {
+		return errors.New("at is required")
+	}
+	if req.At.After(now) {
+		return fmt.Errorf("at %s is in the future", req.At.Format(time.RFC3339))
+	}
+	return nil
+}
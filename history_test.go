@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newHistoryTestServer(t *testing.T) (*server, http.Handler) {
+	t.Helper()
+	s := newTestServer(t)
+	s.data.subscribe(s.recordChangeLog)
+	return s, s.newRouter()
+}
+
+func historyEntries(t *testing.T, h http.Handler, query string) []changeLogEntry {
+	t.Helper()
+	rr := apiRequest(t, h, http.MethodGet, "/v1/history"+query, "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("history: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Entries []changeLogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode history: %v", err)
+	}
+	return resp.Entries
+}
+
+func TestChangeLogRecordsAuthorAndState(t *testing.T) {
+	_, h := newHistoryTestServer(t)
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		if rr := apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"`+ip+`","propagate":false}`, nil); rr.Code != http.StatusOK {
+			t.Fatalf("put: expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	entries := historyEntries(t, h, "?name=app.example.com&type=A")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	latest, first := entries[0], entries[1]
+	if latest.Op != "update" || first.Op != "create" {
+		t.Fatalf("expected newest first, got %s then %s", latest.Op, first.Op)
+	}
+	if latest.Actor != "api-token" || latest.ClientIP != "192.0.2.1" || latest.Origin != "test-node" || latest.Reason != "upsert_record" {
+		t.Fatalf("unexpected author: %+v", latest)
+	}
+	if latest.Zone != "example.com." || len(latest.Before) != 1 || latest.Before[0].IP != "192.0.2.1" || len(latest.After) != 1 || latest.After[0].IP != "192.0.2.2" {
+		t.Fatalf("unexpected state: %+v", latest)
+	}
+	if first.Before != nil {
+		t.Fatalf("expected no state before create, got %+v", first.Before)
+	}
+
+	// The zone was created for the first record; serial bumps are not logged.
+	zones := historyEntries(t, h, "?kind=zone")
+	if len(zones) != 1 || zones[0].Op != "create" || zones[0].ZoneAfter == nil || zones[0].ZoneAfter.Zone != "example.com." {
+		t.Fatalf("expected one zone create entry, got %+v", zones)
+	}
+
+	page := historyEntries(t, h, "?limit=1&cursor="+strconv.FormatUint(latest.ID, 10))
+	if len(page) != 1 || page[0].ID >= latest.ID {
+		t.Fatalf("expected an older entry after the cursor, got %+v", page)
+	}
+
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/history?since=yesterday", "", nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad since: expected 400, got %d", rr.Code)
+	}
+}
+
+func TestChangeLogRecordsSyncOrigin(t *testing.T) {
+	s, h := newHistoryTestServer(t)
+
+	body := `{"origin_node":"peer","op":"set","actor":"alice","version":5,"record":{"name":"www.example.net.","type":"A","ip":"198.51.100.7","zone":"example.net."}}`
+	rr := apiRequest(t, h, http.MethodPost, "/v1/sync/event", body, map[string]string{"X-Sync-Token": "sync-token"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sync: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := s.data.getRecord("www.example.net."); !ok {
+		t.Fatal("expected synced record")
+	}
+
+	entries := historyEntries(t, h, "?name=www.example.net")
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v", entries)
+	}
+	if e := entries[0]; e.Actor != "alice" || e.Origin != "peer" || e.Reason != "set" {
+		t.Fatalf("expected sync entry attributed to its origin, got %+v", e)
+	}
+}
+
+func TestRollbackRRset(t *testing.T) {
+	s, h := newHistoryTestServer(t)
+
+	apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.1","ttl":60,"propagate":false}`, nil)
+	at := time.Now().UTC()
+	apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.2","propagate":false}`, nil)
+	apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"type":"TXT","text":"later","propagate":false}`, nil)
+
+	body := `{"name":"app.example.com","type":"A","at":` + jsonString(at.Format(time.RFC3339Nano)) + `,"dry_run":true}`
+	rr := apiRequest(t, h, http.MethodPost, "/v1/history/rollback", body, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("dry run: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var plan struct {
+		RRsets []rollbackRRset `json:"rrsets"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if len(plan.RRsets) != 1 || plan.RRsets[0].Restore[0].IP != "192.0.2.1" || plan.RRsets[0].Current[0].IP != "192.0.2.2" {
+		t.Fatalf("unexpected plan: %+v", plan.RRsets)
+	}
+	if rec, _ := s.data.getRecord("app.example.com."); rec.IP != "192.0.2.2" {
+		t.Fatalf("dry run changed the store: %+v", rec)
+	}
+
+	serial := zoneSerial(t, s, "example.com.")
+	body = `{"name":"app.example.com","type":"A","at":` + jsonString(at.Format(time.RFC3339Nano)) + `,"propagate":false}`
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/history/rollback", body, nil); rr.Code != http.StatusOK {
+		t.Fatalf("rollback: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	recs := s.data.getRecords("app.example.com.", dns.TypeA)
+	if len(recs) != 1 || recs[0].IP != "192.0.2.1" || recs[0].TTL != 60 {
+		t.Fatalf("expected A restored, got %+v", recs)
+	}
+	if txt := s.data.getRecords("app.example.com.", dns.TypeTXT); len(txt) != 1 {
+		t.Fatalf("expected TXT untouched, got %+v", txt)
+	}
+	if got := zoneSerial(t, s, "example.com."); !serialGreater(got, serial) {
+		t.Fatalf("expected serial bump, got %d after %d", got, serial)
+	}
+	if entries := historyEntries(t, h, "?name=app.example.com&type=A&limit=1"); entries[0].Reason != "rollback" {
+		t.Fatalf("expected rollback logged, got %+v", entries[0])
+	}
+
+	// Rolling back again changes nothing.
+	rr = apiRequest(t, h, http.MethodPost, "/v1/history/rollback", body, nil)
+	if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil || len(plan.RRsets) != 0 {
+		t.Fatalf("expected no-op rollback, got %s", rr.Body.String())
+	}
+}
+
+func TestRollbackDeletedZone(t *testing.T) {
+	s, h := newHistoryTestServer(t)
+
+	apiRequest(t, h, http.MethodPut, "/v1/zones/example.net", `{"ns":["ns1.example.net."],"propagate":false}`, nil)
+	apiRequest(t, h, http.MethodPut, "/v1/records/www.example.net", `{"ip":"198.51.100.1","zone":"example.net","propagate":false}`, nil)
+	apiRequest(t, h, http.MethodPut, "/v1/records/mail.example.net", `{"type":"MX","target":"mx.example.net","priority":10,"zone":"example.net","propagate":false}`, nil)
+	at := time.Now().UTC()
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/zones/example.net?cascade=true&propagate=false", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("delete zone: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	tombstone := s.deletedSerial("example.net.")
+
+	body := `{"zone":"example.net","at":` + jsonString(at.Format(time.RFC3339Nano)) + `,"propagate":false}`
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/history/rollback", body, nil); rr.Code != http.StatusOK {
+		t.Fatalf("rollback: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	z, _ := s.data.getZone("example.net.")
+	if len(z.NS) != 1 || z.NS[0] != "ns1.example.net." || !serialGreater(z.Serial, tombstone) {
+		t.Fatalf("unexpected restored zone %+v (tombstone %d)", z, tombstone)
+	}
+	if recs := s.data.view().zoneRecords("example.net."); len(recs) != 2 {
+		t.Fatalf("expected records restored, got %+v", recs)
+	}
+
+	for _, body := range []string{
+		`{"at":` + jsonString(at.Format(time.RFC3339Nano)) + `}`,
+		`{"zone":"example.net","name":"www.example.net","at":` + jsonString(at.Format(time.RFC3339Nano)) + `}`,
+		`{"zone":"example.net"}`,
+		`{"zone":"example.net","at":"2999-01-01T00:00:00Z"}`,
+	} {
+		if rr := apiRequest(t, h, http.MethodPost, "/v1/history/rollback", body, nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
+
+func zoneSerial(t *testing.T, s *server, zone string) uint32 {
+	t.Helper()
+	z, ok := s.data.getZone(zone)
+	if !ok {
+		t.Fatalf("zone %s missing", zone)
+	}
+	return z.Serial
+}
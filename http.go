@@ -10,6 +10,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -41,19 +44,45 @@ func (s *server) newRouter() http.Handler {
 	if s.cfg.DebugLog {
 		r.Use(s.httpDebugMiddleware)
 	}
+	if s.metrics != nil {
+		r.Use(s.metrics.httpMetricsMiddleware)
+		if s.cfg.MetricsListen == "" {
+			r.Method(http.MethodGet, "/metrics", s.metrics.handler())
+		}
+	}
 	r.Get("/healthz", s.handleHealth)
-	r.Get("/dns-query", s.handleDoH)
-	r.Post("/dns-query", s.handleDoH)
+	s.mountDoH(r)
 
 	r.Group(func(r chi.Router) {
 		r.Use(s.apiAuthMiddleware)
 		r.Get("/v1/records", s.handleRecords)
+		r.Get("/v1/records/{name}", s.handleRecordByName)
 		r.Put("/v1/records/{name}", s.handleRecordByName)
 		r.Post("/v1/records/{name}/add", s.handleRecordAdd)
 		r.Post("/v1/records/{name}/remove", s.handleRecordRemove)
 		r.Delete("/v1/records/{name}", s.handleRecordByName)
+		r.Post("/v1/changes", s.handleChanges)
+		r.Get("/v1/history", s.handleHistory)
+		r.Post("/v1/history/rollback", s.handleRollback)
 		r.Get("/v1/zones", s.handleZones)
+		r.Get("/v1/zones/{zone}", s.handleZoneGet)
 		r.Put("/v1/zones/{zone}", s.handleZoneByName)
+		r.Delete("/v1/zones/{zone}", s.handleZoneDelete)
+		r.Get("/v1/zones/export", s.handleZonesExport)
+		r.Post("/v1/zones/{zone}/import", s.handleZoneImport)
+		r.Get("/v1/zones/{zone}/export", s.handleZoneExport)
+		r.Get("/v1/listeners", s.handleListeners)
+		r.Get("/v1/drain", s.handleDrainStatus)
+		r.Post("/v1/drain", s.handleDrainEnter)
+		r.Delete("/v1/drain", s.handleDrainLeave)
+		r.Get("/v1/stats", s.handleStats)
+		r.Get("/v1/stats/names", s.handleStatsNames)
+		r.Get("/v1/stats/nxdomain", s.handleStatsNXDomain)
+		r.Get("/v1/stats/zones", s.handleStatsZones)
+		r.Get("/v1/stats/records", s.handleStatsRecords)
+		r.Get("/v1/tokens", s.handleTokens)
+		r.Post("/v1/tokens", s.handleTokenCreate)
+		r.Delete("/v1/tokens/{name}", s.handleTokenRevoke)
 	})
 
 	r.Group(func(r chi.Router) {
@@ -63,7 +92,56 @@ func (s *server) newRouter() http.Handler {
 	return r
 }
 
+func (s *server) mountDoH(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		if len(s.cfg.DoHCORSOrigins) > 0 {
+			r.Use(s.dohCORSMiddleware)
+			r.Options("/dns-query", s.handlePreflight)
+			r.Options("/resolve", s.handlePreflight)
+		}
+		r.Get("/dns-query", s.handleDoH)
+		r.Post("/dns-query", s.handleDoH)
+		r.Get("/resolve", s.handleDoHJSON)
+	})
+}
+
+// dohCORSMiddleware lets browsers on DOH_CORS_ORIGINS call the DoH endpoints.
+// A single "*" entry allows any origin.
+func (s *server) dohCORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			w.Header().Add("Vary", "Origin")
+			for _, allowed := range s.cfg.DoHCORSOrigins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
+					w.Header().Set("Access-Control-Max-Age", "86400")
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) handlePreflight(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	if st := s.drain.Load(); st != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"ok":          false,
+			"draining":    true,
+			"reason":      st.Reason,
+			"grace_until": st.GraceUntil,
+			"node_id":     s.cfg.NodeID,
+			"uptime_sec":  int(time.Since(s.start).Seconds()),
+		})
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"ok":         true,
 		"node_id":    s.cfg.NodeID,
@@ -72,6 +150,7 @@ func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (s *server) handleDoH(w http.ResponseWriter, r *http.Request) {
+	queryTime := time.Now()
 	var payload []byte
 
 	switch r.Method {
@@ -119,8 +198,7 @@ func (s *server) handleDoH(w http.ResponseWriter, r *http.Request) {
 		log.Printf("doh query remote=%s q=%s", r.RemoteAddr, formatDNSQuestions(req.Question))
 	}
 
-	resp := s.resolveDNS(&req)
-	wire, err := resp.Pack()
+	res, err := s.answerDNS(&req)
 	if err != nil {
 		http.Error(w, "failed to encode dns response", http.StatusInternalServerError)
 		return
@@ -129,11 +207,129 @@ func (s *server) handleDoH(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/dns-message")
 	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(wire)
+	_, _ = w.Write(res.Wire)
+	transport := "doh"
+	if r.ProtoMajor == 3 {
+		transport = "h3"
+	}
+	s.observeQuery(transport, &req, res.Rcode, res.Answers, queryTime)
+	s.tap.log(transport, httpRemoteAddr(r), httpLocalAddr(r), &req, queryTime, res.Wire)
+}
+
+func httpRemoteAddr(r *http.Request) net.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+func httpLocalAddr(r *http.Request) net.Addr {
+	addr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+// handleDoHJSON serves the JSON DNS API: /resolve?name=&type= with optional
+// cd and do flags, answered by the same resolver as wire-format DoH.
+func (s *server) handleDoHJSON(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	query := r.URL.Query()
+	name := strings.TrimSpace(query.Get("name"))
+	if name == "" || len(name) > 253 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid name"})
+		return
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid name"})
+		return
+	}
+
+	qtype := dns.TypeA
+	if v := strings.TrimSpace(query.Get("type")); v != "" {
+		t, ok := parseJSONQueryType(v)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid type"})
+			return
+		}
+		qtype = t
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	req.CheckingDisabled = jsonQueryFlag(query.Get("cd"))
+	if jsonQueryFlag(query.Get("do")) {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	if s.cfg.DebugLog {
+		log.Printf("doh json query remote=%s q=%s", r.RemoteAddr, formatDNSQuestions(req.Question))
+	}
+
+	resp := s.drainRefused(req)
+	if resp == nil {
+		resp = s.resolveDNS(req)
+	}
+	out := dnsJSONResponse{
+		Status:    resp.Rcode,
+		TC:        resp.Truncated,
+		RD:        resp.RecursionDesired,
+		RA:        resp.RecursionAvailable,
+		AD:        resp.AuthenticatedData,
+		CD:        resp.CheckingDisabled,
+		Question:  make([]dnsJSONQuestion, 0, len(resp.Question)),
+		Answer:    dnsJSONRRs(resp.Answer),
+		Authority: dnsJSONRRs(resp.Ns),
+	}
+	for _, q := range resp.Question {
+		out.Question = append(out.Question, dnsJSONQuestion{Name: q.Name, Type: q.Qtype})
+	}
+
+	w.Header().Set("Content-Type", "application/dns-json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(out)
+	s.observeQuery("doh", req, resp.Rcode, len(resp.Answer), start)
+	if s.tap != nil {
+		if wire, err := resp.Pack(); err == nil {
+			s.tap.log("doh", httpRemoteAddr(r), httpLocalAddr(r), req, start, wire)
+		}
+	}
+}
+
+// parseJSONQueryType accepts a type mnemonic such as "AAAA" or its number.
+func parseJSONQueryType(v string) (uint16, bool) {
+	if t, ok := dns.StringToType[strings.ToUpper(v)]; ok {
+		return t, true
+	}
+	n, err := strconv.ParseUint(v, 10, 16)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+func jsonQueryFlag(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true":
+		return true
+	}
+	return false
 }
 
-func (s *server) handleRecords(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{"records": s.data.listRecords()})
+func dnsJSONRRs(rrs []dns.RR) []dnsJSONRR {
+	if len(rrs) == 0 {
+		return nil
+	}
+	out := make([]dnsJSONRR, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		out = append(out, dnsJSONRR{
+			Name: hdr.Name,
+			Type: hdr.Rrtype,
+			TTL:  hdr.Ttl,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+		})
+	}
+	return out
 }
 
 func (s *server) handleRecordByName(w http.ResponseWriter, r *http.Request) {
@@ -144,6 +340,8 @@ func (s *server) handleRecordByName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch r.Method {
+	case http.MethodGet:
+		s.handleRecordGet(w, r, name)
 	case http.MethodPut:
 		s.handleRecordUpsert(w, r, name)
 	case http.MethodDelete:
@@ -178,26 +376,28 @@ func (s *server) handleRecordAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	zoneCfg := zoneConfig{Zone: rec.Zone}
-	if err := s.ensureZoneDefaults(&zoneCfg, now); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	if !recordAllowed(w, r, rec.Name, rec.Zone) {
 		return
 	}
-	if s.data.upsertZone(zoneCfg) {
-		if err := s.persist.upsertZone(zoneCfg); err != nil {
-			log.Printf("persist zone failed: %v", err)
-		}
+	guards := recordGuards(r, rec.Name, rec.Type, rec.Zone)
+	if !s.guardsHold(w, guards) {
+		return
+	}
+	if err := s.ensureZone(s.requestAuthor(r, "zone"), rec.Zone, now); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
 
-	if s.data.addRecord(rec) {
-		if err := s.persist.addRecord(rec); err != nil {
-			log.Printf("persist add record failed: %v", err)
-		}
+	zoneCfg, etag, ok := s.commitChange(r, "add_record", recordChange{Op: "add", Record: &rec, Zone: rec.Zone, Version: rec.Version}, guards, now)
+	if !ok {
+		writePreconditionFailed(w, etag)
+		return
 	}
 
+	setETag(w, etag)
 	writeJSON(w, http.StatusOK, rec)
 	if shouldPropagate(req.Propagate) {
-		go s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "add", Record: &rec, Version: rec.Version, EventTime: now})
+		go s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "add", Record: &rec, Version: rec.Version, EventTime: now, ZoneConfig: zoneCfg, Actor: requestActor(r)})
 	}
 }
 
@@ -226,15 +426,20 @@ func (s *server) handleRecordRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.data.removeRecord(rec, rec.Version) {
-		if err := s.persist.removeRecord(rec, rec.Version); err != nil {
-			log.Printf("persist remove record failed: %v", err)
-		}
+	if !recordAllowed(w, r, rec.Name, rec.Zone) {
+		return
+	}
+	guards := recordGuards(r, rec.Name, rec.Type, rec.Zone)
+	zoneCfg, etag, ok := s.commitChange(r, "remove_record", recordChange{Op: "remove", Record: &rec, Zone: rec.Zone, Version: rec.Version}, guards, now)
+	if !ok {
+		writePreconditionFailed(w, etag)
+		return
 	}
 
+	setETag(w, etag)
 	writeJSON(w, http.StatusOK, map[string]any{"removed": rec.Name, "type": rec.Type, "version": rec.Version})
 	if shouldPropagate(req.Propagate) {
-		go s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "remove", Record: &rec, Version: rec.Version, EventTime: now})
+		go s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "remove", Record: &rec, Version: rec.Version, EventTime: now, ZoneConfig: zoneCfg, Actor: requestActor(r)})
 	}
 }
 
@@ -257,39 +462,40 @@ func (s *server) handleRecordUpsert(w http.ResponseWriter, r *http.Request, name
 
 	now := time.Now().UTC()
 	rec, err := s.buildRecordFromRequest(name, upsertRecordRequest{
-		IP:       req.IP,
-		Type:     req.Type,
-		Text:     req.Text,
-		Target:   req.Target,
-		Priority: req.Priority,
-		TTL:      ttl,
-		Zone:     zone,
+		IP:        req.IP,
+		Type:      req.Type,
+		Text:      req.Text,
+		Target:    req.Target,
+		Priority:  req.Priority,
+		TTL:       ttl,
+		Zone:      zone,
+		ExpiresAt: req.ExpiresAt,
+		LeaseTTL:  req.LeaseTTL,
 	}, now)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	zoneCfg := zoneConfig{
-		Zone: zone,
+	if !recordAllowed(w, r, rec.Name, rec.Zone) {
+		return
 	}
-	if err := s.ensureZoneDefaults(&zoneCfg, now); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	guards := recordGuards(r, rec.Name, rec.Type, rec.Zone)
+	if !s.guardsHold(w, guards) {
 		return
 	}
-
-	if s.data.upsertZone(zoneCfg) {
-		if err := s.persist.upsertZone(zoneCfg); err != nil {
-			log.Printf("persist zone failed: %v", err)
-		}
+	if err := s.ensureZone(s.requestAuthor(r, "zone"), rec.Zone, now); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
 
-	if s.data.setRecord(rec) {
-		if err := s.persist.upsertRecord(rec); err != nil {
-			log.Printf("persist record failed: %v", err)
-		}
+	zoneCfg, etag, ok := s.commitChange(r, "upsert_record", recordChange{Op: "set", Record: &rec, Zone: rec.Zone, Version: rec.Version}, guards, now)
+	if !ok {
+		writePreconditionFailed(w, etag)
+		return
 	}
 
+	setETag(w, etag)
 	writeJSON(w, http.StatusOK, rec)
 
 	if shouldPropagate(req.Propagate) {
@@ -299,6 +505,8 @@ func (s *server) handleRecordUpsert(w http.ResponseWriter, r *http.Request, name
 			Record:     &rec,
 			Version:    rec.Version,
 			EventTime:  now,
+			ZoneConfig: zoneCfg,
+			Actor:      requestActor(r),
 		})
 	}
 }
@@ -312,10 +520,18 @@ func (s *server) handleRecordDelete(w http.ResponseWriter, r *http.Request, name
 		return
 	}
 
-	if s.data.deleteRecordByType(name, recordType, version) {
-		if err := s.persist.deleteRecord(name, recordType, version); err != nil {
-			log.Printf("persist record delete failed: %v", err)
-		}
+	zone := ""
+	if z, ok := s.data.bestZone(name); ok {
+		zone = z.Zone
+	}
+	if !recordAllowed(w, r, name, zone) {
+		return
+	}
+	guards := recordGuards(r, name, recordType, zone)
+	zoneCfg, etag, ok := s.commitChange(r, "delete_record", recordChange{Op: "delete", Name: name, Type: recordType, Zone: zone, Version: version}, guards, now)
+	if !ok {
+		writePreconditionFailed(w, etag)
+		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{"deleted": name, "type": recordType, "version": version})
@@ -332,12 +548,134 @@ func (s *server) handleRecordDelete(w http.ResponseWriter, r *http.Request, name
 			Type:       recordType,
 			Version:    version,
 			EventTime:  now,
+			ZoneConfig: zoneCfg,
+			Actor:      requestActor(r),
 		})
 	}
 }
 
-func (s *server) handleZones(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{"zones": s.data.listZones()})
+func (s *server) handleZones(w http.ResponseWriter, r *http.Request) {
+	zones := s.data.listZones()
+	if t := requestAPIToken(r); t.restricted() {
+		zones = slices.DeleteFunc(zones, func(z zoneConfig) bool { return !t.allowsZone(z.Zone) })
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zones": zones})
+}
+
+// handleZoneGet returns one zone with its version as the ETag.
+func (s *server) handleZoneGet(w http.ResponseWriter, r *http.Request) {
+	z, ok := s.data.getZone(normalizeName(chi.URLParam(r, "zone")))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "zone not found"})
+		return
+	}
+	setETag(w, zoneETag(z))
+	writeJSON(w, http.StatusOK, z)
+}
+
+func (s *server) handleListeners(w http.ResponseWriter, _ *http.Request) {
+	out := map[string]any{"listeners": s.listenerStats()}
+	if s.tap != nil {
+		out["dnstap"] = s.tap.stats()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.statsReport(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *server) handleStatsNames(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.statsReport(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": report.NodeID, "window": report.Window, "queries": report.Queries, "names": report.TopNames})
+}
+
+func (s *server) handleStatsNXDomain(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.statsReport(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": report.NodeID, "window": report.Window, "nxdomain": report.NXDomain})
+}
+
+func (s *server) handleStatsZones(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.statsReport(w, r)
+	if !ok {
+		return
+	}
+	zones := report.Zones
+	if zone := strings.TrimSpace(r.URL.Query().Get("zone")); zone != "" {
+		zone = normalizeName(zone)
+		zones = map[string]map[string]uint64{zone: report.Zones[zone]}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"node_id": report.NodeID, "window": report.Window, "zones": zones})
+}
+
+// handleStatsRecords returns the most queried RRsets, or with name (and
+// optionally type, default A) the estimated hit count for one RRset.
+func (s *server) handleStatsRecords(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.statsReport(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		writeJSON(w, http.StatusOK, map[string]any{"node_id": report.NodeID, "window": report.Window, "records": report.TopRecords})
+		return
+	}
+
+	recordType := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("type")))
+	if recordType == "" {
+		recordType = "A"
+	}
+	hits := s.stats.recordHits(name, recordType, statsWindows[report.Window], report.To)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"node_id": report.NodeID,
+		"window":  report.Window,
+		"records": []statsRecordCount{{Name: normalizeName(name), Type: recordType, Count: hits}},
+	})
+}
+
+// statsReport parses window (default 5m) and limit (default 20) and builds
+// the report, writing the error response itself when it fails.
+func (s *server) statsReport(w http.ResponseWriter, r *http.Request) (statsReport, bool) {
+	if s.stats == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "query statistics are disabled"})
+		return statsReport{}, false
+	}
+
+	window := strings.TrimSpace(r.URL.Query().Get("window"))
+	if window == "" {
+		window = "5m"
+	}
+	d, ok := statsWindows[window]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "window must be one of 1m, 5m, 15m, 1h"})
+		return statsReport{}, false
+	}
+
+	limit := 20
+	if v := strings.TrimSpace(r.URL.Query().Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > statsTopK {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(statsTopK)})
+			return statsReport{}, false
+		}
+		limit = n
+	}
+
+	report := s.stats.report(d, time.Now(), limit)
+	report.NodeID = s.cfg.NodeID
+	report.Window = window
+	return report, true
 }
 
 func (s *server) handleZoneByName(w http.ResponseWriter, r *http.Request) {
@@ -346,6 +684,10 @@ func (s *server) handleZoneByName(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing zone name"})
 		return
 	}
+	if s.catalog.owns(zone) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "zone is inside the catalog zone"})
+		return
+	}
 
 	var req upsertZoneRequest
 	if err := decodeJSON(r.Body, &req); err != nil {
@@ -372,18 +714,47 @@ func (s *server) handleZoneByName(w http.ResponseWriter, r *http.Request) {
 	}
 
 	z := zoneConfig{
-		Zone:      zone,
-		NS:        ns,
-		SOATTL:    ttl,
-		Serial:    uint32(now.Unix()),
-		UpdatedAt: now,
+		Zone:         zone,
+		NS:           ns,
+		SOATTL:       ttl,
+		Refresh:      req.Refresh,
+		Retry:        req.Retry,
+		Expire:       req.Expire,
+		NegativeTTL:  req.NegativeTTL,
+		Mbox:         normalizeMailbox(req.Mbox),
+		MName:        strings.TrimSpace(req.MName),
+		SerialScheme: strings.ToLower(strings.TrimSpace(req.SerialScheme)),
+		UpdatedAt:    now,
+	}
+	if z.MName != "" {
+		z.MName = normalizeName(z.MName)
+	}
+	prevSerial := s.deletedSerial(zone)
+	if existing, ok := s.data.getZone(zone); ok {
+		inheritSOAFields(&z, existing)
+		prevSerial = existing.Serial
+	}
+	if z.SerialScheme == "" {
+		z.SerialScheme = s.cfg.SerialScheme
+	}
+	applySOADefaults(&z)
+	if err := validateSOA(z); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
+	z.Serial = nextSerial(z.SerialScheme, prevSerial, now)
 
-	if s.data.upsertZone(z) {
+	changed := false
+	if !s.data.updateIf(s.requestAuthor(r, "zone"), zoneGuards(r, zone), func(tx *storeTxn) { changed = tx.upsertZone(z) }) {
+		writePreconditionFailed(w, precondition{Zone: zone}.etag(s.data.view()))
+		return
+	}
+	if changed {
 		if err := s.persist.upsertZone(z); err != nil {
-			log.Printf("persist zone failed: %v", err)
+			s.persistFailed("upsert_zone", err)
 		}
 	}
+	setETag(w, zoneETag(z))
 	writeJSON(w, http.StatusOK, z)
 
 	if shouldPropagate(req.Propagate) {
@@ -394,6 +765,97 @@ func (s *server) handleZoneByName(w http.ResponseWriter, r *http.Request) {
 			Version:    int64(z.Serial),
 			EventTime:  now,
 			ZoneConfig: &z,
+			Actor:      requestActor(r),
+		})
+	}
+}
+
+// handleZoneDelete removes a zone. Zones that still own records are only
+// deleted with cascade=true, which removes the records too; dry_run=true
+// reports what would be removed without changing anything.
+func (s *server) handleZoneDelete(w http.ResponseWriter, r *http.Request) {
+	zone := normalizeName(chi.URLParam(r, "zone"))
+	if zone == "." {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing zone name"})
+		return
+	}
+	q := r.URL.Query()
+	cascade := jsonQueryFlag(q.Get("cascade"))
+	dryRun := jsonQueryFlag(q.Get("dry_run"))
+
+	view := s.data.view()
+	z, ok := view.getZone(zone)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "zone not found"})
+		return
+	}
+	guards := zoneGuards(r, zone)
+	if !s.guardsHold(w, guards) {
+		return
+	}
+	if dryRun {
+		records := view.zoneRecords(zone)
+		if len(records) > 0 && !cascade {
+			writeZoneHasRecords(w, len(records))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"zone": zone, "dry_run": true, "records": records})
+		return
+	}
+
+	// The emptiness check and the serial are taken in the delete's own
+	// transaction, so a record written meanwhile is never orphaned.
+	now := time.Now().UTC()
+	var (
+		removed []aRecord
+		serial  uint32
+		left    int
+		deleted bool
+	)
+	met := s.data.updateIf(s.requestAuthor(r, "zone_delete"), guards, func(tx *storeTxn) {
+		cur, ok := tx.view.zones[zone]
+		if !ok {
+			return
+		}
+		if !cascade {
+			if left = len(tx.view.zoneRecords(zone)); left > 0 {
+				return
+			}
+		}
+		z = cur
+		serial = nextSerial(cur.SerialScheme, cur.Serial, now)
+		removed, deleted = tx.deleteZone(zone, serial, now, cascade)
+	})
+	switch {
+	case !met:
+		writePreconditionFailed(w, precondition{Zone: zone}.etag(s.data.view()))
+		return
+	case left > 0:
+		writeZoneHasRecords(w, left)
+		return
+	case !deleted:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "zone changed during the delete; retry"})
+		return
+	}
+	s.persistZoneDelete(zone, cascade)
+	if removed == nil {
+		removed = []aRecord{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zone": zone, "serial": serial, "records": removed})
+
+	if !strings.EqualFold(q.Get("propagate"), "false") {
+		deleted := z
+		deleted.Serial = serial
+		deleted.UpdatedAt = now
+		go s.propagate(syncEvent{
+			OriginNode: s.cfg.NodeID,
+			Op:         "zone_delete",
+			Zone:       zone,
+			Version:    int64(serial),
+			EventTime:  now,
+			ZoneConfig: &deleted,
+			Cascade:    cascade,
+			Actor:      requestActor(r),
 		})
 	}
 }
@@ -407,6 +869,7 @@ func (s *server) handleSyncEvent(w http.ResponseWriter, r *http.Request) {
 	if ev.Version == 0 {
 		ev.Version = time.Now().UTC().UnixNano()
 	}
+	author := syncAuthor(r, ev)
 
 	switch ev.Op {
 	case "set":
@@ -428,26 +891,24 @@ func (s *server) handleSyncEvent(w http.ResponseWriter, r *http.Request) {
 		}
 		rec.Source = ev.OriginNode
 		rec.UpdatedAt = ev.EventTime
+		if s.syncZoneDeleted(ev.ZoneConfig) {
+			break
+		}
 
-		if s.data.setRecord(rec) {
+		changed := false
+		s.data.updateAs(author, func(tx *storeTxn) { changed = tx.setRecord(rec) })
+		if changed {
 			if err := s.persist.upsertRecord(rec); err != nil {
-				log.Printf("persist record failed: %v", err)
+				s.persistFailed("upsert_record", err)
 			}
 		}
 
-		zoneCfg := zoneConfig{
-			Zone: rec.Zone,
-		}
-		now := time.Now().UTC()
-		if err := s.ensureZoneDefaults(&zoneCfg, now); err == nil {
-			if s.data.upsertZone(zoneCfg) {
-				if err := s.persist.upsertZone(zoneCfg); err != nil {
-					log.Printf("persist zone failed: %v", err)
-				}
+		if ev.ZoneConfig == nil {
+			if err := s.ensureZone(author, rec.Zone, time.Now().UTC()); err != nil {
+				log.Printf("sync set skipped zone defaults for %s: %v", rec.Zone, err)
 			}
-		} else {
-			log.Printf("sync set skipped zone defaults for %s: %v", rec.Zone, err)
 		}
+		s.applySyncZoneSerial(author, ev, rec.Zone, changed)
 	case "add":
 		if ev.Record == nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "record required for add"})
@@ -467,11 +928,17 @@ func (s *server) handleSyncEvent(w http.ResponseWriter, r *http.Request) {
 		}
 		rec.Source = ev.OriginNode
 		rec.UpdatedAt = ev.EventTime
-		if s.data.addRecord(rec) {
+		if s.syncZoneDeleted(ev.ZoneConfig) {
+			break
+		}
+		changed := false
+		s.data.updateAs(author, func(tx *storeTxn) { changed = tx.addRecord(rec) })
+		if changed {
 			if err := s.persist.addRecord(rec); err != nil {
-				log.Printf("persist add record failed: %v", err)
+				s.persistFailed("add_record", err)
 			}
 		}
+		s.applySyncZoneSerial(author, ev, rec.Zone, changed)
 	case "remove":
 		if ev.Record == nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "record required for remove"})
@@ -482,32 +949,60 @@ func (s *server) handleSyncEvent(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sync remove invalid record: " + err.Error()})
 			return
 		}
-		if s.data.removeRecord(rec, ev.Version) {
+		changed := false
+		s.data.updateAs(author, func(tx *storeTxn) { changed = tx.removeRecord(rec, ev.Version) })
+		if changed {
 			if err := s.persist.removeRecord(rec, ev.Version); err != nil {
-				log.Printf("persist remove record failed: %v", err)
+				s.persistFailed("remove_record", err)
 			}
 		}
+		s.applySyncZoneSerial(author, ev, rec.Zone, changed)
 	case "delete":
 		evType := strings.ToUpper(strings.TrimSpace(ev.Type))
 		if evType != "" && evType != "A" && evType != "AAAA" && evType != "TXT" && evType != "CNAME" && evType != "MX" {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "sync delete type must be A, AAAA, TXT, CNAME or MX"})
 			return
 		}
-		if s.data.deleteRecordByType(ev.Name, evType, ev.Version) {
+		changed := false
+		s.data.updateAs(author, func(tx *storeTxn) { changed = tx.deleteRecordByType(ev.Name, evType, ev.Version) })
+		if changed {
 			if err := s.persist.deleteRecord(normalizeName(ev.Name), evType, ev.Version); err != nil {
-				log.Printf("persist record delete failed: %v", err)
+				s.persistFailed("delete_record", err)
 			}
 		}
+		zone := ""
+		if z, ok := s.data.bestZone(ev.Name); ok {
+			zone = z.Zone
+		}
+		s.applySyncZoneSerial(author, ev, zone, changed)
 	case "zone":
 		if ev.ZoneConfig == nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "zone_config required for zone op"})
 			return
 		}
-		if s.data.upsertZone(*ev.ZoneConfig) {
-			if err := s.persist.upsertZone(*ev.ZoneConfig); err != nil {
-				log.Printf("persist zone failed: %v", err)
+		z := *ev.ZoneConfig
+		applySOADefaults(&z)
+		changed := false
+		s.data.updateAs(author, func(tx *storeTxn) { changed = tx.upsertZone(z) })
+		if changed {
+			if err := s.persist.upsertZone(z); err != nil {
+				s.persistFailed("upsert_zone", err)
 			}
 		}
+	case "batch":
+		applied, err := s.applySyncBatch(author, ev)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "applied": applied})
+		return
+	case "zone_delete":
+		if ev.ZoneConfig == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "zone_config required for zone_delete op"})
+			return
+		}
+		s.deleteZone(author, ev.ZoneConfig.Zone, ev.ZoneConfig.Serial, ev.EventTime, ev.Cascade, nil)
 	default:
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported op"})
 		return
@@ -536,6 +1031,17 @@ func (s *server) buildRecordFromRequest(name string, req upsertRecordRequest, no
 	if rec.Zone == "" {
 		rec.Zone = s.inferZone(name)
 	}
+	switch {
+	case req.ExpiresAt != nil && req.LeaseTTL > 0:
+		return rec, errors.New("set either expires_at or lease_ttl, not both")
+	case req.ExpiresAt != nil:
+		if !req.ExpiresAt.After(now) {
+			return rec, errors.New("expires_at must be in the future")
+		}
+		rec.ExpiresAt = req.ExpiresAt.UTC()
+	case req.LeaseTTL > 0:
+		rec.ExpiresAt = now.Add(time.Duration(req.LeaseTTL) * time.Second)
+	}
 	return s.normalizeRecordInput(rec)
 }
 
@@ -620,13 +1126,36 @@ func (s *server) normalizeRecordInput(rec aRecord) (aRecord, error) {
 	return rec, nil
 }
 
+// apiAuthMiddleware admits API_TOKEN with full access, or a named token
+// within its expiry, IP allowlist, scopes and zone and name limits. Without
+// API_TOKEN the API is open only while no named token exists; once one
+// does, every request needs a valid named token.
 func (s *server) apiAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.cfg.APIToken != "" && !validToken(r, s.cfg.APIToken) {
+		if s.cfg.APIToken == "" && !s.hasTokens() {
+			next.ServeHTTP(w, withActor(r, "anonymous"))
+			return
+		}
+		if s.cfg.APIToken != "" && validToken(r, s.cfg.APIToken) {
+			next.ServeHTTP(w, withActor(r, "api-token"))
+			return
+		}
+
+		t := s.lookupToken(requestToken(r))
+		if t == nil || t.expired(time.Now()) {
 			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 			return
 		}
-		next.ServeHTTP(w, r)
+		addr, _ := netip.ParseAddr(clientIP(r))
+		if !t.allowsAddr(addr.Unmap()) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "client address not allowed for token"})
+			return
+		}
+		if err := authorizeToken(t, r); err != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, withToken(r, t))
 	})
 }
 
@@ -683,15 +1212,19 @@ func (s *server) propagate(ev syncEvent) {
 
 			resp, err := s.cfg.SyncHTTPClient.Do(req)
 			if err != nil {
+				s.metrics.syncResult(peerURL, false)
 				log.Printf("sync request failed for %s: %v", peerURL, err)
 				return
 			}
 			defer resp.Body.Close()
 
 			if resp.StatusCode >= 300 {
+				s.metrics.syncResult(peerURL, false)
 				b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 				log.Printf("sync request rejected by %s status=%d body=%s", peerURL, resp.StatusCode, strings.TrimSpace(string(b)))
+				return
 			}
+			s.metrics.syncResult(peerURL, true)
 		}(peer)
 	}
 }
@@ -747,8 +1280,9 @@ func (s *server) ensureZoneDefaults(z *zoneConfig, now time.Time) error {
 		if z.SOATTL == 0 {
 			z.SOATTL = existing.SOATTL
 		}
+		inheritSOAFields(z, existing)
 		if z.Serial == 0 {
-			z.Serial = uint32(now.Unix())
+			z.Serial = existing.Serial
 		}
 		if z.UpdatedAt.IsZero() {
 			z.UpdatedAt = now
@@ -756,6 +1290,7 @@ func (s *server) ensureZoneDefaults(z *zoneConfig, now time.Time) error {
 		if len(z.NS) == 0 {
 			return errMissingZoneNS
 		}
+		applySOADefaults(z)
 		return nil
 	}
 
@@ -768,12 +1303,134 @@ func (s *server) ensureZoneDefaults(z *zoneConfig, now time.Time) error {
 	if z.SOATTL == 0 {
 		z.SOATTL = s.cfg.DefaultTTL
 	}
+	if z.SerialScheme == "" {
+		z.SerialScheme = s.cfg.SerialScheme
+	}
 	if z.Serial == 0 {
-		z.Serial = uint32(now.Unix())
+		z.Serial = nextSerial(z.SerialScheme, s.deletedSerial(z.Zone), now)
 	}
 	if z.UpdatedAt.IsZero() {
 		z.UpdatedAt = now
 	}
+	applySOADefaults(z)
 
 	return nil
 }
+
+// ensureZone creates zone from defaults when it is not configured yet.
+func (s *server) ensureZone(author changeAuthor, zone string, now time.Time) error {
+	if _, ok := s.data.getZone(zone); ok {
+		return nil
+	}
+	z := zoneConfig{Zone: zone}
+	if err := s.ensureZoneDefaults(&z, now); err != nil {
+		return err
+	}
+	changed := false
+	s.data.updateAs(author, func(tx *storeTxn) { changed = tx.upsertZone(z) })
+	if changed {
+		if err := s.persist.upsertZone(z); err != nil {
+			s.persistFailed("upsert_zone", err)
+		}
+	}
+	return nil
+}
+
+// deleteZone removes zone at serial, provided every guard holds, and
+// persists the deletion. It reports the removed records, whether anything
+// changed and whether the guards held.
+func (s *server) deleteZone(author changeAuthor, zone string, serial uint32, now time.Time, cascade bool, guards []precondition) ([]aRecord, bool, bool) {
+	var removed []aRecord
+	ok := false
+	met := s.data.updateIf(author, guards, func(tx *storeTxn) { removed, ok = tx.deleteZone(zone, serial, now, cascade) })
+	if !ok {
+		return nil, false, met
+	}
+	s.persistZoneDelete(zone, cascade)
+	return removed, true, true
+}
+
+// persistZoneDelete saves zone's deletion: its tombstone, and with cascade
+// the removal of its records.
+func (s *server) persistZoneDelete(zone string, cascade bool) {
+	if t, found := s.data.view().tombstone(zone); found {
+		if err := s.persist.deleteZone(t, cascade); err != nil {
+			s.persistFailed("delete_zone", err)
+		}
+	}
+}
+
+// writeZoneHasRecords answers 409 for a delete of a zone that still owns n
+// records without cascade.
+func writeZoneHasRecords(w http.ResponseWriter, n int) {
+	writeJSON(w, http.StatusConflict, map[string]any{
+		"error":   "zone has records; use cascade=true to delete them with the zone",
+		"records": n,
+	})
+}
+
+// deletedSerial returns the serial zone was deleted at, or zero, so a
+// recreated zone starts above its tombstone.
+func (s *server) deletedSerial(zone string) uint32 {
+	t, _ := s.data.view().tombstone(zone)
+	return t.Serial
+}
+
+// syncZoneDeleted reports whether origin, the zone config a replicated
+// change carries, predates the zone's deletion here, so the stale change
+// must not revive it.
+func (s *server) syncZoneDeleted(origin *zoneConfig) bool {
+	if origin == nil {
+		return false
+	}
+	view := s.data.view()
+	if _, ok := view.getZone(origin.Zone); ok {
+		return false
+	}
+	t, ok := view.tombstone(origin.Zone)
+	return ok && !serialGreater(origin.Serial, t.Serial)
+}
+
+// bumpZoneSerial advances zone's serial after a content change and returns
+// the updated config for the sync event, or nil when zone is not configured.
+func (s *server) bumpZoneSerial(zone string, now time.Time) *zoneConfig {
+	z, ok := s.data.bumpZoneSerial(zone, now)
+	if !ok {
+		return nil
+	}
+	if err := s.persist.upsertZone(z); err != nil {
+		s.persistFailed("upsert_zone", err)
+	}
+	return &z
+}
+
+// applySyncZoneSerial adopts the origin's serial for a replicated record
+// change, so every peer serves the same SOA whatever the scheme. Events from
+// nodes that do not send zone_config fall back to a local bump.
+func (s *server) applySyncZoneSerial(author changeAuthor, ev syncEvent, zone string, changed bool) {
+	now := time.Now().UTC()
+	if ev.ZoneConfig == nil {
+		if changed && zone != "" {
+			s.bumpZoneSerial(zone, now)
+		}
+		return
+	}
+
+	if _, ok := s.data.getZone(ev.ZoneConfig.Zone); !ok {
+		z := *ev.ZoneConfig
+		applySOADefaults(&z)
+		created := false
+		s.data.updateAs(author, func(tx *storeTxn) { created = tx.upsertZone(z) })
+		if created {
+			if err := s.persist.upsertZone(z); err != nil {
+				s.persistFailed("upsert_zone", err)
+			}
+		}
+		return
+	}
+	if z, ok := s.data.raiseZoneSerial(ev.ZoneConfig.Zone, ev.ZoneConfig.Serial, now); ok {
+		if err := s.persist.upsertZone(z); err != nil {
+			s.persistFailed("upsert_zone", err)
+		}
+	}
+}
@@ -232,3 +232,412 @@ func TestSyncAuthMiddleware(t *testing.T) {
 		t.Fatalf("expected 200 with sync token, got %d", resp2.Code)
 	}
 }
+
+func TestDoHPreservesQueryNameCase(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.7", TTL: 30, Version: 1, UpdatedAt: now})
+	r := s.newRouter()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("APP.example.COM.", dns.TypeA)
+	wire, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("pack dns msg: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(wire))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 from DoH POST, got %d", resp.Code)
+	}
+
+	var out dns.Msg
+	if err := out.Unpack(resp.Body.Bytes()); err != nil {
+		t.Fatalf("unpack dns response: %v", err)
+	}
+	if len(out.Answer) != 1 || out.Answer[0].Header().Name != "APP.example.COM." {
+		t.Fatalf("expected owner name case preserved, got %v", out.Answer)
+	}
+}
+
+func TestDoHJSONResolve(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.7", TTL: 30, Version: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "txt.example.com", Zone: "example.com", Type: "TXT", Text: "hello world", TTL: 30, Version: 1, UpdatedAt: now})
+	r := s.newRouter()
+
+	cases := []struct {
+		query  string
+		status int
+		data   string
+		auth   bool
+	}{
+		{query: "name=App.example.com", status: dns.RcodeSuccess, data: "198.51.100.7"},
+		{query: "name=txt.example.com&type=TXT", status: dns.RcodeSuccess, data: `"hello world"`},
+		{query: "name=txt.example.com&type=16", status: dns.RcodeSuccess, data: `"hello world"`},
+		{query: "name=missing.example.com&type=A", status: dns.RcodeNameError, auth: true},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/resolve?"+tc.query, nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tc.query, resp.Code)
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != "application/dns-json" {
+			t.Fatalf("%s: unexpected content type %q", tc.query, ct)
+		}
+
+		var out dnsJSONResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: decode json: %v", tc.query, err)
+		}
+		if out.Status != tc.status || len(out.Question) != 1 {
+			t.Fatalf("%s: unexpected response %+v", tc.query, out)
+		}
+		if tc.data != "" && (len(out.Answer) != 1 || out.Answer[0].Data != tc.data) {
+			t.Fatalf("%s: unexpected answer %+v", tc.query, out.Answer)
+		}
+		if tc.auth && (len(out.Authority) != 1 || out.Authority[0].Type != dns.TypeSOA) {
+			t.Fatalf("%s: expected SOA authority, got %+v", tc.query, out.Authority)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/resolve?name=App.example.com", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if !strings.Contains(resp.Body.String(), `"name":"App.example.com."`) {
+		t.Fatalf("expected query name case preserved, got %s", resp.Body.String())
+	}
+
+	for _, query := range []string{"", "name=app.example.com&type=BOGUS", "name=bad..name"} {
+		req := httptest.NewRequest(http.MethodGet, "/resolve?"+query, nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", query, resp.Code)
+		}
+	}
+}
+
+func TestDoHCORS(t *testing.T) {
+	s := newTestServer(t)
+	req := httptest.NewRequest(http.MethodGet, "/resolve?name=app.example.com", nil)
+	req.Header.Set("Origin", "https://app.example.org")
+	resp := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(resp, req)
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS headers must be off by default")
+	}
+
+	s.cfg.DoHCORSOrigins = []string{"https://app.example.org"}
+	r := s.newRouter()
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/dns-query", nil)
+	preflight.Header.Set("Origin", "https://app.example.org")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, preflight)
+	if resp.Code != http.StatusNoContent || resp.Header().Get("Access-Control-Allow-Origin") != "https://app.example.org" {
+		t.Fatalf("unexpected preflight response: %d %v", resp.Code, resp.Header())
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/resolve?name=app.example.com", nil)
+	other.Header.Set("Origin", "https://evil.example.net")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, other)
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("unlisted origin must not be allowed")
+	}
+
+	api := httptest.NewRequest(http.MethodGet, "/v1/records", nil)
+	api.Header.Set("Origin", "https://app.example.org")
+	api.Header.Set("Authorization", "Bearer "+s.cfg.APIToken)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, api)
+	if resp.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS must only apply to the DoH endpoints")
+	}
+}
+
+func TestHTTPZoneSOAFields(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v1/zones/example.org", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := put(`{"ns":["ns1.example.net"],"soa_ttl":60,"refresh":7200,"retry":900,"expire":1814400,"negative_ttl":300,"mbox":"dns.admin@example.org","mname":"primary.example.net"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+
+	// A later update that only changes NS keeps the SOA fields.
+	if resp := put(`{"ns":["ns2.example.net"],"soa_ttl":60}`); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for ns update, got %d", resp.Code)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeSOA)
+	out := s.resolveDNS(req)
+	if len(out.Answer) != 1 {
+		t.Fatalf("expected SOA answer, got %v", out.Answer)
+	}
+	soa := out.Answer[0].(*dns.SOA)
+	if soa.Ns != "primary.example.net." || soa.Mbox != `dns\.admin.example.org.` {
+		t.Fatalf("unexpected SOA names: %s %s", soa.Ns, soa.Mbox)
+	}
+	if soa.Refresh != 7200 || soa.Retry != 900 || soa.Expire != 1814400 || soa.Minttl != 300 {
+		t.Fatalf("unexpected SOA timers: %+v", soa)
+	}
+
+	req.SetQuestion("missing.example.org.", dns.TypeA)
+	out = s.resolveDNS(req)
+	if len(out.Ns) != 1 || out.Ns[0].Header().Ttl != 60 {
+		t.Fatalf("expected negative SOA with ttl 60, got %v", out.Ns)
+	}
+
+	for _, body := range []string{
+		`{"refresh":60}`,
+		`{"retry":7200}`,
+		`{"expire":3600}`,
+		`{"negative_ttl":604800}`,
+		`{"mname":"bad..name"}`,
+	} {
+		if resp := put(body); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, resp.Code)
+		}
+	}
+}
+
+func TestHTTPZoneLongSOATTLCapsDefaultNegativeTTL(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/zones/example.org", strings.NewReader(`{"ns":["ns1.example.net"],"soa_ttl":172800}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	z, _ := s.data.getZone("example.org.")
+	if z.SOATTL != 172800 || z.NegativeTTL != soaMaxNegativeTTL {
+		t.Fatalf("expected negative ttl capped at %d, got %+v", soaMaxNegativeTTL, z)
+	}
+}
+
+func TestHTTPRecordChangesBumpZoneSerial(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+
+	do := func(method, target, body string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d: %s", method, target, resp.Code, resp.Body.String())
+		}
+	}
+	serial := func() uint32 {
+		z, _ := s.data.getZone("example.com")
+		return z.Serial
+	}
+
+	do(http.MethodPut, "/v1/zones/example.com", `{"serial_scheme":"counter","propagate":false}`)
+	if got := serial(); got != 1 {
+		t.Fatalf("expected counter serial 1 for new zone, got %d", got)
+	}
+
+	steps := []struct {
+		method, target, body string
+	}{
+		{http.MethodPut, "/v1/records/app.example.com", `{"ip":"198.51.100.1","propagate":false}`},
+		{http.MethodPost, "/v1/records/app.example.com/add", `{"ip":"198.51.100.2","propagate":false}`},
+		{http.MethodPost, "/v1/records/app.example.com/remove", `{"ip":"198.51.100.1","propagate":false}`},
+		{http.MethodDelete, "/v1/records/app.example.com?propagate=false", ""},
+	}
+	for i, step := range steps {
+		do(step.method, step.target, step.body)
+		if got, want := serial(), uint32(i+2); got != want {
+			t.Fatalf("%s %s: expected serial %d, got %d", step.method, step.target, want, got)
+		}
+	}
+
+	// Deleting a name that no longer exists changes nothing.
+	do(http.MethodDelete, "/v1/records/app.example.com?propagate=false", "")
+	if got := serial(); got != 5 {
+		t.Fatalf("expected serial to stay 5, got %d", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/zones/example.com", strings.NewReader(`{"serial_scheme":"sequence"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown serial scheme, got %d", resp.Code)
+	}
+}
+
+func TestSyncRecordAdoptsOriginSerial(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 7, SerialScheme: serialSchemeCounter, UpdatedAt: now})
+
+	send := func(ev syncEvent) {
+		t.Helper()
+		body, _ := json.Marshal(ev)
+		req := httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+		req.Header.Set("X-Sync-Token", "sync-token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("sync %s: expected 200, got %d: %s", ev.Op, resp.Code, resp.Body.String())
+		}
+	}
+
+	origin := zoneConfig{Zone: "example.com.", NS: []string{"other.ns.example."}, SOATTL: 60, Serial: 12, SerialScheme: serialSchemeCounter, UpdatedAt: now}
+	rec := aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.9", TTL: 20}
+	send(syncEvent{OriginNode: "peer", Op: "set", Record: &rec, Version: 10, EventTime: now, ZoneConfig: &origin})
+
+	z, _ := s.data.getZone("example.com")
+	if z.Serial != 12 {
+		t.Fatalf("expected origin serial 12, got %d", z.Serial)
+	}
+	if len(z.NS) != 1 || z.NS[0] != "love.me.cloudroof.eu." {
+		t.Fatalf("record sync must not replace zone NS, got %v", z.NS)
+	}
+
+	// An older origin serial is ignored.
+	origin.Serial = 9
+	send(syncEvent{OriginNode: "peer", Op: "delete", Name: "app.example.com", Version: 11, EventTime: now, ZoneConfig: &origin})
+	if z, _ := s.data.getZone("example.com"); z.Serial != 12 {
+		t.Fatalf("expected serial to stay 12, got %d", z.Serial)
+	}
+
+	// Events without zone_config bump locally under the zone's scheme.
+	send(syncEvent{OriginNode: "peer", Op: "add", Record: &rec, Version: 12, EventTime: now})
+	if z, _ := s.data.getZone("example.com"); z.Serial != 13 {
+		t.Fatalf("expected local bump to 13, got %d", z.Serial)
+	}
+}
+
+func TestHTTPZoneDelete(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"ns1.example.net"}, SOATTL: 60, Serial: 5, SerialScheme: serialSchemeCounter, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.net", Type: "A", Zone: "example.net", IP: "198.51.100.7", TTL: 20, Version: 1, UpdatedAt: now})
+
+	do := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodDelete, target, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := do("/v1/zones/example.net?propagate=false"); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 without cascade, got %d", resp.Code)
+	}
+
+	resp := do("/v1/zones/example.net?cascade=true&dry_run=true")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "app.example.net.") {
+		t.Fatalf("expected dry run to list the record, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, ok := s.data.getZone("example.net"); !ok {
+		t.Fatal("dry run must not delete the zone")
+	}
+
+	resp = do("/v1/zones/example.net?cascade=true&propagate=false")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for cascade delete, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if _, ok := s.data.getZone("example.net"); ok {
+		t.Fatal("expected zone to be deleted")
+	}
+	if tomb, ok := s.data.view().tombstone("example.net"); !ok || tomb.Serial != 6 {
+		t.Fatalf("expected tombstone at serial 6, got %+v ok=%t", tomb, ok)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.net.", dns.TypeA)
+	if got := s.resolveDNS(req); got.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED after zone delete, got rcode %d", got.Rcode)
+	}
+
+	if resp := do("/v1/zones/example.net"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a deleted zone, got %d", resp.Code)
+	}
+
+	// Recreating the zone continues above the tombstone serial.
+	put := httptest.NewRequest(http.MethodPut, "/v1/zones/example.net", strings.NewReader(`{"serial_scheme":"counter","propagate":false}`))
+	put.Header.Set("Authorization", "Bearer token")
+	putResp := httptest.NewRecorder()
+	r.ServeHTTP(putResp, put)
+	if putResp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for zone recreate, got %d: %s", putResp.Code, putResp.Body.String())
+	}
+	if z, _ := s.data.getZone("example.net"); z.Serial != 7 {
+		t.Fatalf("expected recreated serial 7, got %d", z.Serial)
+	}
+}
+
+func TestSyncZoneDeleteIgnoresStaleEvents(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"ns1.example.net"}, SOATTL: 60, Serial: 5, UpdatedAt: now})
+
+	send := func(ev syncEvent) {
+		t.Helper()
+		body, _ := json.Marshal(ev)
+		req := httptest.NewRequest(http.MethodPost, "/v1/sync/event", bytes.NewReader(body))
+		req.Header.Set("X-Sync-Token", "sync-token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("sync %s: expected 200, got %d: %s", ev.Op, resp.Code, resp.Body.String())
+		}
+	}
+
+	deleted := zoneConfig{Zone: "example.net.", NS: []string{"ns1.example.net."}, SOATTL: 60, Serial: 6}
+	send(syncEvent{OriginNode: "peer", Op: "zone_delete", Zone: "example.net.", Version: 6, EventTime: now, ZoneConfig: &deleted})
+	if _, ok := s.data.getZone("example.net"); ok {
+		t.Fatal("expected zone_delete to remove the zone")
+	}
+
+	// A record change made before the delete arrives late and is dropped.
+	stale := deleted
+	stale.Serial = 6
+	rec := aRecord{Name: "app.example.net", Zone: "example.net", IP: "198.51.100.9", TTL: 20}
+	send(syncEvent{OriginNode: "peer", Op: "set", Record: &rec, Version: 10, EventTime: now, ZoneConfig: &stale})
+	if _, ok := s.data.getZone("example.net"); ok || s.data.hasName("app.example.net") {
+		t.Fatal("expected stale record sync not to revive the zone")
+	}
+	send(syncEvent{OriginNode: "peer", Op: "zone", Zone: "example.net.", Version: 6, EventTime: now, ZoneConfig: &stale})
+	if _, ok := s.data.getZone("example.net"); ok {
+		t.Fatal("expected stale zone op not to revive the zone")
+	}
+
+	newer := deleted
+	newer.Serial = 7
+	send(syncEvent{OriginNode: "peer", Op: "zone", Zone: "example.net.", Version: 7, EventTime: now, ZoneConfig: &newer})
+	if _, ok := s.data.getZone("example.net"); !ok {
+		t.Fatal("expected a newer zone op to recreate the zone")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 )
@@ -28,10 +29,23 @@ func main() {
 			Zone:      cfg.DefaultZone,
 			NS:        cfg.DefaultNS,
 			SOATTL:    cfg.DefaultTTL,
-			Serial:    uint32(now.Unix()),
 			UpdatedAt: now,
 		}
-		if mem.upsertZone(z) {
+		var prevSerial uint32
+		existing, found := mem.getZone(z.Zone)
+		if found {
+			inheritSOAFields(&z, existing)
+			prevSerial = existing.Serial
+		}
+		if z.SerialScheme == "" {
+			z.SerialScheme = cfg.SerialScheme
+		}
+		applySOADefaults(&z)
+		z.Serial = nextSerial(z.SerialScheme, prevSerial, now)
+		// Restarting with unchanged settings must not bump the serial, or
+		// counter-scheme peers would drift apart.
+		unchanged := found && slices.Equal(existing.NS, normalizeNames(z.NS)) && existing.SOATTL == z.SOATTL
+		if !unchanged && mem.upsertZone(z) {
 			if err := persist.upsertZone(z); err != nil {
 				log.Printf("persist default zone failed: %v", err)
 			}
@@ -45,16 +59,75 @@ func main() {
 		cfg:     cfg,
 		data:    mem,
 		persist: persist,
+		writes:  newWriteQueue(),
 		start:   time.Now().UTC(),
 	}
+	if err := srv.restoreDrain(); err != nil {
+		log.Fatalf("drain state load failed: %v", err)
+	}
+	if st := srv.drain.Load(); st != nil {
+		log.Printf("node is draining since %s: %s", st.Since.Format(time.RFC3339), st.Reason)
+	}
+	if err := srv.restoreTokens(); err != nil {
+		log.Fatalf("api tokens load failed: %v", err)
+	}
+	if n := len(srv.listTokens()); n > 0 && cfg.APIToken == "" {
+		log.Printf("API_TOKEN is not set: the API only accepts the %d named tokens and they cannot be managed", n)
+	}
+	if cfg.CatalogZone != "" {
+		srv.catalog = newCatalogZone(cfg.CatalogZone, cfg.SerialScheme)
+		if err := srv.restoreCatalog(); err != nil {
+			log.Fatalf("catalog state load failed: %v", err)
+		}
+	}
+	mem.subscribe(srv.handleZoneChange)
+	mem.subscribe(srv.recordChangeLog)
+	if cfg.tlsEnabled() {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("tls init failed: %v", err)
+		}
+		srv.certs = certs
+	}
+	if cfg.Stats {
+		srv.stats = newQueryStats(mem)
+	}
+	if cfg.Metrics {
+		srv.metrics = newMetrics(srv)
+	}
+	if cfg.DNSTapOutput != "" {
+		tap, err := newDNSTapLogger(cfg.DNSTapOutput, cfg.NodeID, int(cfg.DNSTapBuffer))
+		if err != nil {
+			log.Fatalf("dnstap init failed: %v", err)
+		}
+		srv.tap = tap
+		defer tap.close()
+	}
+	if cfg.DNSCache {
+		srv.cache = newResponseCache(int(cfg.DNSCacheSize))
+		mem.subscribe(srv.cache.invalidate)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	errCh := make(chan error, 3)
+	errCh := make(chan error, 7)
 	go func() { errCh <- srv.runHTTP(ctx) }()
 	go func() { errCh <- srv.runDNS(ctx, "udp") }()
 	go func() { errCh <- srv.runDNS(ctx, "tcp") }()
+	if len(cfg.DNSTLSListen) > 0 {
+		go func() { errCh <- srv.runDNS(ctx, "tcp-tls") }()
+	}
+	if len(cfg.DNSQUICListen) > 0 {
+		go func() { errCh <- srv.runDoQ(ctx) }()
+	}
+	if len(cfg.HTTP3Listen) > 0 {
+		go func() { errCh <- srv.runHTTP3(ctx) }()
+	}
+	if srv.metrics != nil && cfg.MetricsListen != "" {
+		go func() { errCh <- srv.runMetrics(ctx) }()
+	}
+	go srv.runExpirySweeper(ctx)
 
 	select {
 	case <-ctx.Done():
@@ -64,4 +137,5 @@ func main() {
 			log.Fatalf("fatal server error: %v", err)
 		}
 	}
+	srv.writes.flush()
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func newMetrics(s *server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		dnsQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_queries_total",
+			Help: "DNS queries answered, by transport, query type, response code and zone.",
+		}, []string{"transport", "qtype", "rcode", "zone"}),
+		dnsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dns_query_duration_seconds",
+			Help:    "Time from receiving a DNS query to writing its response.",
+			Buckets: []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"transport"}),
+		syncEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_sync_events_total",
+			Help: "Sync events pushed to peers, by peer and result.",
+		}, []string{"peer", "result"}),
+		persistErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_persistence_errors_total",
+			Help: "Failed SQLite writes, by operation.",
+		}, []string{"op"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns_http_requests_total",
+			Help: "HTTP API requests, by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dns_http_request_duration_seconds",
+			Help:    "HTTP API request latency, by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.dnsQueries,
+		m.dnsDuration,
+		m.syncEvents,
+		m.persistErrors,
+		m.httpRequests,
+		m.httpDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dns_store_zones",
+			Help: "Zones in the in-memory store.",
+		}, func() float64 { return float64(len(s.data.view().zones)) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dns_store_names",
+			Help: "Owner names in the in-memory store.",
+		}, func() float64 {
+			names, _ := s.data.view().counts()
+			return float64(names)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dns_store_records",
+			Help: "Records in the in-memory store.",
+		}, func() float64 {
+			_, records := s.data.view().counts()
+			return float64(records)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dns_response_cache_entries",
+			Help: "Packed responses held by the DNS response cache.",
+		}, func() float64 { return float64(s.cache.len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "dns_draining",
+			Help: "1 while the node is in drain mode.",
+		}, func() float64 {
+			if s.drain.Load() != nil {
+				return 1
+			}
+			return 0
+		}),
+	)
+	return m
+}
+
+func (s *server) runMetrics(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.handler())
+	metricsServer := &http.Server{
+		Addr:              s.cfg.MetricsListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}()
+
+	return metricsServer.ListenAndServe()
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// observeQuery records one answered DNS query in the query statistics and
+// metrics. The zone label is the longest configured zone containing the query
+// name, or "none" when outside all zones.
+func (s *server) observeQuery(transport string, req *dns.Msg, rcode, answers int, start time.Time) {
+	s.stats.observe(req, rcode, answers, start)
+	if s.metrics == nil || len(req.Question) == 0 {
+		return
+	}
+	q := req.Question[0]
+
+	qtype, ok := dns.TypeToString[q.Qtype]
+	if !ok {
+		qtype = "other"
+	}
+	rcodeName, ok := dns.RcodeToString[rcode]
+	if !ok {
+		rcodeName = "other"
+	}
+	zone := "none"
+	if z, ok := s.data.view().bestZone(q.Name); ok {
+		zone = z.Zone
+	}
+
+	s.metrics.dnsQueries.WithLabelValues(transport, qtype, rcodeName, zone).Inc()
+	s.metrics.dnsDuration.WithLabelValues(transport).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) syncResult(peer string, ok bool) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	m.syncEvents.WithLabelValues(peer, result).Inc()
+}
+
+// persistFailed logs a failed SQLite write and counts it by operation.
+func (s *server) persistFailed(op string, err error) {
+	log.Printf("persist %s failed: %v", op, err)
+	if s.metrics != nil {
+		s.metrics.persistErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// httpMetricsMiddleware counts API requests by their chi route pattern, so
+// record names in paths do not become label values.
+func (m *metrics) httpMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(ww.status)).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func scrapeMetrics(t *testing.T, h http.Handler) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /metrics, got %d", resp.Code)
+	}
+	return resp.Body.String()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newTestServer(t)
+	s.metrics = newMetrics(s)
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.7", TTL: 30, Version: 1, UpdatedAt: now})
+	r := s.newRouter()
+
+	for _, qname := range []string{"app.example.com.", "missing.example.com.", "outside.example.net."} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(qname, dns.TypeA)
+		wire, err := msg.Pack()
+		if err != nil {
+			t.Fatalf("pack dns msg: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/dns-query", bytes.NewReader(wire))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("expected 200 from DoH POST, got %d", resp.Code)
+		}
+	}
+
+	apiReq := httptest.NewRequest(http.MethodPatch, "/v1/records/app.example.com", nil)
+	r.ServeHTTP(httptest.NewRecorder(), apiReq)
+
+	body := scrapeMetrics(t, r)
+	for _, want := range []string{
+		`dns_queries_total{qtype="A",rcode="NOERROR",transport="doh",zone="example.com."} 1`,
+		`dns_queries_total{qtype="A",rcode="NXDOMAIN",transport="doh",zone="example.com."} 1`,
+		`dns_queries_total{qtype="A",rcode="REFUSED",transport="doh",zone="none"} 1`,
+		`dns_query_duration_seconds_count{transport="doh"} 3`,
+		`dns_store_zones 1`,
+		`dns_store_names 1`,
+		`dns_store_records 1`,
+		`dns_http_requests_total{code="200",method="POST",route="/dns-query"} 3`,
+		`dns_http_requests_total{code="405",method="PATCH",route="unmatched"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsSyncAndPersistence(t *testing.T) {
+	okPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer okPeer.Close()
+	badPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer badPeer.Close()
+
+	s := newTestServer(t)
+	s.cfg.Peers = []string{okPeer.URL, badPeer.URL}
+	s.metrics = newMetrics(s)
+	s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "upsert", Name: "app.example.com", Version: 1})
+
+	sqlDB, err := s.persist.db.DB()
+	if err != nil {
+		t.Fatalf("sql db: %v", err)
+	}
+	_ = sqlDB.Close()
+
+	body := `{"ip":"198.51.100.5","ttl":15}`
+	req := httptest.NewRequest(http.MethodPut, "/v1/records/app.example.com", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	s.newRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	// One explicit event plus the one propagated by the PUT.
+	wants := []string{
+		`dns_sync_events_total{peer="` + okPeer.URL + `",result="success"} 2`,
+		`dns_sync_events_total{peer="` + badPeer.URL + `",result="failure"} 2`,
+		`dns_persistence_errors_total{op="upsert_record"} 1`,
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out := scrapeMetrics(t, s.metrics.handler())
+		missing := ""
+		for _, want := range wants {
+			if !strings.Contains(out, want) {
+				missing = want
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics output missing %q:\n%s", missing, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsSeparateListener(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.MetricsListen = "127.0.0.1:0"
+	s.metrics = newMetrics(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected /metrics off the API router with METRICS_LISTEN set, got %d", resp.Code)
+	}
+}
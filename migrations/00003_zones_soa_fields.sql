@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE zones ADD COLUMN refresh INTEGER NOT NULL DEFAULT 3600;
+ALTER TABLE zones ADD COLUMN retry INTEGER NOT NULL DEFAULT 600;
+ALTER TABLE zones ADD COLUMN expire INTEGER NOT NULL DEFAULT 1209600;
+ALTER TABLE zones ADD COLUMN negative_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE zones ADD COLUMN mbox TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN mname TEXT NOT NULL DEFAULT '';
+
+UPDATE zones SET negative_ttl = soa_ttl;
+
+-- +goose Down
+ALTER TABLE zones DROP COLUMN mname;
+ALTER TABLE zones DROP COLUMN mbox;
+ALTER TABLE zones DROP COLUMN negative_ttl;
+ALTER TABLE zones DROP COLUMN expire;
+ALTER TABLE zones DROP COLUMN retry;
+ALTER TABLE zones DROP COLUMN refresh;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE zones ADD COLUMN serial_scheme TEXT NOT NULL DEFAULT 'unix';
+
+-- +goose Down
+ALTER TABLE zones DROP COLUMN serial_scheme;
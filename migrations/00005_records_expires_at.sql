@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE records ADD COLUMN expires_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_records_expires_at ON records(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_records_expires_at;
+ALTER TABLE records DROP COLUMN expires_at;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS zone_tombstones (
+    zone TEXT PRIMARY KEY,
+    serial INTEGER NOT NULL,
+    deleted_at DATETIME NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS zone_tombstones;
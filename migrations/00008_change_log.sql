@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS change_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    at INTEGER NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    client_ip TEXT NOT NULL DEFAULT '',
+    origin TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    kind TEXT NOT NULL,
+    op TEXT NOT NULL,
+    zone TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    type TEXT NOT NULL DEFAULT '',
+    before TEXT NOT NULL DEFAULT '',
+    after TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_change_log_zone_at ON change_log (zone, at);
+CREATE INDEX IF NOT EXISTS idx_change_log_name_type_at ON change_log (name, type, at);
+CREATE INDEX IF NOT EXISTS idx_change_log_at ON change_log (at);
+
+-- +goose Down
+DROP TABLE IF EXISTS change_log;
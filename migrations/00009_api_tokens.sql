@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS api_tokens (
+    name TEXT PRIMARY KEY,
+    secret_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL DEFAULT '',
+    scopes_json TEXT NOT NULL,
+    zones_json TEXT NOT NULL DEFAULT '[]',
+    names_json TEXT NOT NULL DEFAULT '[]',
+    allowed_ips_json TEXT NOT NULL DEFAULT '[]',
+    expires_at DATETIME,
+    created_at DATETIME NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_tokens;
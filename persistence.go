@@ -6,12 +6,67 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/glebarez/sqlite"
 	"github.com/pressly/goose/v3"
 	"gorm.io/gorm"
 )
 
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// enqueue queues fn behind every write queued before it. It never blocks on
+// I/O. A nil queue runs fn at once.
+func (q *writeQueue) enqueue(fn func()) {
+	if q == nil {
+		fn()
+		return
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, fn)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// flush waits until every write queued so far has run, so readers of the
+// database see them.
+func (q *writeQueue) flush() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) > 0 || q.busy {
+		q.cond.Wait()
+	}
+}
+
+func (q *writeQueue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		batch := q.pending
+		q.pending = nil
+		q.busy = true
+		q.mu.Unlock()
+		for _, fn := range batch {
+			fn()
+		}
+		q.mu.Lock()
+		q.busy = false
+		q.cond.Broadcast()
+	}
+}
+
 func newPersistence(dbPath, migrationsDir string) (*persistence, error) {
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
 	if err != nil {
@@ -46,17 +101,25 @@ func (p *persistence) loadIntoStore(s *store) error {
 	if err := p.db.Find(&zones).Error; err != nil {
 		return fmt.Errorf("load zones: %w", err)
 	}
+	zoneConfigs := make([]zoneConfig, 0, len(zones))
 	for _, z := range zones {
 		ns, err := unmarshalNS(z.NSJSON)
 		if err != nil {
 			return fmt.Errorf("decode zone %s: %w", z.Zone, err)
 		}
-		s.upsertZone(zoneConfig{
-			Zone:      z.Zone,
-			NS:        ns,
-			SOATTL:    z.SOATTL,
-			Serial:    z.Serial,
-			UpdatedAt: z.UpdatedAt,
+		zoneConfigs = append(zoneConfigs, zoneConfig{
+			Zone:         z.Zone,
+			NS:           ns,
+			SOATTL:       z.SOATTL,
+			Serial:       z.Serial,
+			Refresh:      z.Refresh,
+			Retry:        z.Retry,
+			Expire:       z.Expire,
+			NegativeTTL:  z.NegativeTTL,
+			Mbox:         z.Mbox,
+			MName:        z.MName,
+			SerialScheme: z.SerialScheme,
+			UpdatedAt:    z.UpdatedAt,
 		})
 	}
 
@@ -64,31 +127,50 @@ func (p *persistence) loadIntoStore(s *store) error {
 	if err := p.db.Find(&records).Error; err != nil {
 		return fmt.Errorf("load records: %w", err)
 	}
-	for _, r := range records {
-		s.addRecord(aRecord{
-			ID:        r.ID,
-			Name:      r.Name,
-			Type:      r.Type,
-			IP:        r.IP,
-			Text:      r.Text,
-			Target:    r.Target,
-			Priority:  r.Priority,
-			TTL:       r.TTL,
-			Zone:      r.Zone,
-			UpdatedAt: r.UpdatedAt,
-			Version:   r.Version,
-			Source:    r.Source,
-		})
+
+	var tombstones []zoneTombstoneModel
+	if err := p.db.Find(&tombstones).Error; err != nil {
+		return fmt.Errorf("load zone tombstones: %w", err)
 	}
 
+	s.update(func(tx *storeTxn) {
+		for _, t := range tombstones {
+			tx.deleteZone(t.Zone, t.Serial, t.DeletedAt, false)
+		}
+		for _, z := range zoneConfigs {
+			tx.upsertZone(z)
+		}
+		for _, r := range records {
+			tx.addRecord(aRecord{
+				ID:        r.ID,
+				Name:      r.Name,
+				Type:      r.Type,
+				IP:        r.IP,
+				Text:      r.Text,
+				Target:    r.Target,
+				Priority:  r.Priority,
+				TTL:       r.TTL,
+				Zone:      r.Zone,
+				UpdatedAt: r.UpdatedAt,
+				Version:   r.Version,
+				Source:    r.Source,
+				ExpiresAt: derefTime(r.ExpiresAt),
+			})
+		}
+	})
+
 	return nil
 }
 
 func (p *persistence) upsertRecord(rec aRecord) error {
+	return upsertRecordIn(p.db, rec)
+}
+
+func upsertRecordIn(db *gorm.DB, rec aRecord) error {
 	rec = normalizeRecord(rec)
 
 	var existing []recordModel
-	if err := p.db.Where("name = ? AND type = ?", rec.Name, rec.Type).Find(&existing).Error; err != nil {
+	if err := db.Where("name = ? AND type = ?", rec.Name, rec.Type).Find(&existing).Error; err != nil {
 		return fmt.Errorf("lookup record set: %w", err)
 	}
 	for _, row := range existing {
@@ -96,12 +178,12 @@ func (p *persistence) upsertRecord(rec aRecord) error {
 			return nil
 		}
 	}
-	if err := p.db.Where("name = ? AND type = ?", rec.Name, rec.Type).Delete(&recordModel{}).Error; err != nil {
+	if err := db.Where("name = ? AND type = ?", rec.Name, rec.Type).Delete(&recordModel{}).Error; err != nil {
 		return fmt.Errorf("delete existing record set: %w", err)
 	}
 
 	model := recordModelFrom(rec)
-	if err := p.db.Create(&model).Error; err != nil {
+	if err := db.Create(&model).Error; err != nil {
 		return fmt.Errorf("create record: %w", err)
 	}
 
@@ -109,6 +191,10 @@ func (p *persistence) upsertRecord(rec aRecord) error {
 }
 
 func (p *persistence) addRecord(rec aRecord) error {
+	return addRecordIn(p.db, rec)
+}
+
+func addRecordIn(db *gorm.DB, rec aRecord) error {
 	rec.Type = normalizeRecordType(rec.Type)
 	if rec.Type == "" {
 		rec.Type = "A"
@@ -116,7 +202,7 @@ func (p *persistence) addRecord(rec aRecord) error {
 	rec = normalizeRecord(rec)
 
 	var existingRows []recordModel
-	if err := recordIdentityQuery(p.db, rec).Find(&existingRows).Error; err != nil {
+	if err := recordIdentityQuery(db, rec).Find(&existingRows).Error; err != nil {
 		return fmt.Errorf("lookup record: %w", err)
 	}
 	if len(existingRows) > 0 && existingRows[0].Version > rec.Version {
@@ -125,13 +211,14 @@ func (p *persistence) addRecord(rec aRecord) error {
 
 	model := recordModelFrom(rec)
 	if len(existingRows) == 0 {
-		if err := p.db.Create(&model).Error; err != nil {
+		if err := db.Create(&model).Error; err != nil {
 			return fmt.Errorf("create record: %w", err)
 		}
 		return nil
 	}
 
-	if err := p.db.Model(&existingRows[0]).Updates(model).Error; err != nil {
+	// Select all columns so clearing expires_at is written too.
+	if err := db.Model(&existingRows[0]).Select("*").Omit("id").Updates(model).Error; err != nil {
 		return fmt.Errorf("update record: %w", err)
 	}
 
@@ -139,10 +226,14 @@ func (p *persistence) addRecord(rec aRecord) error {
 }
 
 func (p *persistence) deleteRecord(name, recordType string, version int64) error {
+	return deleteRecordIn(p.db, name, recordType, version)
+}
+
+func deleteRecordIn(db *gorm.DB, name, recordType string, version int64) error {
 	name = normalizeName(name)
 	recordType = strings.ToUpper(strings.TrimSpace(recordType))
 
-	query := p.db.Model(&recordModel{}).Where("name = ?", name)
+	query := db.Model(&recordModel{}).Where("name = ?", name)
 	if recordType != "" {
 		query = query.Where("type = ?", recordType)
 	}
@@ -156,7 +247,7 @@ func (p *persistence) deleteRecord(name, recordType string, version int64) error
 		if rec.Version > version {
 			continue
 		}
-		if err := p.db.Delete(&recordModel{}, "id = ?", rec.ID).Error; err != nil {
+		if err := db.Delete(&recordModel{}, "id = ?", rec.ID).Error; err != nil {
 			return fmt.Errorf("delete record: %w", err)
 		}
 	}
@@ -165,10 +256,14 @@ func (p *persistence) deleteRecord(name, recordType string, version int64) error
 }
 
 func (p *persistence) removeRecord(rec aRecord, version int64) error {
+	return removeRecordIn(p.db, rec, version)
+}
+
+func removeRecordIn(db *gorm.DB, rec aRecord, version int64) error {
 	rec = normalizeRecord(rec)
 
 	var existing []recordModel
-	if err := recordIdentityQuery(p.db, rec).Find(&existing).Error; err != nil {
+	if err := recordIdentityQuery(db, rec).Find(&existing).Error; err != nil {
 		return fmt.Errorf("lookup records before remove: %w", err)
 	}
 
@@ -176,7 +271,7 @@ func (p *persistence) removeRecord(rec aRecord, version int64) error {
 		if row.Version > version {
 			continue
 		}
-		if err := p.db.Delete(&recordModel{}, "id = ?", row.ID).Error; err != nil {
+		if err := db.Delete(&recordModel{}, "id = ?", row.ID).Error; err != nil {
 			return fmt.Errorf("delete record by identity: %w", err)
 		}
 	}
@@ -184,14 +279,18 @@ func (p *persistence) removeRecord(rec aRecord, version int64) error {
 }
 
 func (p *persistence) upsertZone(z zoneConfig) error {
+	return upsertZoneIn(p.db, z)
+}
+
+func upsertZoneIn(db *gorm.DB, z zoneConfig) error {
 	nsJSON, err := marshalNS(z.NS)
 	if err != nil {
 		return err
 	}
 
 	var existing []zoneModel
-	err = p.db.Where("zone = ?", z.Zone).Limit(1).Find(&existing).Error
-	if err == nil && len(existing) > 0 && existing[0].Serial > z.Serial {
+	err = db.Where("zone = ?", z.Zone).Limit(1).Find(&existing).Error
+	if err == nil && len(existing) > 0 && serialGreater(existing[0].Serial, z.Serial) {
 		return nil
 	}
 	if err != nil {
@@ -199,19 +298,103 @@ func (p *persistence) upsertZone(z zoneConfig) error {
 	}
 
 	model := zoneModel{
-		Zone:      z.Zone,
-		NSJSON:    nsJSON,
-		SOATTL:    z.SOATTL,
-		Serial:    z.Serial,
-		UpdatedAt: z.UpdatedAt,
-	}
-	if err := p.db.Save(&model).Error; err != nil {
+		Zone:         z.Zone,
+		NSJSON:       nsJSON,
+		SOATTL:       z.SOATTL,
+		Serial:       z.Serial,
+		Refresh:      z.Refresh,
+		Retry:        z.Retry,
+		Expire:       z.Expire,
+		NegativeTTL:  z.NegativeTTL,
+		Mbox:         z.Mbox,
+		MName:        z.MName,
+		SerialScheme: z.SerialScheme,
+		UpdatedAt:    z.UpdatedAt,
+	}
+	if err := db.Save(&model).Error; err != nil {
 		return fmt.Errorf("save zone: %w", err)
 	}
 
 	return nil
 }
 
+// applyChanges persists the applied changes of a batch and the resulting
+// zone configs in one transaction.
+func (p *persistence) applyChanges(changes []recordChange, applied []bool, zones []zoneConfig) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		for i, c := range changes {
+			if !applied[i] {
+				continue
+			}
+			var err error
+			switch c.Op {
+			case "set":
+				err = upsertRecordIn(tx, *c.Record)
+			case "add":
+				err = addRecordIn(tx, *c.Record)
+			case "remove":
+				err = removeRecordIn(tx, *c.Record, c.Version)
+			case "delete":
+				err = deleteRecordIn(tx, c.Name, c.Type, c.Version)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, z := range zones {
+			if err := upsertZoneIn(tx, z); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importRecords removes and adds records and upserts the zone config, when
+// set, in one transaction, so a zone import is either fully persisted or not
+// at all.
+func (p *persistence) importRecords(z *zoneConfig, added, removed []aRecord) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if z != nil {
+			if err := upsertZoneIn(tx, *z); err != nil {
+				return err
+			}
+		}
+		for _, rec := range removed {
+			if err := removeRecordIn(tx, rec, rec.Version); err != nil {
+				return err
+			}
+		}
+		for _, rec := range added {
+			if err := addRecordIn(tx, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteZone removes the zone row, and its records when cascade is set, and
+// stores the tombstone, all in one transaction. Callers apply the serial
+// guard in memory first.
+func (p *persistence) deleteZone(t zoneTombstone, cascade bool) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("zone = ?", t.Zone).Delete(&zoneModel{}).Error; err != nil {
+			return fmt.Errorf("delete zone: %w", err)
+		}
+		if cascade {
+			if err := tx.Where("zone = ?", t.Zone).Delete(&recordModel{}).Error; err != nil {
+				return fmt.Errorf("delete zone records: %w", err)
+			}
+		}
+		model := zoneTombstoneModel{Zone: t.Zone, Serial: t.Serial, DeletedAt: t.DeletedAt}
+		if err := tx.Save(&model).Error; err != nil {
+			return fmt.Errorf("save zone tombstone: %w", err)
+		}
+		return nil
+	})
+}
+
 func marshalNS(ns []string) (string, error) {
 	b, err := json.Marshal(ns)
 	if err != nil {
@@ -244,7 +427,24 @@ func recordModelFrom(rec aRecord) recordModel {
 		UpdatedAt: rec.UpdatedAt,
 		Version:   rec.Version,
 		Source:    rec.Source,
+		ExpiresAt: timePtr(rec.ExpiresAt),
+	}
+}
+
+// timePtr maps a zero time to NULL for nullable columns.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
+	t = t.UTC()
+	return &t
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
 }
 
 func normalizeRecord(rec aRecord) aRecord {
@@ -275,3 +475,241 @@ func recordIdentityQuery(db *gorm.DB, rec aRecord) *gorm.DB {
 	}
 	return q
 }
+
+// loadNodeState returns the value stored under key, or "" when unset.
+func (p *persistence) loadNodeState(key string) (string, error) {
+	var rows []nodeStateModel
+	if err := p.db.Where("key = ?", key).Limit(1).Find(&rows).Error; err != nil {
+		return "", fmt.Errorf("load node state %s: %w", key, err)
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	return rows[0].Value, nil
+}
+
+func (p *persistence) saveNodeState(key, value string, now time.Time) error {
+	model := nodeStateModel{Key: key, Value: value, UpdatedAt: now}
+	if err := p.db.Save(&model).Error; err != nil {
+		return fmt.Errorf("save node state %s: %w", key, err)
+	}
+	return nil
+}
+
+func (p *persistence) deleteNodeState(key string) error {
+	if err := p.db.Delete(&nodeStateModel{}, "key = ?", key).Error; err != nil {
+		return fmt.Errorf("delete node state %s: %w", key, err)
+	}
+	return nil
+}
+
+// appendChangeLog stores entries in one transaction. Rows are never updated
+// or deleted.
+func (p *persistence) appendChangeLog(entries []changeLogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	models := make([]changeLogModel, 0, len(entries))
+	for _, e := range entries {
+		m, err := changeLogModelFrom(e)
+		if err != nil {
+			return err
+		}
+		models = append(models, m)
+	}
+	if err := p.db.CreateInBatches(&models, 500).Error; err != nil {
+		return fmt.Errorf("append change log: %w", err)
+	}
+	return nil
+}
+
+// queryChangeLog returns the entries matching q, newest first unless
+// q.Ascending is set.
+func (p *persistence) queryChangeLog(q changeLogQuery) ([]changeLogEntry, error) {
+	db := p.db.Model(&changeLogModel{})
+	if q.Zone != "" {
+		db = db.Where("zone = ?", q.Zone)
+	}
+	if q.Name != "" {
+		db = db.Where("name = ?", q.Name)
+	}
+	if q.Type != "" {
+		db = db.Where("type = ?", q.Type)
+	}
+	if q.Kind != "" {
+		db = db.Where("kind = ?", q.Kind)
+	}
+	if q.Actor != "" {
+		db = db.Where("actor = ?", q.Actor)
+	}
+	if !q.Since.IsZero() {
+		db = db.Where("at >= ?", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		db = db.Where("at <= ?", q.Until.UnixNano())
+	}
+	if q.BeforeID > 0 {
+		db = db.Where("id < ?", q.BeforeID)
+	}
+	if q.Ascending {
+		db = db.Order("id ASC")
+	} else {
+		db = db.Order("id DESC")
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+
+	var rows []changeLogModel
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("query change log: %w", err)
+	}
+	out := make([]changeLogEntry, 0, len(rows))
+	for _, row := range rows {
+		e, err := changeLogEntryFrom(row)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func changeLogModelFrom(e changeLogEntry) (changeLogModel, error) {
+	m := changeLogModel{
+		At:       e.At.UnixNano(),
+		Actor:    e.Actor,
+		ClientIP: e.ClientIP,
+		Origin:   e.Origin,
+		Reason:   e.Reason,
+		Kind:     e.Kind,
+		Op:       e.Op,
+		Zone:     e.Zone,
+		Name:     e.Name,
+		Type:     e.Type,
+	}
+	var err error
+	if e.Kind == changeKindZone {
+		if e.ZoneBefore != nil {
+			m.Before, err = marshalChangeState(e.ZoneBefore)
+		}
+		if err == nil && e.ZoneAfter != nil {
+			m.After, err = marshalChangeState(e.ZoneAfter)
+		}
+	} else {
+		if e.Before != nil {
+			m.Before, err = marshalChangeState(e.Before)
+		}
+		if err == nil && e.After != nil {
+			m.After, err = marshalChangeState(e.After)
+		}
+	}
+	return m, err
+}
+
+func marshalChangeState(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal change log state: %w", err)
+	}
+	return string(b), nil
+}
+
+func changeLogEntryFrom(m changeLogModel) (changeLogEntry, error) {
+	e := changeLogEntry{
+		ID:       m.ID,
+		At:       time.Unix(0, m.At).UTC(),
+		Actor:    m.Actor,
+		ClientIP: m.ClientIP,
+		Origin:   m.Origin,
+		Reason:   m.Reason,
+		Kind:     m.Kind,
+		Op:       m.Op,
+		Zone:     m.Zone,
+		Name:     m.Name,
+		Type:     m.Type,
+	}
+	var err error
+	if e.Kind == changeKindZone {
+		if m.Before != "" {
+			e.ZoneBefore = new(zoneConfig)
+			err = json.Unmarshal([]byte(m.Before), e.ZoneBefore)
+		}
+		if err == nil && m.After != "" {
+			e.ZoneAfter = new(zoneConfig)
+			err = json.Unmarshal([]byte(m.After), e.ZoneAfter)
+		}
+	} else {
+		if m.Before != "" {
+			err = json.Unmarshal([]byte(m.Before), &e.Before)
+		}
+		if err == nil && m.After != "" {
+			err = json.Unmarshal([]byte(m.After), &e.After)
+		}
+	}
+	if err != nil {
+		return changeLogEntry{}, fmt.Errorf("decode change log %d: %w", m.ID, err)
+	}
+	return e, nil
+}
+
+func (p *persistence) loadAPITokens() ([]apiToken, error) {
+	var rows []apiTokenModel
+	if err := p.db.Order("name").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load api tokens: %w", err)
+	}
+	out := make([]apiToken, 0, len(rows))
+	for _, row := range rows {
+		t := apiToken{
+			Name:      row.Name,
+			Prefix:    row.Prefix,
+			ExpiresAt: derefTime(row.ExpiresAt),
+			CreatedAt: row.CreatedAt,
+			hash:      row.SecretHash,
+		}
+		for _, f := range []struct {
+			src string
+			dst *[]string
+		}{{row.ScopesJSON, &t.Scopes}, {row.ZonesJSON, &t.Zones}, {row.NamesJSON, &t.Names}, {row.AllowedIPsJSON, &t.AllowedIPs}} {
+			if err := json.Unmarshal([]byte(f.src), f.dst); err != nil {
+				return nil, fmt.Errorf("decode api token %s: %w", row.Name, err)
+			}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (p *persistence) createAPIToken(t apiToken) error {
+	model := apiTokenModel{
+		Name:       t.Name,
+		SecretHash: t.hash,
+		Prefix:     t.Prefix,
+		ExpiresAt:  timePtr(t.ExpiresAt),
+		CreatedAt:  t.CreatedAt,
+	}
+	for _, f := range []struct {
+		src []string
+		dst *string
+	}{{t.Scopes, &model.ScopesJSON}, {t.Zones, &model.ZonesJSON}, {t.Names, &model.NamesJSON}, {t.AllowedIPs, &model.AllowedIPsJSON}} {
+		if f.src == nil {
+			f.src = []string{}
+		}
+		b, err := json.Marshal(f.src)
+		if err != nil {
+			return fmt.Errorf("encode api token %s: %w", t.Name, err)
+		}
+		*f.dst = string(b)
+	}
+	if err := p.db.Create(&model).Error; err != nil {
+		return fmt.Errorf("create api token %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+func (p *persistence) deleteAPIToken(name string) error {
+	if err := p.db.Delete(&apiTokenModel{}, "name = ?", name).Error; err != nil {
+		return fmt.Errorf("delete api token %s: %w", name, err)
+	}
+	return nil
+}
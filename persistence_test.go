@@ -14,8 +14,8 @@ func TestPersistenceRoundTrip(t *testing.T) {
 	}
 
 	now := time.Now().UTC()
-	z := zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 7, UpdatedAt: now}
-	r := aRecord{Name: "app.example.com", Zone: "example.com", IP: "203.0.113.8", TTL: 30, Version: 99, Source: "n1", UpdatedAt: now}
+	z := zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 7, Refresh: 7200, Retry: 900, Expire: 1814400, NegativeTTL: 300, Mbox: "dns.example.com.", MName: "primary.example.net.", UpdatedAt: now}
+	r := aRecord{Name: "app.example.com", Zone: "example.com", IP: "203.0.113.8", TTL: 30, Version: 99, Source: "n1", UpdatedAt: now, ExpiresAt: now.Add(time.Hour).Truncate(time.Second)}
 
 	if err := p.upsertZone(z); err != nil {
 		t.Fatalf("upsertZone: %v", err)
@@ -29,9 +29,13 @@ func TestPersistenceRoundTrip(t *testing.T) {
 		t.Fatalf("loadIntoStore: %v", err)
 	}
 
-	if _, ok := loaded.getZone("example.com"); !ok {
+	gotZone, ok := loaded.getZone("example.com")
+	if !ok {
 		t.Fatal("expected zone after load")
 	}
+	if gotZone.Refresh != 7200 || gotZone.Retry != 900 || gotZone.Expire != 1814400 || gotZone.NegativeTTL != 300 || gotZone.Mbox != "dns.example.com." || gotZone.MName != "primary.example.net." {
+		t.Fatalf("unexpected loaded SOA fields: %+v", gotZone)
+	}
 	got, ok := loaded.getRecord("app.example.com")
 	if !ok {
 		t.Fatal("expected record after load")
@@ -39,6 +43,36 @@ func TestPersistenceRoundTrip(t *testing.T) {
 	if got.IP != "203.0.113.8" {
 		t.Fatalf("unexpected loaded IP: %s", got.IP)
 	}
+	if !got.ExpiresAt.Equal(r.ExpiresAt) {
+		t.Fatalf("unexpected loaded expires_at: %s", got.ExpiresAt)
+	}
+}
+
+func TestPersistenceAddRecordClearsExpiry(t *testing.T) {
+	p, err := newPersistence(filepath.Join(t.TempDir(), "expiry.db"), "migrations")
+	if err != nil {
+		t.Fatalf("newPersistence: %v", err)
+	}
+
+	now := time.Now().UTC()
+	r := aRecord{Name: "app.example.com", Zone: "example.com", Type: "A", IP: "203.0.113.8", TTL: 30, Version: 1, Source: "n1", UpdatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := p.addRecord(r); err != nil {
+		t.Fatalf("addRecord: %v", err)
+	}
+	r.Version = 2
+	r.ExpiresAt = time.Time{}
+	if err := p.addRecord(r); err != nil {
+		t.Fatalf("addRecord renew: %v", err)
+	}
+
+	loaded := newStore()
+	if err := p.loadIntoStore(loaded); err != nil {
+		t.Fatalf("loadIntoStore: %v", err)
+	}
+	got, _ := loaded.getRecord("app.example.com")
+	if got.Version != 2 || !got.ExpiresAt.IsZero() {
+		t.Fatalf("expected expiry cleared at version 2, got %+v", got)
+	}
 }
 
 func TestPersistenceVersionGuard(t *testing.T) {
@@ -68,3 +102,52 @@ func TestPersistenceVersionGuard(t *testing.T) {
 		t.Fatalf("older write should not win, got ip=%s", got.IP)
 	}
 }
+
+func TestPersistenceDeleteZoneKeepsTombstone(t *testing.T) {
+	p, err := newPersistence(filepath.Join(t.TempDir(), "tombstone.db"), "migrations")
+	if err != nil {
+		t.Fatalf("newPersistence: %v", err)
+	}
+
+	now := time.Now().UTC()
+	if err := p.upsertZone(zoneConfig{Zone: "example.com.", NS: []string{"ns1.example.com."}, SOATTL: 60, Serial: 7, UpdatedAt: now}); err != nil {
+		t.Fatalf("upsertZone: %v", err)
+	}
+	if err := p.upsertRecord(aRecord{Name: "app.example.com", Type: "A", Zone: "example.com", IP: "203.0.113.8", TTL: 30, Version: 1, Source: "n1", UpdatedAt: now}); err != nil {
+		t.Fatalf("upsertRecord: %v", err)
+	}
+	if err := p.deleteZone(zoneTombstone{Zone: "example.com.", Serial: 8, DeletedAt: now}, true); err != nil {
+		t.Fatalf("deleteZone: %v", err)
+	}
+
+	loaded := newStore()
+	if err := p.loadIntoStore(loaded); err != nil {
+		t.Fatalf("loadIntoStore: %v", err)
+	}
+	if _, ok := loaded.getZone("example.com"); ok {
+		t.Fatal("expected zone to stay deleted after load")
+	}
+	if loaded.hasName("app.example.com") {
+		t.Fatal("expected cascaded record to stay deleted after load")
+	}
+	if tomb, ok := loaded.view().tombstone("example.com"); !ok || tomb.Serial != 8 {
+		t.Fatalf("expected tombstone at serial 8, got %+v ok=%t", tomb, ok)
+	}
+}
+
+func TestWriteQueueRunsInOrder(t *testing.T) {
+	q := newWriteQueue()
+	var got []int
+	for i := range 100 {
+		q.enqueue(func() { got = append(got, i) })
+	}
+	q.flush()
+	if len(got) != 100 {
+		t.Fatalf("expected 100 writes after flush, got %d", len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("write %d ran as %d", i, v)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// DoQ error codes from RFC 9250 section 4.3.
+const (
+	doqInternalError quic.ApplicationErrorCode = 0x1
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+)
+
+func (s *server) runDoQ(ctx context.Context) error {
+	if s.certs == nil {
+		return errors.New("dns/doq: TLS certificate is not configured")
+	}
+
+	quicConfig := &quic.Config{MaxIdleTimeout: s.cfg.DoQIdleTimeout}
+	listeners := make([]*quic.Listener, 0, len(s.cfg.DNSQUICListen))
+	defer func() {
+		for _, ln := range listeners {
+			_ = ln.Close()
+		}
+	}()
+
+	errCh := make(chan error, len(s.cfg.DNSQUICListen))
+	for _, addr := range s.cfg.DNSQUICListen {
+		ln, err := quic.ListenAddr(addr, s.tlsConfig("doq"), quicConfig)
+		if err != nil {
+			return fmt.Errorf("dns/doq listen %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+
+		l := &dnsListener{network: "doq", addr: ln.Addr().String(), pad: true}
+		s.registerListener(l)
+		go func() { errCh <- s.acceptDoQ(ctx, ln, l) }()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("dns/doq serve: %w", err)
+	}
+}
+
+func (s *server) acceptDoQ(ctx context.Context, ln *quic.Listener, l *dnsListener) error {
+	for {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		go s.serveDoQConn(conn, l)
+	}
+}
+
+func (s *server) serveDoQConn(conn *quic.Conn, l *dnsListener) {
+	ctx := conn.Context()
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go s.serveDoQStream(conn, stream, l)
+	}
+}
+
+// serveDoQStream handles one RFC 9250 query: a two-byte length prefixed DNS
+// message on its own bidirectional stream, answered the same way before the
+// stream is closed.
+func (s *server) serveDoQStream(conn *quic.Conn, stream *quic.Stream, l *dnsListener) {
+	defer stream.Close()
+	_ = stream.SetDeadline(time.Now().Add(s.cfg.DoQIdleTimeout))
+
+	var size [2]byte
+	if _, err := io.ReadFull(stream, size[:]); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(stream, payload); err != nil {
+		return
+	}
+	l.queries.Add(1)
+	queryTime := time.Now()
+
+	var req dns.Msg
+	if err := req.Unpack(payload); err != nil {
+		l.errors.Add(1)
+		_ = conn.CloseWithError(doqProtocolError, "malformed dns message")
+		return
+	}
+	if req.Id != 0 {
+		l.errors.Add(1)
+		_ = conn.CloseWithError(doqProtocolError, "dns message id must be 0")
+		return
+	}
+	if s.cfg.DebugLog {
+		log.Printf("doq query remote=%s q=%s", conn.RemoteAddr().String(), formatDNSQuestions(req.Question))
+	}
+
+	res, err := s.answerDNS(&req)
+	if err != nil {
+		l.errors.Add(1)
+		stream.CancelWrite(quic.StreamErrorCode(doqInternalError))
+		return
+	}
+	wire := padResponse(&req, res.Wire)
+
+	out := make([]byte, 2, 2+len(wire))
+	binary.BigEndian.PutUint16(out, uint16(len(wire)))
+	out = append(out, wire...)
+	if _, err := stream.Write(out); err != nil {
+		l.errors.Add(1)
+		return
+	}
+	l.responses.Add(1)
+	s.observeQuery(l.network, &req, res.Rcode, res.Answers, queryTime)
+	s.tap.log(l.network, conn.RemoteAddr(), conn.LocalAddr(), &req, queryTime, wire)
+}
+
+func (s *server) runHTTP3(ctx context.Context) error {
+	if s.certs == nil {
+		return errors.New("http3: TLS certificate is not configured")
+	}
+
+	servers := make([]*http3.Server, 0, len(s.cfg.HTTP3Listen))
+	conns := make([]net.PacketConn, 0, len(s.cfg.HTTP3Listen))
+	defer func() {
+		for _, srv := range servers {
+			_ = srv.Close()
+		}
+		for _, pc := range conns {
+			_ = pc.Close()
+		}
+	}()
+
+	errCh := make(chan error, len(s.cfg.HTTP3Listen))
+	for _, addr := range s.cfg.HTTP3Listen {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("http3 listen %s: %w", addr, err)
+		}
+		conns = append(conns, pc)
+
+		l := &dnsListener{network: "h3", addr: pc.LocalAddr().String()}
+		s.registerListener(l)
+
+		srv := &http3.Server{
+			Handler:    s.countDNSRequests(l, s.newDoHRouter()),
+			TLSConfig:  http3.ConfigureTLSConfig(s.tlsConfig()),
+			QUICConfig: &quic.Config{MaxIdleTimeout: s.cfg.DoQIdleTimeout},
+		}
+		servers = append(servers, srv)
+		go func() { errCh <- srv.Serve(pc) }()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("http3 serve: %w", err)
+	}
+}
+
+// newDoHRouter serves only the DNS endpoints, for listeners such as HTTP/3
+// that must not expose the control API.
+func (s *server) newDoHRouter() http.Handler {
+	r := chi.NewRouter()
+	if s.cfg.DebugLog {
+		r.Use(s.httpDebugMiddleware)
+	}
+	s.mountDoH(r)
+	return r
+}
+
+func (s *server) countDNSRequests(l *dnsListener, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.queries.Add(1)
+		ww := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(ww, r)
+		if ww.status >= http.StatusBadRequest {
+			l.errors.Add(1)
+			return
+		}
+		l.responses.Add(1)
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func startQUICTestServer(t *testing.T, run func(*server, context.Context) error) (*server, string) {
+	t.Helper()
+	s := newTLSTestServer(t)
+	s.cfg.DNSQUICListen = []string{"127.0.0.1:0"}
+	s.cfg.HTTP3Listen = []string{"127.0.0.1:0"}
+	s.cfg.DoQIdleTimeout = 5 * time.Second
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- run(s, ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("listener returned error: %v", err)
+		}
+	})
+
+	return s, waitForListeners(t, s, 1)[0].Addr
+}
+
+func doqExchange(t *testing.T, conn *quic.Conn, req *dns.Msg) (*dns.Msg, error) {
+	t.Helper()
+	wire, err := req.Pack()
+	if err != nil {
+		t.Fatalf("pack query: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(wire)))
+	if _, err := stream.Write(append(out, wire...)); err != nil {
+		return nil, err
+	}
+	_ = stream.Close()
+
+	body, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 2 || int(binary.BigEndian.Uint16(body)) != len(body)-2 {
+		t.Fatalf("bad DoQ length prefix in %d-byte response", len(body))
+	}
+	var resp dns.Msg
+	if err := resp.Unpack(body[2:]); err != nil {
+		t.Fatalf("unpack DoQ response: %v", err)
+	}
+	return &resp, nil
+}
+
+func TestDoQListenerAnswers(t *testing.T) {
+	s, addr := startQUICTestServer(t, (*server).runDoQ)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"doq"}}, nil)
+	if err != nil {
+		t.Fatalf("dial DoQ: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	for i := 0; i < 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion("app.example.com.", dns.TypeA)
+		req.Id = 0
+		resp, err := doqExchange(t, conn, req)
+		if err != nil {
+			t.Fatalf("DoQ exchange %d: %v", i, err)
+		}
+		if resp.Id != 0 || len(resp.Answer) != 1 {
+			t.Fatalf("unexpected DoQ response: id=%d answers=%d", resp.Id, len(resp.Answer))
+		}
+	}
+
+	if stats := s.listenerStats(); stats[0].Network != "doq" || stats[0].Responses != 2 {
+		t.Fatalf("unexpected DoQ listener stats: %+v", stats)
+	}
+}
+
+func TestDoQRejectsNonZeroMessageID(t *testing.T) {
+	_, addr := startQUICTestServer(t, (*server).runDoQ)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"doq"}}, nil)
+	if err != nil {
+		t.Fatalf("dial DoQ: %v", err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	req.Id = 1234
+	_, err = doqExchange(t, conn, req)
+
+	var appErr *quic.ApplicationError
+	if !errors.As(err, &appErr) || appErr.ErrorCode != doqProtocolError {
+		t.Fatalf("expected DOQ_PROTOCOL_ERROR, got %v", err)
+	}
+}
+
+func TestHTTP3DoH(t *testing.T) {
+	s, addr := startQUICTestServer(t, (*server).runHTTP3)
+
+	tr := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer tr.Close()
+	client := &http.Client{Transport: tr, Timeout: 2 * time.Second}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("app.example.com.", dns.TypeA)
+	wire, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("pack dns msg: %v", err)
+	}
+
+	resp, err := client.Get("https://" + addr + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(wire))
+	if err != nil {
+		t.Fatalf("HTTP/3 DoH GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Fatalf("expected 200 over HTTP/3, got %d %s", resp.StatusCode, resp.Proto)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var out dns.Msg
+	if err := out.Unpack(body); err != nil {
+		t.Fatalf("unpack DoH response: %v", err)
+	}
+	if len(out.Answer) != 1 {
+		t.Fatalf("expected one answer over HTTP/3, got %d", len(out.Answer))
+	}
+
+	apiResp, err := client.Get("https://" + addr + "/v1/records")
+	if err != nil {
+		t.Fatalf("HTTP/3 API GET: %v", err)
+	}
+	apiResp.Body.Close()
+	if apiResp.StatusCode != http.StatusNotFound {
+		t.Fatalf("control API must not be served over HTTP/3, got %d", apiResp.StatusCode)
+	}
+
+	if stats := s.listenerStats(); stats[0].Network != "h3" || stats[0].Responses != 1 || stats[0].Errors != 1 {
+		t.Fatalf("unexpected HTTP/3 listener stats: %+v", stats)
+	}
+}
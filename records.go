@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxRecordsPage bounds the limit of one GET /v1/records page.
+const maxRecordsPage = 1000
+
+// recordFilter selects records for GET /v1/records. Empty fields match
+// everything.
+type recordFilter struct {
+	Zone   string
+	Name   string
+	Suffix string
+	Types  map[string]struct{}
+	Value  string
+	Source string
+	// After is the cursor: only records whose key sorts after it match.
+	After string
+	// Token, when set, hides records outside its zones and names.
+	Token *apiToken
+}
+
+// parseRecordFilter reads the filter from the query string.
+func parseRecordFilter(q url.Values) (recordFilter, error) {
+	f := recordFilter{
+		Value:  strings.TrimSpace(q.Get("value")),
+		Source: strings.TrimSpace(q.Get("source")),
+	}
+	if v := strings.TrimSpace(q.Get("zone")); v != "" {
+		f.Zone = normalizeName(v)
+	}
+	if v := strings.TrimSpace(q.Get("name")); v != "" {
+		f.Name = normalizeName(v)
+	}
+	if v := strings.TrimSpace(q.Get("suffix")); v != "" {
+		f.Suffix = normalizeName(v)
+	}
+	if v := strings.TrimSpace(q.Get("type")); v != "" {
+		f.Types = make(map[string]struct{})
+		for _, t := range strings.Split(v, ",") {
+			t = strings.ToUpper(strings.TrimSpace(t))
+			switch t {
+			case "A", "AAAA", "TXT", "CNAME", "MX":
+				f.Types[t] = struct{}{}
+			default:
+				return recordFilter{}, errors.New("type must be A, AAAA, TXT, CNAME or MX")
+			}
+		}
+	}
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		after, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(after) == 0 {
+			return recordFilter{}, errors.New("invalid cursor")
+		}
+		f.After = string(after)
+	}
+	return f, nil
+}
+
+func (f recordFilter) match(rec aRecord) bool {
+	if f.Zone != "" && rec.Zone != f.Zone {
+		return false
+	}
+	if f.Name != "" && rec.Name != f.Name {
+		return false
+	}
+	if f.Suffix != "" && f.Suffix != "." && rec.Name != f.Suffix && !strings.HasSuffix(rec.Name, "."+f.Suffix) {
+		return false
+	}
+	if f.Types != nil {
+		if _, ok := f.Types[rec.Type]; !ok {
+			return false
+		}
+	}
+	if f.Source != "" && rec.Source != f.Source {
+		return false
+	}
+	if f.Value != "" && !recordValueMatches(rec, f.Value) {
+		return false
+	}
+	if f.Token != nil && !f.Token.allowsRecord(rec) {
+		return false
+	}
+	return f.After == "" || recordKey(rec) > f.After
+}
+
+// recordValueMatches reports whether value is the record's data: the
+// address of A and AAAA records (in any notation), the TXT text, or the
+// CNAME and MX target.
+func recordValueMatches(rec aRecord, value string) bool {
+	switch rec.Type {
+	case "A", "AAAA":
+		want, err := netip.ParseAddr(value)
+		if err != nil {
+			return false
+		}
+		got, err := netip.ParseAddr(rec.IP)
+		return err == nil && got == want
+	case "TXT":
+		return rec.Text == value
+	case "CNAME", "MX":
+		return normalizeName(rec.Target) == normalizeName(value)
+	}
+	return false
+}
+
+// walkRecords calls fn with the live records of view matching f in key
+// order (name, type, value), starting after the cursor, until fn returns
+// false. Owner names are walked through the snapshot's sorted name index,
+// so a page costs the records it returns rather than a sort of the store.
+func walkRecords(view *storeView, f recordFilter, fn func(aRecord) bool) {
+	if f.Name != "" {
+		for _, rec := range view.getRecords(f.Name, dns.TypeANY) {
+			if f.match(rec) && !fn(rec) {
+				return
+			}
+		}
+		return
+	}
+	names := view.sortedNames()
+	start := 0
+	if f.After != "" {
+		after, _, _ := strings.Cut(f.After, "|")
+		start = sort.Search(len(names), func(i int) bool { return compareOwnerKeys(names[i], after) >= 0 })
+	}
+	for _, name := range names[start:] {
+		for _, rec := range view.getRecords(name, dns.TypeANY) {
+			if f.match(rec) && !fn(rec) {
+				return
+			}
+		}
+	}
+}
+
+// filterRecords returns the live records of view matching f in key order.
+// With limit > 0 at most limit records are returned, along with the cursor
+// for the next page when more remain.
+func filterRecords(view *storeView, f recordFilter, limit int) ([]aRecord, string) {
+	out := make([]aRecord, 0)
+	more := false
+	walkRecords(view, f, func(rec aRecord) bool {
+		if limit > 0 && len(out) == limit {
+			more = true
+			return false
+		}
+		out = append(out, rec)
+		return true
+	})
+	if !more {
+		return out, ""
+	}
+	return out, base64.RawURLEncoding.EncodeToString([]byte(recordKey(out[limit-1])))
+}
+
+// handleRecords lists records. Without a limit every matching record is
+// returned, as JSON or, with format=ndjson, streamed one record per line.
+func (s *server) handleRecords(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := parseRecordFilter(q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	f.Token = requestAPIToken(r)
+
+	limit := 0
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRecordsPage {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxRecordsPage)})
+			return
+		}
+		limit = n
+	}
+
+	view := s.data.view()
+	if wantsNDJSON(r) {
+		writeRecordsNDJSON(w, view, f, limit)
+		return
+	}
+
+	recs, next := filterRecords(view, f, limit)
+	resp := map[string]any{"records": recs}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ndjsonFlushEvery is how many records an NDJSON listing writes between
+// flushes to the client.
+const ndjsonFlushEvery = 256
+
+// writeRecordsNDJSON writes the records matching f one JSON object per line.
+// A page is collected first, since its next cursor goes in the
+// X-Next-Cursor header; without a limit, records are encoded as the walk
+// produces them and flushed every ndjsonFlushEvery lines, so the listing
+// never holds the whole store.
+func writeRecordsNDJSON(w http.ResponseWriter, view *storeView, f recordFilter, limit int) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if limit > 0 {
+		recs, next := filterRecords(view, f, limit)
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, rec := range recs {
+			if err := enc.Encode(rec); err != nil {
+				return
+			}
+		}
+		_ = bw.Flush()
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	n := 0
+	walkRecords(view, f, func(rec aRecord) bool {
+		if err := enc.Encode(rec); err != nil {
+			return false
+		}
+		if n++; n%ndjsonFlushEvery == 0 {
+			if err := bw.Flush(); err != nil {
+				return false
+			}
+			_ = rc.Flush()
+		}
+		return true
+	})
+	_ = bw.Flush()
+}
+
+// handleRecordGet returns the live records of one name, optionally limited
+// to some types, or 404 when it has none. The response carries each RRset's
+// ETag, and the ETag header matches what If-Match on a write with the same
+// type (or a typeless DELETE) is checked against.
+func (s *server) handleRecordGet(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := parseRecordFilter(url.Values{"type": r.URL.Query()["type"]})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	f.Name = name
+	f.Token = requestAPIToken(r)
+
+	view := s.data.view()
+	recs, _ := filterRecords(view, f, 0)
+	if len(recs) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return
+	}
+	etags := make(map[string]string)
+	for _, rec := range recs {
+		if _, ok := etags[rec.Type]; !ok {
+			etags[rec.Type] = view.rrsetETag(name, rec.Type)
+		}
+	}
+	switch len(f.Types) {
+	case 0:
+		setETag(w, view.rrsetETag(name, ""))
+	case 1:
+		for recordType := range f.Types {
+			setETag(w, view.rrsetETag(name, recordType))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"name": name, "records": recs, "etags": etags})
+}
+
+func wantsNDJSON(r *http.Request) bool {
+	if strings.EqualFold(strings.TrimSpace(r.URL.Query().Get("format")), "ndjson") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func seedListRecords(s *server) {
+	for _, rec := range []aRecord{
+		{Name: "a.example.com.", Type: "A", IP: "203.0.113.10", TTL: 20, Zone: "example.com.", Version: 1, Source: "node-a"},
+		{Name: "a.example.com.", Type: "TXT", Text: "hello", TTL: 20, Zone: "example.com.", Version: 1, Source: "node-a"},
+		{Name: "b.example.com.", Type: "A", IP: "203.0.113.10", TTL: 20, Zone: "example.com.", Version: 1, Source: "node-b"},
+		{Name: "c.dev.example.com.", Type: "CNAME", Target: "a.example.com.", TTL: 20, Zone: "example.com.", Version: 1, Source: "node-b"},
+		{Name: "www.example.net.", Type: "AAAA", IP: "2001:db8::10", TTL: 20, Zone: "example.net.", Version: 1, Source: "node-a"},
+	} {
+		s.data.addRecord(rec)
+	}
+}
+
+func recordNames(t *testing.T, body []byte) ([]string, string) {
+	t.Helper()
+	var resp struct {
+		Records    []aRecord `json:"records"`
+		NextCursor string    `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	names := make([]string, 0, len(resp.Records))
+	for _, rec := range resp.Records {
+		names = append(names, rec.Name+"/"+rec.Type)
+	}
+	return names, resp.NextCursor
+}
+
+func TestRecordsFilters(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	seedListRecords(s)
+
+	cases := map[string]string{
+		"/v1/records":                                 "a.example.com./A a.example.com./TXT b.example.com./A c.dev.example.com./CNAME www.example.net./AAAA",
+		"/v1/records?zone=example.net":                "www.example.net./AAAA",
+		"/v1/records?name=A.example.com":              "a.example.com./A a.example.com./TXT",
+		"/v1/records?suffix=dev.example.com":          "c.dev.example.com./CNAME",
+		"/v1/records?type=a,aaaa":                     "a.example.com./A b.example.com./A www.example.net./AAAA",
+		"/v1/records?value=203.0.113.10":              "a.example.com./A b.example.com./A",
+		"/v1/records?value=2001:0db8::0010":           "www.example.net./AAAA",
+		"/v1/records?value=a.example.com":             "c.dev.example.com./CNAME",
+		"/v1/records?source=node-b&zone=example.com.": "b.example.com./A c.dev.example.com./CNAME",
+	}
+	for path, want := range cases {
+		rr := apiRequest(t, h, http.MethodGet, path, "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		names, _ := recordNames(t, rr.Body.Bytes())
+		if got := strings.Join(names, " "); got != want {
+			t.Fatalf("%s: expected %q, got %q", path, want, got)
+		}
+	}
+
+	for _, path := range []string{"/v1/records?type=SRV", "/v1/records?limit=0", "/v1/records?limit=1001", "/v1/records?cursor=!!"} {
+		if rr := apiRequest(t, h, http.MethodGet, path, "", nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestRecordsPagination(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	seedListRecords(s)
+
+	var all []string
+	cursor := ""
+	pages := 0
+	for {
+		path := "/v1/records?limit=2"
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		rr := apiRequest(t, h, http.MethodGet, path, "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		names, next := recordNames(t, rr.Body.Bytes())
+		all = append(all, names...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if pages != 3 || len(all) != 5 {
+		t.Fatalf("expected 5 records over 3 pages, got %d over %d: %v", len(all), pages, all)
+	}
+	if all[0] != "a.example.com./A" || all[4] != "www.example.net./AAAA" {
+		t.Fatalf("unexpected page order: %v", all)
+	}
+}
+
+func TestRecordGetByName(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	seedListRecords(s)
+
+	rr := apiRequest(t, h, http.MethodGet, "/v1/records/a.example.com", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if names, _ := recordNames(t, rr.Body.Bytes()); len(names) != 2 {
+		t.Fatalf("expected both RRsets, got %v", names)
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/v1/records/a.example.com?type=TXT", "", nil)
+	if names, _ := recordNames(t, rr.Body.Bytes()); len(names) != 1 || names[0] != "a.example.com./TXT" {
+		t.Fatalf("expected TXT only, got %v", names)
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records/missing.example.com", "", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestRecordsNDJSON(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	seedListRecords(s)
+
+	rr := apiRequest(t, h, http.MethodGet, "/v1/records?format=ndjson&zone=example.com", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	lines := 0
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var rec aRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if rec.Zone != "example.com." {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		lines++
+	}
+	if lines != 4 {
+		t.Fatalf("expected 4 lines, got %d", lines)
+	}
+}
+
+func TestRecordsNDJSONStreamsInKeyOrder(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	total := 2*ndjsonFlushEvery + 5
+	for i := range total {
+		s.data.addRecord(aRecord{Name: fmt.Sprintf("host%04d.example.com.", i), Type: "A", IP: "192.0.2.1", TTL: 20, Zone: "example.com.", Version: 1})
+	}
+
+	rr := apiRequest(t, h, http.MethodGet, "/v1/records?format=ndjson", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !rr.Flushed {
+		t.Fatal("expected the listing to be flushed while streaming")
+	}
+	var prev string
+	lines := 0
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var rec aRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if key := recordKey(rec); key <= prev {
+			t.Fatalf("line %d out of order: %s after %s", lines, key, prev)
+		} else {
+			prev = key
+		}
+		lines++
+	}
+	if lines != total {
+		t.Fatalf("expected %d lines, got %d", total, lines)
+	}
+}
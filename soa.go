@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SOA timer defaults and bounds follow RFC 1912 section 2.2: refresh between
+// 20 minutes and 12 hours, retry below refresh, expire between two and four
+// weeks. The negative TTL is capped at one day per RFC 2308.
+const (
+	soaDefaultRefresh = 3600
+	soaDefaultRetry   = 600
+	soaDefaultExpire  = 1209600
+
+	soaMinRefresh     = 1200
+	soaMaxRefresh     = 43200
+	soaMinExpire      = 1209600
+	soaMaxExpire      = 2419200
+	soaMaxNegativeTTL = 86400
+)
+
+// Serial schemes select how nextSerial advances a zone serial.
+const (
+	serialSchemeUnix    = "unix"
+	serialSchemeDate    = "date"
+	serialSchemeCounter = "counter"
+)
+
+func validSerialScheme(scheme string) bool {
+	switch scheme {
+	case serialSchemeUnix, serialSchemeDate, serialSchemeCounter:
+		return true
+	}
+	return false
+}
+
+// serialGreater reports whether a is greater than b in RFC 1982 serial
+// number arithmetic. Serials exactly 2^31 apart are incomparable and report
+// false both ways.
+func serialGreater(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// nextSerial returns the serial following cur under scheme: the current Unix
+// time, YYYYMMDDnn for today, or cur+1. The result is always greater than a
+// non-zero cur in RFC 1982 terms; when the scheme value would not be, cur is
+// incremented instead. Zero is skipped since it marks an unset serial.
+func nextSerial(scheme string, cur uint32, now time.Time) uint32 {
+	var next uint32
+	switch scheme {
+	case serialSchemeDate:
+		now = now.UTC()
+		next = uint32(now.Year())*1000000 + uint32(now.Month())*10000 + uint32(now.Day())*100
+	case serialSchemeCounter:
+		next = cur + 1
+	default:
+		next = uint32(now.Unix())
+	}
+	if cur != 0 && !serialGreater(next, cur) {
+		next = cur + 1
+	}
+	if next == 0 {
+		next = 1
+	}
+	return next
+}
+
+// applySOADefaults fills unset SOA timers. The negative TTL follows the SOA
+// TTL up to its one day cap. The mailbox and primary master stay empty so
+// they keep following the zone apex and first NS. Zones that predate serial
+// schemes used Unix time serials.
+func applySOADefaults(z *zoneConfig) {
+	if z.SerialScheme == "" {
+		z.SerialScheme = serialSchemeUnix
+	}
+	if z.Refresh == 0 {
+		z.Refresh = soaDefaultRefresh
+	}
+	if z.Retry == 0 {
+		z.Retry = soaDefaultRetry
+	}
+	if z.Expire == 0 {
+		z.Expire = soaDefaultExpire
+	}
+	if z.NegativeTTL == 0 {
+		z.NegativeTTL = min(z.SOATTL, soaMaxNegativeTTL)
+	}
+}
+
+// inheritSOAFields copies SOA fields left unset in z from the stored zone, so
+// a zone update only changes what it names.
+func inheritSOAFields(z *zoneConfig, existing zoneConfig) {
+	if z.Refresh == 0 {
+		z.Refresh = existing.Refresh
+	}
+	if z.Retry == 0 {
+		z.Retry = existing.Retry
+	}
+	if z.Expire == 0 {
+		z.Expire = existing.Expire
+	}
+	if z.NegativeTTL == 0 {
+		z.NegativeTTL = existing.NegativeTTL
+	}
+	if z.Mbox == "" {
+		z.Mbox = existing.Mbox
+	}
+	if z.MName == "" {
+		z.MName = existing.MName
+	}
+	if z.SerialScheme == "" {
+		z.SerialScheme = existing.SerialScheme
+	}
+}
+
+func validateSOA(z zoneConfig) error {
+	if !validSerialScheme(z.SerialScheme) {
+		return errors.New("serial_scheme must be unix, date or counter")
+	}
+	if z.Refresh < soaMinRefresh || z.Refresh > soaMaxRefresh {
+		return fmt.Errorf("refresh must be between %d and %d seconds", soaMinRefresh, soaMaxRefresh)
+	}
+	if z.Retry == 0 || z.Retry >= z.Refresh {
+		return errors.New("retry must be greater than zero and less than refresh")
+	}
+	if z.Expire < soaMinExpire || z.Expire > soaMaxExpire {
+		return fmt.Errorf("expire must be between %d and %d seconds", soaMinExpire, soaMaxExpire)
+	}
+	if z.NegativeTTL > soaMaxNegativeTTL {
+		return fmt.Errorf("negative_ttl must be at most %d seconds", soaMaxNegativeTTL)
+	}
+	if z.Mbox != "" {
+		if _, ok := dns.IsDomainName(z.Mbox); !ok || dns.CountLabel(z.Mbox) < 2 {
+			return errors.New("mbox must be a mailbox such as hostmaster@example.com")
+		}
+	}
+	if z.MName != "" {
+		if _, ok := dns.IsDomainName(z.MName); !ok || z.MName == "." {
+			return errors.New("mname must be a domain name")
+		}
+	}
+	return nil
+}
+
+// normalizeMailbox turns "user@domain" into the SOA RNAME form, escaping
+// dots in the local part. Names already in domain form are only normalized.
+func normalizeMailbox(mbox string) string {
+	mbox = strings.TrimSpace(mbox)
+	if mbox == "" {
+		return ""
+	}
+	local, domain, ok := strings.Cut(mbox, "@")
+	if !ok {
+		return normalizeName(mbox)
+	}
+	return strings.ReplaceAll(local, ".", `\.`) + "." + normalizeName(domain)
+}
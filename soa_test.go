@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestValidateSOA(t *testing.T) {
+	base := zoneConfig{Zone: "example.com.", SOATTL: 60}
+	applySOADefaults(&base)
+	if err := validateSOA(base); err != nil {
+		t.Fatalf("defaults should validate: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		edit func(*zoneConfig)
+	}{
+		{"refresh too low", func(z *zoneConfig) { z.Refresh = 30 }},
+		{"refresh too high", func(z *zoneConfig) { z.Refresh = 86400 }},
+		{"retry not below refresh", func(z *zoneConfig) { z.Retry = z.Refresh }},
+		{"expire too low", func(z *zoneConfig) { z.Expire = 300 }},
+		{"expire too high", func(z *zoneConfig) { z.Expire = 4838400 }},
+		{"negative ttl too high", func(z *zoneConfig) { z.NegativeTTL = 172800 }},
+		{"bad mbox", func(z *zoneConfig) { z.Mbox = "hostmaster." }},
+		{"bad mname", func(z *zoneConfig) { z.MName = "bad..name." }},
+	}
+	for _, tc := range cases {
+		z := base
+		tc.edit(&z)
+		if err := validateSOA(z); err == nil {
+			t.Fatalf("%s: expected validation error", tc.name)
+		}
+	}
+}
+
+func TestNormalizeMailbox(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"hostmaster@Example.com":    "hostmaster.example.com.",
+		"john.doe@example.com":      `john\.doe.example.com.`,
+		"dns-admin.example.com":     "dns-admin.example.com.",
+		" hostmaster.example.com. ": "hostmaster.example.com.",
+	}
+	for in, want := range cases {
+		if got := normalizeMailbox(in); got != want {
+			t.Fatalf("normalizeMailbox(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSOAForZoneDefaults(t *testing.T) {
+	soa := soaForZone(zoneConfig{Zone: "example.com.", NS: []string{"ns1.example.net."}, SOATTL: 60, Serial: 5}).(*dns.SOA)
+	if soa.Ns != "ns1.example.net." || soa.Mbox != "hostmaster.example.com." {
+		t.Fatalf("unexpected SOA names: %s %s", soa.Ns, soa.Mbox)
+	}
+	if soa.Refresh != soaDefaultRefresh || soa.Retry != soaDefaultRetry || soa.Expire != soaDefaultExpire || soa.Minttl != 60 {
+		t.Fatalf("unexpected SOA timers: %+v", soa)
+	}
+}
+
+func TestSerialGreater(t *testing.T) {
+	cases := []struct {
+		a, b uint32
+		want bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{5, 5, false},
+		{0, 4294967295, true},
+		{4294967295, 0, false},
+		{100, 4294967200, true},
+		{2147483648, 0, false},
+		{0, 2147483648, false},
+	}
+	for _, tc := range cases {
+		if got := serialGreater(tc.a, tc.b); got != tc.want {
+			t.Fatalf("serialGreater(%d, %d) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)
+	unix := uint32(now.Unix())
+
+	cases := []struct {
+		name   string
+		scheme string
+		cur    uint32
+		want   uint32
+	}{
+		{"unix from unset", serialSchemeUnix, 0, unix},
+		{"unix advances", serialSchemeUnix, unix - 60, unix},
+		{"unix same second", serialSchemeUnix, unix, unix + 1},
+		{"date from unset", serialSchemeDate, 0, 2026031400},
+		{"date same day", serialSchemeDate, 2026031400, 2026031401},
+		{"date new day", serialSchemeDate, 2026031307, 2026031400},
+		{"date from unix serial", serialSchemeDate, unix, 2026031400},
+		{"counter from unset", serialSchemeCounter, 0, 1},
+		{"counter advances", serialSchemeCounter, 41, 42},
+		{"counter wraps past zero", serialSchemeCounter, 4294967295, 1},
+	}
+	for _, tc := range cases {
+		got := nextSerial(tc.scheme, tc.cur, now)
+		if got != tc.want {
+			t.Fatalf("%s: nextSerial = %d, want %d", tc.name, got, tc.want)
+		}
+		if tc.cur != 0 && !serialGreater(got, tc.cur) {
+			t.Fatalf("%s: %d is not greater than %d", tc.name, got, tc.cur)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	statsBucketWidth = time.Minute
+	statsBuckets     = 60
+	statsTopK        = 200
+	statsCMSDepth    = 4
+	statsCMSWidth    = 2048
+	statsQueueSize   = 8192
+)
+
+// statsWindows are the sliding windows served by /v1/stats. Each is a whole
+// number of one-minute buckets, the longest covering the full ring.
+var statsWindows = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+}
+
+// newQueryStats starts the aggregator. Queries are handed over on a bounded
+// queue and folded into per-minute sketches by a single goroutine, so the
+// query path only pays for a channel send.
+func newQueryStats(data *store) *queryStats {
+	q := &queryStats{
+		data:   data,
+		events: make(chan statsEvent, statsQueueSize),
+	}
+	go func() {
+		for ev := range q.events {
+			q.apply(ev)
+		}
+	}()
+	return q
+}
+
+func (q *queryStats) observe(req *dns.Msg, rcode, answers int, at time.Time) {
+	if q == nil || len(req.Question) == 0 {
+		return
+	}
+	ev := statsEvent{
+		name:    req.Question[0].Name,
+		qtype:   req.Question[0].Qtype,
+		rcode:   rcode,
+		answers: answers,
+		at:      at,
+	}
+	select {
+	case q.events <- ev:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+func (q *queryStats) apply(ev statsEvent) {
+	name := normalizeName(ev.name)
+	zone := "none"
+	if z, ok := q.data.view().bestZone(name); ok {
+		zone = z.Zone
+	}
+	qtype := statsTypeName(ev.qtype)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b := q.bucketLocked(ev.at)
+	b.queries++
+	b.names.add(name, 1)
+	if ev.rcode == dns.RcodeNameError {
+		b.nxdomain.add(name, 1)
+	}
+	if ev.rcode == dns.RcodeSuccess && ev.answers > 0 {
+		key := name + "|" + qtype
+		b.records.add(key, 1)
+		b.recordHits.add(key)
+	}
+	types := b.zones[zone]
+	if types == nil {
+		types = make(map[string]uint64)
+		b.zones[zone] = types
+	}
+	types[qtype]++
+}
+
+// bucketLocked returns the bucket for the minute containing at, recycling
+// the ring slot when it still holds an older minute.
+func (q *queryStats) bucketLocked(at time.Time) *statsBucket {
+	minute := at.Unix() / int64(statsBucketWidth/time.Second)
+	slot := int(minute % statsBuckets)
+	b := q.buckets[slot]
+	if b == nil {
+		b = &statsBucket{}
+		q.buckets[slot] = b
+	}
+	if b.names == nil || b.minute != minute {
+		b.reset(minute)
+	}
+	return b
+}
+
+func (b *statsBucket) reset(minute int64) {
+	b.minute = minute
+	b.queries = 0
+	b.names = newTopK(statsTopK)
+	b.nxdomain = newTopK(statsTopK)
+	b.records = newTopK(statsTopK)
+	b.recordHits = countMin{}
+	b.zones = make(map[string]map[string]uint64)
+}
+
+// bucketsLocked returns the buckets covering the window ending at now.
+func (q *queryStats) bucketsLocked(window time.Duration, now time.Time) []*statsBucket {
+	current := now.Unix() / int64(statsBucketWidth/time.Second)
+	oldest := current - int64(window/statsBucketWidth) + 1
+
+	out := make([]*statsBucket, 0, statsBuckets)
+	for _, b := range q.buckets {
+		if b != nil && b.names != nil && b.minute >= oldest && b.minute <= current {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// report merges the buckets in window. Top lists sum the per-minute
+// Space-Saving counters, so counts are upper bounds and error is the
+// accumulated overestimate.
+func (q *queryStats) report(window time.Duration, now time.Time, limit int) statsReport {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	buckets := q.bucketsLocked(window, now)
+	out := statsReport{
+		From:    now.Add(-window).UTC(),
+		To:      now.UTC(),
+		Dropped: q.dropped.Load(),
+		Zones:   make(map[string]map[string]uint64),
+	}
+
+	names := make([]*topK, 0, len(buckets))
+	nxdomain := make([]*topK, 0, len(buckets))
+	records := make([]*topK, 0, len(buckets))
+	for _, b := range buckets {
+		out.Queries += b.queries
+		names = append(names, b.names)
+		nxdomain = append(nxdomain, b.nxdomain)
+		records = append(records, b.records)
+		for zone, types := range b.zones {
+			dst := out.Zones[zone]
+			if dst == nil {
+				dst = make(map[string]uint64, len(types))
+				out.Zones[zone] = dst
+			}
+			for qtype, n := range types {
+				dst[qtype] += n
+			}
+		}
+	}
+
+	out.TopNames = mergeTopK(names, limit)
+	out.NXDomain = mergeTopK(nxdomain, limit)
+	for _, c := range mergeTopK(records, limit) {
+		name, qtype, _ := strings.Cut(c.Name, "|")
+		out.TopRecords = append(out.TopRecords, statsRecordCount{Name: name, Type: qtype, Count: c.Count, Error: c.Error})
+	}
+	return out
+}
+
+// recordHits estimates answered queries for one RRset in window from the
+// count-min sketches. The estimate never undercounts.
+func (q *queryStats) recordHits(name, recordType string, window time.Duration, now time.Time) uint64 {
+	key := normalizeName(name) + "|" + strings.ToUpper(recordType)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var total uint64
+	for _, b := range q.bucketsLocked(window, now) {
+		total += b.recordHits.estimate(key)
+	}
+	return total
+}
+
+func statsTypeName(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	return "other"
+}
+
+func newTopK(capacity int) *topK {
+	return &topK{capacity: capacity, items: make(map[string]*topKItem, capacity)}
+}
+
+// add counts key with the Space-Saving algorithm: once the sketch is full, a
+// new key replaces the current minimum and inherits its count as error.
+func (t *topK) add(key string, n uint64) {
+	if it, ok := t.items[key]; ok {
+		it.count += n
+		heap.Fix(&t.heap, it.index)
+		return
+	}
+	if len(t.items) < t.capacity {
+		it := &topKItem{key: key, count: n}
+		t.items[key] = it
+		heap.Push(&t.heap, it)
+		return
+	}
+
+	evict := t.heap[0]
+	delete(t.items, evict.key)
+	evict.key = key
+	evict.err = evict.count
+	evict.count += n
+	t.items[key] = evict
+	heap.Fix(&t.heap, 0)
+}
+
+func mergeTopK(sketches []*topK, limit int) []statsCount {
+	merged := make(map[string]*statsCount)
+	for _, t := range sketches {
+		for key, it := range t.items {
+			c := merged[key]
+			if c == nil {
+				c = &statsCount{Name: key}
+				merged[key] = c
+			}
+			c.Count += it.count
+			c.Error += it.err
+		}
+	}
+
+	out := make([]statsCount, 0, len(merged))
+	for _, c := range merged {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x any) {
+	it := x.(*topKItem)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *topKHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+func (c *countMin) add(key string) {
+	h1, h2 := countMinHashes(key)
+	for i := range c.rows {
+		c.rows[i][(h1+uint32(i)*h2)%statsCMSWidth]++
+	}
+}
+
+func (c *countMin) estimate(key string) uint64 {
+	h1, h2 := countMinHashes(key)
+	var lowest uint32
+	for i := range c.rows {
+		v := c.rows[i][(h1+uint32(i)*h2)%statsCMSWidth]
+		if i == 0 || v < lowest {
+			lowest = v
+		}
+	}
+	return uint64(lowest)
+}
+
+func countMinHashes(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func statsQuery(name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	return req
+}
+
+func TestQueryStatsReport(t *testing.T) {
+	s := newTestServer(t)
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1})
+	q := &queryStats{data: s.data}
+	now := time.Date(2026, 1, 2, 3, 4, 30, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		q.apply(statsEvent{name: "App.Example.com.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answers: 1, at: now})
+	}
+	q.apply(statsEvent{name: "app.example.com.", qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess, answers: 0, at: now})
+	q.apply(statsEvent{name: "typo.example.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError, at: now.Add(-3 * time.Minute)})
+	q.apply(statsEvent{name: "other.example.net.", qtype: dns.TypeMX, rcode: dns.RcodeRefused, at: now.Add(-30 * time.Minute)})
+
+	report := q.report(5*time.Minute, now, 10)
+	if report.Queries != 7 {
+		t.Fatalf("expected 7 queries in 5m window, got %d", report.Queries)
+	}
+	if len(report.TopNames) != 2 || report.TopNames[0] != (statsCount{Name: "app.example.com.", Count: 6}) {
+		t.Fatalf("unexpected top names: %+v", report.TopNames)
+	}
+	if len(report.NXDomain) != 1 || report.NXDomain[0].Name != "typo.example.com." {
+		t.Fatalf("unexpected nxdomain hotspots: %+v", report.NXDomain)
+	}
+	if len(report.TopRecords) != 1 || report.TopRecords[0] != (statsRecordCount{Name: "app.example.com.", Type: "A", Count: 5}) {
+		t.Fatalf("expected only answered RRsets in top records, got %+v", report.TopRecords)
+	}
+	if got := report.Zones["example.com."]; got["A"] != 6 || got["AAAA"] != 1 {
+		t.Fatalf("unexpected qtype breakdown: %+v", report.Zones)
+	}
+
+	if got := q.report(time.Minute, now, 10).Queries; got != 6 {
+		t.Fatalf("expected 6 queries in 1m window, got %d", got)
+	}
+	hour := q.report(time.Hour, now, 10)
+	if hour.Queries != 8 || hour.Zones["none"]["MX"] != 1 {
+		t.Fatalf("unexpected 1h report: %+v", hour)
+	}
+	if got := q.recordHits("app.example.com", "a", 5*time.Minute, now); got != 5 {
+		t.Fatalf("expected 5 record hits, got %d", got)
+	}
+
+	if got := q.report(time.Hour, now.Add(2*time.Hour), 10).Queries; got != 0 {
+		t.Fatalf("expected old buckets to age out, got %d queries", got)
+	}
+}
+
+func TestTopKBoundedMemory(t *testing.T) {
+	k := newTopK(10)
+	for i := 0; i < 1000; i++ {
+		k.add("hot.example.com.", 1)
+		k.add(fmt.Sprintf("cold-%d.example.com.", i), 1)
+	}
+	if len(k.items) != 10 || len(k.heap) != 10 {
+		t.Fatalf("expected sketch bounded at 10 entries, got %d", len(k.items))
+	}
+
+	top := mergeTopK([]*topK{k}, 1)
+	if top[0].Name != "hot.example.com." || top[0].Count < 1000 {
+		t.Fatalf("expected heavy hitter to survive, got %+v", top)
+	}
+	if top[0].Count-top[0].Error > 1000 {
+		t.Fatalf("guaranteed count exceeds true count: %+v", top[0])
+	}
+}
+
+func TestStatsEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	s.stats = newQueryStats(s.data)
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.7", TTL: 30, Version: 1})
+
+	for _, name := range []string{"app.example.com.", "app.example.com.", "missing.example.com."} {
+		req := statsQuery(name, dns.TypeA)
+		res, err := s.answerDNS(req)
+		if err != nil {
+			t.Fatalf("answerDNS: %v", err)
+		}
+		s.observeQuery("udp", req, res.Rcode, res.Answers, time.Now())
+	}
+
+	r := s.newRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	var report statsReport
+	deadline := time.Now().Add(2 * time.Second)
+	for report.Queries < 3 && time.Now().Before(deadline) {
+		resp := get("/v1/stats?window=1m")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("expected 200 from /v1/stats, got %d", resp.Code)
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+			t.Fatalf("decode stats: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if report.Queries != 3 || report.NodeID != "test-node" || report.Window != "1m" {
+		t.Fatalf("unexpected stats report: %+v", report)
+	}
+	if len(report.NXDomain) != 1 || report.NXDomain[0].Name != "missing.example.com." {
+		t.Fatalf("unexpected nxdomain list: %+v", report.NXDomain)
+	}
+
+	var records struct {
+		Records []statsRecordCount `json:"records"`
+	}
+	resp := get("/v1/stats/records?name=app.example.com&type=A")
+	if err := json.Unmarshal(resp.Body.Bytes(), &records); err != nil {
+		t.Fatalf("decode record stats: %v", err)
+	}
+	if len(records.Records) != 1 || records.Records[0].Count != 2 {
+		t.Fatalf("unexpected record hits: %+v", records.Records)
+	}
+
+	var zones struct {
+		Zones map[string]map[string]uint64 `json:"zones"`
+	}
+	resp = get("/v1/stats/zones?zone=example.com")
+	if err := json.Unmarshal(resp.Body.Bytes(), &zones); err != nil {
+		t.Fatalf("decode zone stats: %v", err)
+	}
+	if len(zones.Zones) != 1 || zones.Zones["example.com."]["A"] != 3 {
+		t.Fatalf("unexpected zone stats: %+v", zones.Zones)
+	}
+
+	for _, path := range []string{"/v1/stats?window=2d", "/v1/stats/names?limit=0", "/v1/stats/names?limit=1000"} {
+		if resp := get(path); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, resp.Code)
+		}
+	}
+}
+
+func BenchmarkQueryStatsObserve(b *testing.B) {
+	q := newQueryStats(newStore())
+	req := statsQuery("app.example.com.", dns.TypeA)
+	now := time.Now()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.observe(req, dns.RcodeSuccess, 1, now)
+	}
+}
@@ -1,144 +1,588 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
 func newStore() *store {
-	return &store{
-		records: make(map[string]aRecord),
-		zones:   make(map[string]zoneConfig),
+	s := &store{}
+	view := &storeView{
+		zones:      make(map[string]zoneConfig),
+		zoneTree:   &zoneNode{},
+		tombstones: make(map[string]zoneTombstone),
+		names:      &nameIndex{},
 	}
+	for i := range view.records {
+		view.records[i] = make(map[string]map[string]rrset)
+	}
+	s.snap.Store(view)
+	return s
+}
+
+// view returns the current immutable snapshot. Callers that perform several
+// lookups for one query should take a single view and use it throughout.
+func (s *store) view() *storeView {
+	return s.snap.Load()
+}
+
+// update runs fn against a private copy of the current snapshot and publishes
+// it atomically if fn changed anything. Writers are serialised; readers never
+// block and keep using whichever snapshot they already loaded.
+func (s *store) update(fn func(tx *storeTxn)) bool {
+	return s.updateAs(changeAuthor{}, fn)
+}
+
+// updateAs is update with the transaction attributed to author, which
+// watchers see on the published change.
+func (s *store) updateAs(author changeAuthor, fn func(tx *storeTxn)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur := s.snap.Load()
+	next := *cur
+	tx := &storeTxn{view: &next}
+	fn(tx)
+	if !tx.changed {
+		return false
+	}
+	tx.view.names = cur.names.next(tx.names)
+
+	s.snap.Store(tx.view)
+	if len(s.watchers) > 0 {
+		change := tx.change()
+		change.Author = author
+		change.Before = cur
+		change.After = tx.view
+		for _, fn := range s.watchers {
+			fn(change)
+		}
+	}
+	return true
+}
+
+// subscribe registers fn to be called after every published change with the
+// names and zones it touched. Callbacks run while the writer lock is held, so
+// they observe changes in order and must not write to the store.
+func (s *store) subscribe(fn func(storeChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, fn)
 }
 
 func (s *store) setRecord(rec aRecord) bool {
+	ok := false
+	s.update(func(tx *storeTxn) { ok = tx.setRecord(rec) })
+	return ok
+}
+
+func (s *store) addRecord(rec aRecord) bool {
+	ok := false
+	s.update(func(tx *storeTxn) { ok = tx.addRecord(rec) })
+	return ok
+}
+
+func (s *store) deleteRecord(name string, version int64) bool {
+	return s.deleteRecordByType(name, "", version)
+}
+
+func (s *store) deleteRecordByType(name, recordType string, version int64) bool {
+	ok := false
+	s.update(func(tx *storeTxn) { ok = tx.deleteRecordByType(name, recordType, version) })
+	return ok
+}
+
+func (s *store) removeRecord(rec aRecord, version int64) bool {
+	ok := false
+	s.update(func(tx *storeTxn) { ok = tx.removeRecord(rec, version) })
+	return ok
+}
+
+func (s *store) upsertZone(z zoneConfig) bool {
+	ok := false
+	s.update(func(tx *storeTxn) { ok = tx.upsertZone(z) })
+	return ok
+}
+
+// deleteZone removes zone, leaving a tombstone at serial, and returns the
+// records it removed when cascade is set.
+func (s *store) deleteZone(zone string, serial uint32, now time.Time, cascade bool) ([]aRecord, bool) {
+	var removed []aRecord
+	ok := false
+	s.update(func(tx *storeTxn) { removed, ok = tx.deleteZone(zone, serial, now, cascade) })
+	return removed, ok
+}
+
+// bumpZoneSerial advances zone's serial under its own scheme and returns the
+// updated config. The read and write happen in one transaction, so
+// concurrent bumps never hand out the same serial.
+func (s *store) bumpZoneSerial(zone string, now time.Time) (zoneConfig, bool) {
+	var out zoneConfig
+	ok := false
+	s.update(func(tx *storeTxn) { out, ok = tx.bumpZoneSerial(zone, now) })
+	return out, ok
+}
+
+// raiseZoneSerial moves zone's serial forward to serial, leaving it alone
+// when the stored serial is already equal or greater.
+func (s *store) raiseZoneSerial(zone string, serial uint32, now time.Time) (zoneConfig, bool) {
+	var out zoneConfig
+	ok := false
+	s.update(func(tx *storeTxn) { out, ok = tx.raiseZoneSerial(zone, serial, now) })
+	return out, ok
+}
+
+// updateIf runs fn like updateAs, but only when every guard holds against
+// the snapshot fn would modify. It reports whether the guards held.
+func (s *store) updateIf(author changeAuthor, guards []precondition, fn func(tx *storeTxn)) bool {
+	met := true
+	s.updateAs(author, func(tx *storeTxn) {
+		if failedGuard(tx.view, guards) >= 0 {
+			met = false
+			return
+		}
+		fn(tx)
+	})
+	return met
+}
+
+// applyChanges applies changes in one transaction, provided every guard
+// holds, and reports which of them changed the store. finish, when set, runs
+// in the same transaction afterwards, so zone bookkeeping is published
+// together with the records.
+func (s *store) applyChanges(author changeAuthor, guards []precondition, changes []recordChange, finish func(tx *storeTxn, applied []bool)) ([]bool, bool) {
+	applied := make([]bool, len(changes))
+	met := s.updateIf(author, guards, func(tx *storeTxn) {
+		for i, c := range changes {
+			applied[i] = tx.applyChange(c)
+		}
+		if finish != nil {
+			finish(tx, applied)
+		}
+	})
+	return applied, met
+}
+
+// expireRecords removes every record whose expires_at is at or before now and
+// returns what it removed, along with the configs of the zones it touched,
+// whose serials it bumps once each in the same transaction. Candidates come
+// from the current snapshot and are rechecked inside the transaction, so a
+// record renewed meanwhile survives.
+func (s *store) expireRecords(now time.Time) ([]aRecord, map[string]zoneConfig) {
+	candidates := s.view().expiredRecords(now)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var out []aRecord
+	zones := make(map[string]zoneConfig)
+	s.updateAs(changeAuthor{Reason: "expiry"}, func(tx *storeTxn) {
+		for _, rec := range candidates {
+			key := recordKey(rec)
+			prevSet := tx.view.rrset(rec.Name, rec.Type)
+			prev, ok := prevSet[key]
+			if !ok || prev.ExpiresAt.IsZero() || now.Before(prev.ExpiresAt) {
+				continue
+			}
+			set := cloneRRSet(prevSet, 0)
+			delete(set, key)
+			tx.putRRSet(rec.Name, rec.Type, set)
+			out = append(out, prev)
+		}
+		for _, rec := range out {
+			if _, ok := zones[rec.Zone]; ok {
+				continue
+			}
+			if z, ok := tx.bumpZoneSerial(rec.Zone, now); ok {
+				zones[rec.Zone] = z
+			}
+		}
+	})
+	return out, zones
+}
+
+func (s *store) getRecords(name string, qtype uint16) []aRecord {
+	return s.view().getRecords(name, qtype)
+}
+
+func (s *store) getRecord(name string) (aRecord, bool) {
+	return s.view().getRecord(name)
+}
+
+func (s *store) hasName(name string) bool {
+	return s.view().hasName(name)
+}
+
+func (s *store) listRecords() []aRecord {
+	return s.view().listRecords()
+}
+
+func (s *store) getZone(zone string) (zoneConfig, bool) {
+	return s.view().getZone(zone)
+}
+
+func (s *store) listZones() []zoneConfig {
+	return s.view().listZones()
+}
+
+func (s *store) bestZone(name string) (zoneConfig, bool) {
+	return s.view().bestZone(name)
+}
+
+func (tx *storeTxn) setRecord(rec aRecord) bool {
 	rec.Name = normalizeName(rec.Name)
 	rec.Type = normalizeRecordType(rec.Type)
 	rec.Zone = normalizeName(rec.Zone)
 	key := recordKey(rec)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for k, prev := range s.records {
-		if prev.Name != rec.Name || prev.Type != rec.Type {
-			continue
-		}
+	for _, prev := range tx.view.rrset(rec.Name, rec.Type) {
 		if prev.Version > rec.Version {
 			return false
 		}
-		delete(s.records, k)
 	}
 
-	if prev, ok := s.records[key]; ok && prev.Version > rec.Version {
-		return false
-	}
-
-	s.records[key] = rec
+	tx.putRRSet(rec.Name, rec.Type, rrset{key: rec})
 	return true
 }
 
-func (s *store) addRecord(rec aRecord) bool {
+func (tx *storeTxn) addRecord(rec aRecord) bool {
 	rec.Name = normalizeName(rec.Name)
 	rec.Type = normalizeRecordType(rec.Type)
 	rec.Zone = normalizeName(rec.Zone)
 	key := recordKey(rec)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if prev, ok := s.records[key]; ok && prev.Version > rec.Version {
+	prevSet := tx.view.rrset(rec.Name, rec.Type)
+	if prev, ok := prevSet[key]; ok && prev.Version > rec.Version {
 		return false
 	}
-	s.records[key] = rec
-	return true
-}
 
-func (s *store) deleteRecord(name string, version int64) bool {
-	return s.deleteRecordByType(name, "", version)
+	set := cloneRRSet(prevSet, 1)
+	set[key] = rec
+	tx.putRRSet(rec.Name, rec.Type, set)
+	return true
 }
 
-func (s *store) deleteRecordByType(name, recordType string, version int64) bool {
+func (tx *storeTxn) deleteRecordByType(name, recordType string, version int64) bool {
 	name = normalizeName(name)
 	recordType = strings.ToUpper(strings.TrimSpace(recordType))
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
 	deleted := false
 
-	for key, prev := range s.records {
-		if prev.Name != name {
+	for typ, prevSet := range tx.view.records[shardFor(name)][name] {
+		if recordType != "" && typ != recordType {
 			continue
 		}
-		if recordType != "" && prev.Type != recordType {
-			continue
+		var set rrset
+		for key, prev := range prevSet {
+			if prev.Version > version {
+				continue
+			}
+			if set == nil {
+				set = cloneRRSet(prevSet, 0)
+			}
+			delete(set, key)
+			deleted = true
 		}
-		if prev.Version > version {
-			continue
+		if set != nil {
+			tx.putRRSet(name, typ, set)
 		}
-		delete(s.records, key)
-		deleted = true
 	}
 
 	return deleted
 }
 
-func (s *store) removeRecord(rec aRecord, version int64) bool {
+func (tx *storeTxn) removeRecord(rec aRecord, version int64) bool {
 	rec.Name = normalizeName(rec.Name)
 	rec.Type = normalizeRecordType(rec.Type)
 	rec.Zone = normalizeName(rec.Zone)
 	key := recordKey(rec)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	prev, ok := s.records[key]
+	prevSet := tx.view.rrset(rec.Name, rec.Type)
+	prev, ok := prevSet[key]
 	if !ok {
 		return false
 	}
 	if prev.Version > version {
 		return false
 	}
-	delete(s.records, key)
+
+	set := cloneRRSet(prevSet, 0)
+	delete(set, key)
+	tx.putRRSet(rec.Name, rec.Type, set)
 	return true
 }
 
-func (s *store) getRecords(name string, qtype uint16) []aRecord {
-	name = normalizeName(name)
+func (tx *storeTxn) upsertZone(z zoneConfig) bool {
+	z.Zone = normalizeName(z.Zone)
+	z.NS = normalizeNames(z.NS)
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	prev, ok := tx.view.zones[z.Zone]
+	if ok && serialGreater(prev.Serial, z.Serial) {
+		return false
+	}
+	if t, ok := tx.view.tombstones[z.Zone]; ok {
+		if !serialGreater(z.Serial, t.Serial) {
+			return false
+		}
+		tx.ownTombstones()
+		delete(tx.view.tombstones, z.Zone)
+	}
 
-	out := make([]aRecord, 0, 2)
-	for _, rec := range s.records {
-		if rec.Name != name {
-			continue
+	tx.ownZones()
+	tx.view.zones[z.Zone] = z
+	tx.view.zoneTree = tx.view.zoneTree.with(dns.SplitDomainName(z.Zone), &z)
+	tx.touchZone(z.Zone)
+	return true
+}
+
+// importRecords adds recs to zone and returns what it added and removed.
+// With replace, the zone's records that recs does not contain are removed
+// first. Records newer than the import are kept.
+func (tx *storeTxn) importRecords(zone string, recs []aRecord, replace bool) (added, removed []aRecord) {
+	if replace {
+		keep := make(map[string]struct{}, len(recs))
+		var version int64
+		for _, rec := range recs {
+			keep[recordKey(normalizeRecord(rec))] = struct{}{}
+			version = max(version, rec.Version)
 		}
-		switch qtype {
-		case dns.TypeA:
-			if rec.Type == "A" {
-				out = append(out, rec)
+		for _, prev := range tx.view.zoneRecords(zone) {
+			if _, ok := keep[recordKey(prev)]; ok {
+				continue
 			}
-		case dns.TypeAAAA:
-			if rec.Type == "AAAA" {
-				out = append(out, rec)
+			if tx.removeRecord(prev, version) {
+				removed = append(removed, prev)
 			}
-		case dns.TypeANY:
-			out = append(out, rec)
-		case dns.TypeTXT:
-			if rec.Type == "TXT" {
-				out = append(out, rec)
-			}
-		case dns.TypeCNAME:
-			if rec.Type == "CNAME" {
-				out = append(out, rec)
+		}
+	}
+	for _, rec := range recs {
+		if tx.addRecord(rec) {
+			added = append(added, rec)
+		}
+	}
+	return added, removed
+}
+
+func (tx *storeTxn) applyChange(c recordChange) bool {
+	switch c.Op {
+	case "set":
+		return tx.setRecord(*c.Record)
+	case "add":
+		return tx.addRecord(*c.Record)
+	case "remove":
+		return tx.removeRecord(*c.Record, c.Version)
+	case "delete":
+		return tx.deleteRecordByType(c.Name, c.Type, c.Version)
+	}
+	return false
+}
+
+func (tx *storeTxn) bumpZoneSerial(zone string, now time.Time) (zoneConfig, bool) {
+	return tx.updateZoneSerial(zone, func(z zoneConfig) uint32 {
+		return nextSerial(z.SerialScheme, z.Serial, now)
+	}, now)
+}
+
+func (tx *storeTxn) raiseZoneSerial(zone string, serial uint32, now time.Time) (zoneConfig, bool) {
+	return tx.updateZoneSerial(zone, func(z zoneConfig) uint32 {
+		if serialGreater(serial, z.Serial) {
+			return serial
+		}
+		return z.Serial
+	}, now)
+}
+
+func (tx *storeTxn) updateZoneSerial(zone string, next func(zoneConfig) uint32, now time.Time) (zoneConfig, bool) {
+	z, found := tx.view.zones[normalizeName(zone)]
+	if !found {
+		return zoneConfig{}, false
+	}
+	serial := next(z)
+	if serial == z.Serial {
+		return zoneConfig{}, false
+	}
+	z.Serial = serial
+	z.UpdatedAt = now
+	return z, tx.upsertZone(z)
+}
+
+// deleteZone removes zone and records a tombstone at serial, so zone upserts
+// at or below that serial are ignored from then on. A zone already newer than
+// serial is kept. With cascade, the records the zone owns are removed too,
+// expired ones included, and returned.
+func (tx *storeTxn) deleteZone(zone string, serial uint32, now time.Time, cascade bool) ([]aRecord, bool) {
+	zone = normalizeName(zone)
+	if z, ok := tx.view.zones[zone]; ok && serialGreater(z.Serial, serial) {
+		return nil, false
+	}
+
+	changed := false
+	if _, ok := tx.view.zones[zone]; ok {
+		tx.ownZones()
+		delete(tx.view.zones, zone)
+		tx.view.zoneTree = tx.view.zoneTree.with(dns.SplitDomainName(zone), nil)
+		changed = true
+	}
+	if t, ok := tx.view.tombstones[zone]; !ok || serialGreater(serial, t.Serial) {
+		tx.ownTombstones()
+		tx.view.tombstones[zone] = zoneTombstone{Zone: zone, Serial: serial, DeletedAt: now}
+		changed = true
+	}
+
+	var removed []aRecord
+	if cascade {
+		for _, rec := range tx.view.zoneRecords(zone) {
+			set := cloneRRSet(tx.view.rrset(rec.Name, rec.Type), 0)
+			delete(set, recordKey(rec))
+			tx.putRRSet(rec.Name, rec.Type, set)
+			removed = append(removed, rec)
+		}
+	}
+	if changed {
+		tx.touchZone(zone)
+	}
+	return removed, changed || len(removed) > 0
+}
+
+// putRRSet installs set as the RRset for name/type in the transaction's view,
+// dropping empty index entries so hasName stays accurate. The shard map and
+// the per-name type map are copied before the first write; published
+// snapshots are never mutated.
+func (tx *storeTxn) putRRSet(name, recordType string, set rrset) {
+	shard := shardFor(name)
+	if !tx.ownedShards[shard] {
+		prev := tx.view.records[shard]
+		next := make(map[string]map[string]rrset, len(prev)+1)
+		for k, v := range prev {
+			next[k] = v
+		}
+		tx.view.records[shard] = next
+		tx.ownedShards[shard] = true
+	}
+	records := tx.view.records[shard]
+
+	prevTypes := records[name]
+	types := make(map[string]rrset, len(prevTypes)+1)
+	for k, v := range prevTypes {
+		types[k] = v
+	}
+	if len(set) == 0 {
+		delete(types, recordType)
+	} else {
+		types[recordType] = set
+	}
+
+	if len(types) == 0 {
+		delete(records, name)
+	} else {
+		records[name] = types
+	}
+	tx.touchName(name)
+}
+
+func (tx *storeTxn) touchName(name string) {
+	if tx.names == nil {
+		tx.names = make(map[string]struct{})
+	}
+	tx.names[name] = struct{}{}
+	tx.changed = true
+}
+
+func (tx *storeTxn) touchZone(zone string) {
+	if tx.zones == nil {
+		tx.zones = make(map[string]struct{})
+	}
+	tx.zones[zone] = struct{}{}
+	tx.changed = true
+}
+
+func (tx *storeTxn) change() storeChange {
+	out := storeChange{
+		Names: make([]string, 0, len(tx.names)),
+		Zones: make([]string, 0, len(tx.zones)),
+	}
+	for name := range tx.names {
+		out.Names = append(out.Names, name)
+	}
+	for zone := range tx.zones {
+		out.Zones = append(out.Zones, zone)
+	}
+	sort.Strings(out.Names)
+	sort.Strings(out.Zones)
+	return out
+}
+
+func (tx *storeTxn) ownZones() {
+	if tx.ownedZones {
+		return
+	}
+	next := make(map[string]zoneConfig, len(tx.view.zones)+1)
+	for k, v := range tx.view.zones {
+		next[k] = v
+	}
+	tx.view.zones = next
+	tx.ownedZones = true
+}
+
+func (tx *storeTxn) ownTombstones() {
+	if tx.ownedTombstones {
+		return
+	}
+	next := make(map[string]zoneTombstone, len(tx.view.tombstones)+1)
+	for k, v := range tx.view.tombstones {
+		next[k] = v
+	}
+	tx.view.tombstones = next
+	tx.ownedTombstones = true
+}
+
+func cloneRRSet(set rrset, extra int) rrset {
+	out := make(rrset, len(set)+extra)
+	for k, v := range set {
+		out[k] = v
+	}
+	return out
+}
+
+func shardFor(name string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return int(h % storeShards)
+}
+
+func (v *storeView) rrset(name, recordType string) rrset {
+	return v.records[shardFor(name)][name][recordType]
+}
+
+func (v *storeView) getRecords(name string, qtype uint16) []aRecord {
+	name = normalizeName(name)
+
+	types := v.records[shardFor(name)][name]
+	if len(types) == 0 {
+		return []aRecord{}
+	}
+
+	var now time.Time
+	out := make([]aRecord, 0, 2)
+	if qtype == dns.TypeANY {
+		for _, set := range types {
+			for _, rec := range set {
+				if rec.liveAt(&now) {
+					out = append(out, rec)
+				}
 			}
-		case dns.TypeMX:
-			if rec.Type == "MX" {
+		}
+	} else {
+		for _, rec := range types[dns.TypeToString[qtype]] {
+			if rec.liveAt(&now) {
 				out = append(out, rec)
 			}
 		}
@@ -146,15 +590,15 @@ func (s *store) getRecords(name string, qtype uint16) []aRecord {
 
 	sort.Slice(out, func(i, j int) bool {
 		if out[i].Type == out[j].Type {
-			return out[i].Name < out[j].Name
+			return recordKey(out[i]) < recordKey(out[j])
 		}
 		return out[i].Type < out[j].Type
 	})
 	return out
 }
 
-func (s *store) getRecord(name string) (aRecord, bool) {
-	recs := s.getRecords(name, dns.TypeANY)
+func (v *storeView) getRecord(name string) (aRecord, bool) {
+	recs := v.getRecords(name, dns.TypeANY)
 	if len(recs) == 0 {
 		return aRecord{}, false
 	}
@@ -166,19 +610,64 @@ func (s *store) getRecord(name string) (aRecord, bool) {
 	return recs[0], true
 }
 
-func (s *store) hasName(name string) bool {
+func (v *storeView) hasName(name string) bool {
 	name = normalizeName(name)
-
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, rec := range s.records {
-		if rec.Name == name {
-			return true
+	var now time.Time
+	for _, set := range v.records[shardFor(name)][name] {
+		for _, rec := range set {
+			if rec.liveAt(&now) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// liveAt reports whether rec is still served. Expired records are hidden as
+// soon as expires_at passes, before the sweeper removes them. *now is read
+// lazily so records without an expiry never cost a clock read.
+func (rec aRecord) liveAt(now *time.Time) bool {
+	if rec.ExpiresAt.IsZero() {
+		return true
+	}
+	if now.IsZero() {
+		*now = time.Now()
+	}
+	return now.Before(rec.ExpiresAt)
+}
+
+// nameExpiry returns the earliest expires_at among records at name, or the
+// zero time when none of them expire. Cached answers for name must not
+// outlive it.
+func (v *storeView) nameExpiry(name string) time.Time {
+	var first time.Time
+	for _, set := range v.records[shardFor(name)][name] {
+		for _, rec := range set {
+			if !rec.ExpiresAt.IsZero() && (first.IsZero() || rec.ExpiresAt.Before(first)) {
+				first = rec.ExpiresAt
+			}
+		}
+	}
+	return first
+}
+
+// expiredRecords returns the records whose expires_at is at or before now.
+func (v *storeView) expiredRecords(now time.Time) []aRecord {
+	var out []aRecord
+	for _, shard := range v.records {
+		for _, types := range shard {
+			for _, set := range types {
+				for _, rec := range set {
+					if !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt) {
+						out = append(out, rec)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
 func recordKey(rec aRecord) string {
 	val := ""
 	switch rec.Type {
@@ -194,51 +683,193 @@ func recordKey(rec aRecord) string {
 	return rec.Name + "|" + rec.Type + "|" + val
 }
 
-func (s *store) listRecords() []aRecord {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// counts returns the number of owner names and records in the snapshot.
+func (v *storeView) counts() (names, records int) {
+	for _, shard := range v.records {
+		names += len(shard)
+		for _, types := range shard {
+			for _, set := range types {
+				records += len(set)
+			}
+		}
+	}
+	return names, records
+}
 
-	out := make([]aRecord, 0, len(s.records))
-	for _, rec := range s.records {
-		out = append(out, rec)
+func (v *storeView) listRecords() []aRecord {
+	var now time.Time
+	out := make([]aRecord, 0)
+	for _, shard := range v.records {
+		for _, types := range shard {
+			for _, set := range types {
+				for _, rec := range set {
+					if rec.liveAt(&now) {
+						out = append(out, rec)
+					}
+				}
+			}
+		}
 	}
 
 	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out
 }
 
-func (s *store) upsertZone(z zoneConfig) bool {
-	z.Zone = normalizeName(z.Zone)
-	z.NS = normalizeNames(z.NS)
+// liveRecordsByZone groups the snapshot's live records by owning zone in
+// one pass, in no particular order. With zone set only that zone's records
+// are collected.
+func (v *storeView) liveRecordsByZone(zone string) map[string][]aRecord {
+	var now time.Time
+	out := make(map[string][]aRecord)
+	for _, shard := range v.records {
+		for _, types := range shard {
+			for _, set := range types {
+				for _, rec := range set {
+					if (zone == "" || rec.Zone == zone) && rec.liveAt(&now) {
+						out[rec.Zone] = append(out[rec.Zone], rec)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// sortedNames returns the snapshot's owner names in record key order. The
+// slice is shared and must not be modified.
+func (v *storeView) sortedNames() []string {
+	ix := v.names
+	if ix == nil {
+		return v.collectNames()
+	}
+	ix.once.Do(func() {
+		if ix.base != nil {
+			ix.names = v.mergeNames(ix.base.names, ix.touched)
+		} else {
+			ix.names = v.collectNames()
+		}
+		ix.base, ix.touched = nil, nil
+		ix.built.Store(true)
+	})
+	return ix.names
+}
 
-	prev, ok := s.zones[z.Zone]
-	if ok && prev.Serial > z.Serial {
-		return false
+// collectNames lists and sorts every owner name in the snapshot.
+func (v *storeView) collectNames() []string {
+	var out []string
+	for _, shard := range v.records {
+		for name := range shard {
+			out = append(out, name)
+		}
 	}
+	slices.SortFunc(out, compareOwnerKeys)
+	return out
+}
 
-	s.zones[z.Zone] = z
-	return true
+// mergeNames returns base with the touched names, both in key order,
+// merged in: a touched name is kept only if the snapshot still has it.
+func (v *storeView) mergeNames(base, touched []string) []string {
+	out := make([]string, 0, len(base)+len(touched))
+	i := 0
+	for _, name := range touched {
+		for ; i < len(base) && compareOwnerKeys(base[i], name) < 0; i++ {
+			out = append(out, base[i])
+		}
+		if i < len(base) && base[i] == name {
+			i++
+		}
+		if len(v.records[shardFor(name)][name]) > 0 {
+			out = append(out, name)
+		}
+	}
+	return append(out, base[i:]...)
 }
 
-func (s *store) getZone(zone string) (zoneConfig, bool) {
-	key := normalizeName(zone)
+// next returns the name index for the snapshot published after ix's, whose
+// write touched names.
+func (ix *nameIndex) next(names map[string]struct{}) *nameIndex {
+	out := &nameIndex{}
+	if ix == nil || !ix.built.Load() {
+		return out
+	}
+	out.base = ix
+	out.touched = make([]string, 0, len(names))
+	for name := range names {
+		out.touched = append(out.touched, name)
+	}
+	slices.SortFunc(out.touched, compareOwnerKeys)
+	return out
+}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// compareOwnerKeys orders owner names the way recordKey orders their
+// records: by the name followed by the '|' separator.
+func compareOwnerKeys(a, b string) int {
+	n := min(len(a), len(b))
+	if c := strings.Compare(a[:n], b[:n]); c != 0 {
+		return c
+	}
+	switch {
+	case len(a) == len(b):
+		return 0
+	case len(a) < len(b):
+		return cmp.Compare('|', b[n])
+	default:
+		return cmp.Compare(a[n], '|')
+	}
+}
 
-	z, ok := s.zones[key]
+func (v *storeView) getZone(zone string) (zoneConfig, bool) {
+	z, ok := v.zones[normalizeName(zone)]
 	return z, ok
 }
 
-func (s *store) listZones() []zoneConfig {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// zoneRecords returns every record owned by zone, expired ones included, in
+// name order.
+func (v *storeView) zoneRecords(zone string) []aRecord {
+	zone = normalizeName(zone)
+	var out []aRecord
+	for _, shard := range v.records {
+		for _, types := range shard {
+			for _, set := range types {
+				for _, rec := range set {
+					if rec.Zone == zone {
+						out = append(out, rec)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
 
-	out := make([]zoneConfig, 0, len(s.zones))
-	for _, z := range s.zones {
+// zoneConfigs returns the configs of zones in name order, skipping any that
+// are not configured.
+func (v *storeView) zoneConfigs(zones map[string]struct{}) []zoneConfig {
+	out := make([]zoneConfig, 0, len(zones))
+	for zone := range zones {
+		if z, ok := v.zones[zone]; ok {
+			out = append(out, z)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zone < out[j].Zone })
+	return out
+}
+
+// tombstone returns the deletion tombstone for zone, if any.
+func (v *storeView) tombstone(zone string) (zoneTombstone, bool) {
+	t, ok := v.tombstones[normalizeName(zone)]
+	return t, ok
+}
+
+func (v *storeView) listZones() []zoneConfig {
+	out := make([]zoneConfig, 0, len(v.zones))
+	for _, z := range v.zones {
 		out = append(out, z)
 	}
 
@@ -246,29 +877,62 @@ func (s *store) listZones() []zoneConfig {
 	return out
 }
 
-func (s *store) bestZone(name string) (zoneConfig, bool) {
-	q := normalizeName(name)
+func (v *storeView) bestZone(name string) (zoneConfig, bool) {
+	return v.zoneTree.longestMatch(normalizeName(name))
+}
+
+// with returns a copy of the tree where the node at labels (ordered as in
+// the zone name) carries z. Only nodes on the path are copied, so existing
+// snapshots keep their own tree untouched.
+func (n *zoneNode) with(labels []string, z *zoneConfig) *zoneNode {
+	out := &zoneNode{}
+	if n != nil {
+		out.zone = n.zone
+		out.children = n.children
+	}
+	if len(labels) == 0 {
+		out.zone = z
+		return out
+	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	last := labels[len(labels)-1]
+	var child *zoneNode
+	if n != nil {
+		child = n.children[last]
+	}
 
-	var (
-		best       zoneConfig
-		found      bool
-		bestLabels int
-	)
+	children := make(map[string]*zoneNode, len(out.children)+1)
+	for k, v := range out.children {
+		children[k] = v
+	}
+	children[last] = child.with(labels[:len(labels)-1], z)
+	out.children = children
+	return out
+}
 
-	for zone, cfg := range s.zones {
-		if !dns.IsSubDomain(zone, q) {
-			continue
+// longestMatch walks the query labels from the root and returns the deepest
+// zone enclosing name, so lookups scale with label count, not zone count.
+func (n *zoneNode) longestMatch(name string) (zoneConfig, bool) {
+	var best *zoneConfig
+	node := n
+	if node.zone != nil {
+		best = node.zone
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := node.children[labels[i]]
+		if !ok {
+			break
 		}
-		labels := dns.CountLabel(zone)
-		if !found || labels > bestLabels {
-			best = cfg
-			bestLabels = labels
-			found = true
+		node = child
+		if node.zone != nil {
+			best = node.zone
 		}
 	}
 
-	return best, found
+	if best == nil {
+		return zoneConfig{}, false
+	}
+	return *best, true
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestStoreSetRecordVersioning(t *testing.T) {
@@ -48,3 +52,254 @@ func TestStoreBestZoneLongestMatch(t *testing.T) {
 		t.Fatalf("unexpected best zone: %s", z.Zone)
 	}
 }
+
+func benchmarkStore(b *testing.B, size int) *store {
+	b.Helper()
+	s := newStore()
+	now := time.Now().UTC()
+	s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 30, Serial: 1, UpdatedAt: now})
+	for i := 0; i < size; i++ {
+		s.addRecord(aRecord{
+			Name:    fmt.Sprintf("host-%d.example.com", i),
+			Zone:    "example.com",
+			IP:      fmt.Sprintf("198.51.%d.%d", (i/250)%250, i%250+1),
+			TTL:     30,
+			Version: int64(i + 1),
+		})
+	}
+	return s
+}
+
+func BenchmarkStoreGetRecords(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("records=%d", size), func(b *testing.B) {
+			s := benchmarkStore(b, size)
+			name := fmt.Sprintf("host-%d.example.com.", size/2)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(s.getRecords(name, dns.TypeA)) != 1 {
+					b.Fatal("expected one record")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStoreHasName(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("records=%d", size), func(b *testing.B) {
+			s := benchmarkStore(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if s.hasName("missing.example.com.") {
+					b.Fatal("unexpected name")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStoreSetRecord(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("records=%d", size), func(b *testing.B) {
+			s := benchmarkStore(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.setRecord(aRecord{Name: "bench.example.com", Zone: "example.com", IP: "203.0.113.1", TTL: 30, Version: int64(size + i + 1)})
+			}
+		})
+	}
+}
+
+func BenchmarkStoreBestZone(b *testing.B) {
+	for _, size := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("zones=%d", size), func(b *testing.B) {
+			s := newStore()
+			now := time.Now().UTC()
+			for i := 0; i < size; i++ {
+				s.upsertZone(zoneConfig{Zone: fmt.Sprintf("zone-%d.example", i), NS: []string{"love.me.cloudroof.eu"}, SOATTL: 30, Serial: 1, UpdatedAt: now})
+			}
+			name := fmt.Sprintf("www.app.zone-%d.example.", size/2)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := s.bestZone(name); !ok {
+					b.Fatal("expected zone match")
+				}
+			}
+		})
+	}
+}
+
+func TestStoreIndexPrunesEmptyNames(t *testing.T) {
+	s := newStore()
+	s.addRecord(aRecord{Name: "pool.example.com", Zone: "example.com", IP: "192.0.2.1", TTL: 10, Version: 1})
+	s.addRecord(aRecord{Name: "pool.example.com", Zone: "example.com", IP: "192.0.2.2", TTL: 10, Version: 1})
+	s.addRecord(aRecord{Name: "pool.example.com", Type: "TXT", Zone: "example.com", Text: "hello", TTL: 10, Version: 1})
+
+	if got := len(s.getRecords("pool.example.com", dns.TypeA)); got != 2 {
+		t.Fatalf("expected 2 A records, got %d", got)
+	}
+	if got := len(s.getRecords("pool.example.com", dns.TypeANY)); got != 3 {
+		t.Fatalf("expected 3 records for ANY, got %d", got)
+	}
+
+	s.removeRecord(aRecord{Name: "pool.example.com", Zone: "example.com", IP: "192.0.2.1"}, 2)
+	s.deleteRecordByType("pool.example.com", "A", 2)
+	if !s.hasName("pool.example.com") {
+		t.Fatal("name should remain while TXT record exists")
+	}
+
+	s.deleteRecord("pool.example.com", 2)
+	if s.hasName("pool.example.com") {
+		t.Fatal("name should be gone once all RRsets are deleted")
+	}
+	if got := len(s.listRecords()); got != 0 {
+		t.Fatalf("expected empty store, got %d records", got)
+	}
+}
+
+func TestStoreSortedNamesFollowWrites(t *testing.T) {
+	s := newStore()
+	for _, name := range []string{"example.com", "www.example.com", "example.com.au", "b.example.com"} {
+		s.addRecord(aRecord{Name: name, Zone: "com", IP: "192.0.2.1", TTL: 10, Version: 1})
+	}
+	// Names sort as their record keys do, so "example.com.|A|..." comes
+	// after "example.com.au.|A|...".
+	want := []string{"b.example.com.", "example.com.au.", "example.com.", "www.example.com."}
+	if got := s.view().sortedNames(); !slices.Equal(got, want) {
+		t.Fatalf("unexpected name order: %v", got)
+	}
+
+	// The next snapshot derives its index from the built one.
+	s.update(func(tx *storeTxn) {
+		tx.addRecord(aRecord{Name: "c.example.com", Zone: "com", IP: "192.0.2.1", TTL: 10, Version: 2})
+		tx.deleteRecordByType("www.example.com.", "A", 2)
+	})
+	want = []string{"b.example.com.", "c.example.com.", "example.com.au.", "example.com."}
+	view := s.view()
+	if got := view.sortedNames(); !slices.Equal(got, want) || !slices.Equal(got, view.collectNames()) {
+		t.Fatalf("derived index out of date: %v", got)
+	}
+}
+
+func TestStoreViewIsStableAcrossWrites(t *testing.T) {
+	s := newStore()
+	now := time.Now().UTC()
+	s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 30, Serial: 1, UpdatedAt: now})
+	s.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "192.0.2.1", TTL: 10, Version: 1})
+
+	view := s.view()
+
+	s.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "192.0.2.2", TTL: 10, Version: 2})
+	s.addRecord(aRecord{Name: "new.example.com", Zone: "example.com", IP: "192.0.2.3", TTL: 10, Version: 2})
+	s.upsertZone(zoneConfig{Zone: "svc.example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 30, Serial: 1, UpdatedAt: now})
+
+	got, ok := view.getRecord("app.example.com")
+	if !ok || got.IP != "192.0.2.1" {
+		t.Fatalf("old view should keep original record, got %#v", got)
+	}
+	if view.hasName("new.example.com") {
+		t.Fatal("old view should not see records added later")
+	}
+	if z, _ := view.bestZone("api.svc.example.com"); z.Zone != "example.com." {
+		t.Fatalf("old view should not see zones added later, got %s", z.Zone)
+	}
+
+	got, _ = s.getRecord("app.example.com")
+	if got.IP != "192.0.2.2" {
+		t.Fatalf("store should serve latest record, got %s", got.IP)
+	}
+	if z, _ := s.bestZone("api.svc.example.com"); z.Zone != "svc.example.com." {
+		t.Fatalf("store should serve latest zone, got %s", z.Zone)
+	}
+}
+
+func TestStoreConcurrentReadsAndWrites(t *testing.T) {
+	s := newStore()
+	s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 30, Serial: 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			s.setRecord(aRecord{Name: fmt.Sprintf("host-%d.example.com", i%20), Zone: "example.com", IP: "192.0.2.1", TTL: 10, Version: int64(i + 1)})
+			s.deleteRecord(fmt.Sprintf("host-%d.example.com", (i+10)%20), int64(i+1))
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		view := s.view()
+		for i := 0; i < 20; i++ {
+			name := fmt.Sprintf("host-%d.example.com", i)
+			if view.hasName(name) != (len(view.getRecords(name, dns.TypeANY)) > 0) {
+				t.Fatalf("inconsistent view for %s", name)
+			}
+		}
+	}
+}
+
+func BenchmarkStoreGetRecordsParallelWithWriter(b *testing.B) {
+	s := benchmarkStore(b, 10000)
+	stop := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.setRecord(aRecord{Name: "bench.example.com", Zone: "example.com", IP: "203.0.113.1", TTL: 30, Version: int64(10001 + i)})
+		}
+	}()
+	defer close(stop)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			view := s.view()
+			view.getRecords("host-5000.example.com.", dns.TypeA)
+			view.hasName("host-5000.example.com.")
+			view.bestZone("host-5000.example.com.")
+		}
+	})
+}
+
+func TestStoreDeleteZoneTombstone(t *testing.T) {
+	s := newStore()
+	now := time.Now().UTC()
+	s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 10, UpdatedAt: now})
+	s.setRecord(aRecord{Name: "app.example.com", Type: "A", Zone: "example.com", IP: "198.51.100.1", TTL: 20, Version: 1})
+	s.setRecord(aRecord{Name: "other.example.org", Type: "A", Zone: "example.org", IP: "198.51.100.2", TTL: 20, Version: 1})
+
+	if _, ok := s.deleteZone("example.com", 9, now, true); ok {
+		t.Fatal("expected delete below the zone serial to be ignored")
+	}
+	removed, ok := s.deleteZone("example.com", 11, now, true)
+	if !ok || len(removed) != 1 || removed[0].Name != "app.example.com." {
+		t.Fatalf("expected cascade to remove app.example.com, got %v ok=%t", removed, ok)
+	}
+	if _, ok := s.getZone("example.com"); ok {
+		t.Fatal("expected zone to be gone")
+	}
+	if _, ok := s.bestZone("app.example.com"); ok {
+		t.Fatal("expected no zone match after delete")
+	}
+	if s.hasName("app.example.com") || !s.hasName("other.example.org") {
+		t.Fatal("expected only the zone's records to be removed")
+	}
+
+	if s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 11, UpdatedAt: now}) {
+		t.Fatal("expected upsert at the tombstone serial to be ignored")
+	}
+	if !s.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 12, UpdatedAt: now}) {
+		t.Fatal("expected upsert above the tombstone serial to recreate the zone")
+	}
+	if _, ok := s.view().tombstone("example.com"); ok {
+		t.Fatal("expected recreation to clear the tombstone")
+	}
+}
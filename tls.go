@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	certReloadInterval = 5 * time.Second
+	paddingBlockSize   = 468
+)
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE are required for TLS listeners")
+	}
+
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: certReloadInterval}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// getCertificate serves the current certificate and, at most once per
+// interval, reloads it when either file's modification time has changed.
+// A failed reload keeps serving the previous certificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= c.interval {
+		c.lastCheck = time.Now()
+		if c.changedLocked() {
+			if err := c.reloadLocked(); err != nil {
+				log.Printf("tls certificate reload failed: %v", err)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reloadLocked()
+}
+
+func (c *certReloader) changedLocked() bool {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(c.certMod) || !keyInfo.ModTime().Equal(c.keyMod)
+}
+
+func (c *certReloader) reloadLocked() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("stat tls cert: %w", err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("stat tls key: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair: %w", err)
+	}
+
+	c.cert = &cert
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	c.lastCheck = time.Now()
+	return nil
+}
+
+// tlsConfig returns a server TLS config backed by the shared certificate
+// reloader, advertising the given ALPN protocols.
+func (s *server) tlsConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.certs.getCertificate,
+		NextProtos:     nextProtos,
+	}
+}
+
+// padResponse appends an OPT record with an RFC 7830 padding option so the
+// message length is a multiple of the RFC 8467 block size. It only pads when
+// the query carried a padding option, and works on the packed message so
+// cached responses can be padded without repacking.
+func padResponse(req *dns.Msg, wire []byte) []byte {
+	opt := req.IsEdns0()
+	if opt == nil || len(wire) < 12 {
+		return wire
+	}
+	wantsPadding := false
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0PADDING {
+			wantsPadding = true
+			break
+		}
+	}
+	if !wantsPadding {
+		return wire
+	}
+
+	// OPT RR: root owner (1), type (2), class (2), ttl (4), rdlength (2),
+	// then the padding option header (4) and its zero-filled payload.
+	const optOverhead = 1 + 2 + 2 + 4 + 2 + 4
+	padLen := 0
+	if rem := (len(wire) + optOverhead) % paddingBlockSize; rem != 0 {
+		padLen = paddingBlockSize - rem
+	}
+	if len(wire)+optOverhead+padLen > dns.MaxMsgSize {
+		return wire
+	}
+
+	out := make([]byte, len(wire), len(wire)+optOverhead+padLen)
+	copy(out, wire)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])+1)
+
+	out = append(out, 0)
+	out = binary.BigEndian.AppendUint16(out, dns.TypeOPT)
+	out = binary.BigEndian.AppendUint16(out, dns.DefaultMsgSize)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(4+padLen))
+	out = binary.BigEndian.AppendUint16(out, dns.EDNS0PADDING)
+	out = binary.BigEndian.AppendUint16(out, uint16(padLen))
+	out = append(out, make([]byte, padLen)...)
+	return out
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoTListenerAnswersAndPads(t *testing.T) {
+	s := newTLSTestServer(t)
+	s.cfg.DNSTLSListen = []string{"127.0.0.1:0"}
+	s.cfg.DoTIdleTimeout = time.Second
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"love.me.cloudroof.eu"}, SOATTL: 60, Serial: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.runDNS(ctx, "tcp-tls") }()
+	defer func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("runDNS returned error: %v", err)
+		}
+	}()
+
+	stats := waitForListeners(t, s, 1)
+	c := &dns.Client{Net: "tcp-tls", Timeout: time.Second, TLSConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"dot"}}}
+
+	req := new(dns.Msg)
+	req.SetQuestion("app.example.com.", dns.TypeA)
+	resp, _, err := c.Exchange(req, stats[0].Addr)
+	if err != nil {
+		t.Fatalf("DoT exchange: %v", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("expected one answer over DoT, got %d", len(resp.Answer))
+	}
+	if resp.IsEdns0() != nil {
+		t.Fatal("expected no OPT record when padding was not requested")
+	}
+
+	padded := new(dns.Msg)
+	padded.SetQuestion("app.example.com.", dns.TypeA)
+	padded.SetEdns0(dns.DefaultMsgSize, false)
+	opt := padded.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 16)})
+	resp, _, err = c.Exchange(padded, stats[0].Addr)
+	if err != nil {
+		t.Fatalf("padded DoT exchange: %v", err)
+	}
+	wire, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("repack response: %v", err)
+	}
+	if len(wire)%paddingBlockSize != 0 {
+		t.Fatalf("expected response padded to %d-byte blocks, got %d bytes", paddingBlockSize, len(wire))
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("expected one answer in padded response, got %d", len(resp.Answer))
+	}
+}
+
+func TestCertReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "old.example.com")
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	c.interval = 0
+
+	first, _ := c.getCertificate(nil)
+	writeTestCert(t, dir, "new.example.com")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	second, _ := c.getCertificate(nil)
+	if first == second {
+		t.Fatal("expected certificate to be reloaded after files changed")
+	}
+	if second.Leaf == nil || second.Leaf.Subject.CommonName != "new.example.com" {
+		t.Fatalf("unexpected reloaded certificate: %+v", second.Leaf)
+	}
+
+	if err := os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+		t.Fatalf("write broken cert: %v", err)
+	}
+	evenLater := later.Add(time.Minute)
+	_ = os.Chtimes(certFile, evenLater, evenLater)
+	third, _ := c.getCertificate(nil)
+	if third != second {
+		t.Fatal("expected previous certificate to be kept when reload fails")
+	}
+}
+
+func TestNewCertReloaderRequiresFiles(t *testing.T) {
+	if _, err := newCertReloader("", ""); err == nil {
+		t.Fatal("expected error without certificate files")
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+const (
+	scopeRead      = "read"
+	scopeWrite     = "write"
+	scopeZoneAdmin = "zone_admin"
+
+	// tokenSecretPrefix marks secrets issued by this server, so they are
+	// easy to spot in logs and secret scanners.
+	tokenSecretPrefix = "dns_"
+)
+
+var tokenNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type tokenContextKey struct{}
+
+// withToken records the named token that authenticated the request.
+func withToken(r *http.Request, t *apiToken) *http.Request {
+	r = withActor(r, "token:"+t.Name)
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, t))
+}
+
+// requestAPIToken returns the named token r was authenticated with, or nil
+// for the admin API_TOKEN and an open API, which are unrestricted.
+func requestAPIToken(r *http.Request) *apiToken {
+	t, _ := r.Context().Value(tokenContextKey{}).(*apiToken)
+	return t
+}
+
+// restoreTokens loads the named tokens saved in the database.
+func (s *server) restoreTokens() error {
+	tokens, err := s.persist.loadAPITokens()
+	if err != nil {
+		return err
+	}
+	m := make(map[string]*apiToken, len(tokens))
+	for i := range tokens {
+		t := &tokens[i]
+		if t.prefixes, err = parseAllowedIPs(t.AllowedIPs); err != nil {
+			return fmt.Errorf("api token %s: %w", t.Name, err)
+		}
+		m[t.hash] = t
+	}
+	s.tokens.Store(&m)
+	return nil
+}
+
+// lookupToken finds the named token whose secret is secret.
+func (s *server) lookupToken(secret string) *apiToken {
+	m := s.tokens.Load()
+	if m == nil || secret == "" {
+		return nil
+	}
+	return (*m)[hashTokenSecret(secret)]
+}
+
+// hasTokens reports whether any named token exists.
+func (s *server) hasTokens() bool {
+	m := s.tokens.Load()
+	return m != nil && len(*m) > 0
+}
+
+// listTokens returns the named tokens ordered by name.
+func (s *server) listTokens() []*apiToken {
+	m := s.tokens.Load()
+	if m == nil {
+		return nil
+	}
+	out := make([]*apiToken, 0, len(*m))
+	for _, t := range *m {
+		out = append(out, t)
+	}
+	slices.SortFunc(out, func(a, b *apiToken) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// updateTokens publishes a copy of the token map changed by fn. fn runs
+// under tokensMu and may fail, leaving the map untouched.
+func (s *server) updateTokens(fn func(m map[string]*apiToken) error) error {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+
+	next := make(map[string]*apiToken)
+	if cur := s.tokens.Load(); cur != nil {
+		for k, v := range *cur {
+			next[k] = v
+		}
+	}
+	if err := fn(next); err != nil {
+		return err
+	}
+	s.tokens.Store(&next)
+	return nil
+}
+
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTokenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token secret: %w", err)
+	}
+	return tokenSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// has reports whether t grants scope. zone_admin includes write, and both
+// include read.
+func (t *apiToken) has(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == scopeZoneAdmin || (s == scopeWrite && scope == scopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// restricted reports whether t is limited to some zones or names.
+func (t *apiToken) restricted() bool {
+	return t != nil && (len(t.Zones) > 0 || len(t.Names) > 0)
+}
+
+// allowsZone reports whether zone is one of t's zones or below one. A nil
+// token allows everything.
+func (t *apiToken) allowsZone(zone string) bool {
+	if t == nil || len(t.Zones) == 0 {
+		return true
+	}
+	for _, z := range t.Zones {
+		if dns.IsSubDomain(z, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsName reports whether name lies within t's zones and matches one of
+// its name patterns.
+func (t *apiToken) allowsName(name string) bool {
+	if t == nil {
+		return true
+	}
+	if !t.allowsZone(name) {
+		return false
+	}
+	if len(t.Names) == 0 {
+		return true
+	}
+	for _, p := range t.Names {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *apiToken) allowsRecord(rec aRecord) bool {
+	return t.allowsName(rec.Name) && t.allowsZone(rec.Zone)
+}
+
+func (t *apiToken) allowsAddr(addr netip.Addr) bool {
+	if len(t.prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *apiToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// tokenRouteAccess is what a route asks of a named token: a scope, or the
+// admin API_TOKEN (adminOnly). Routes that report on every zone also need a
+// token without zone or name limits (unrestrictedOnly).
+func tokenRouteAccess(method, pattern string) (scope string, adminOnly, unrestrictedOnly bool) {
+	switch {
+	case strings.HasPrefix(pattern, "/v1/tokens"):
+		return "", true, false
+	case pattern == "/v1/drain" && method != http.MethodGet:
+		return "", true, false
+	case pattern == "/v1/listeners", pattern == "/v1/zones/export", strings.HasPrefix(pattern, "/v1/stats"):
+		return scopeRead, false, true
+	case pattern == "/v1/zones/{zone}/import", pattern == "/v1/zones/{zone}" && method != http.MethodGet:
+		return scopeZoneAdmin, false, false
+	case method == http.MethodGet:
+		return scopeRead, false, false
+	}
+	return scopeWrite, false, false
+}
+
+// authorizeToken checks t against the route r matched and the zone or name
+// in its path. Checks that depend on the body are left to the handlers.
+func authorizeToken(t *apiToken, r *http.Request) error {
+	pattern := chi.RouteContext(r.Context()).RoutePattern()
+	scope, adminOnly, unrestrictedOnly := tokenRouteAccess(r.Method, pattern)
+	if adminOnly {
+		return errors.New("requires the admin API token")
+	}
+	if !t.has(scope) {
+		return fmt.Errorf("token lacks %s scope", scope)
+	}
+	if unrestrictedOnly && t.restricted() {
+		return errors.New("token is limited to some zones or names")
+	}
+	if zone := chi.URLParam(r, "zone"); zone != "" {
+		zone = normalizeName(zone)
+		if !t.allowsZone(zone) {
+			return fmt.Errorf("zone %s not allowed for token", zone)
+		}
+		// Zone contents are not limited by name patterns.
+		if len(t.Names) > 0 && (pattern != "/v1/zones/{zone}" || r.Method != http.MethodGet) {
+			return errors.New("token is limited to some names")
+		}
+	}
+	if name := chi.URLParam(r, "name"); name != "" {
+		name = normalizeName(name)
+		if !t.allowsName(name) {
+			return fmt.Errorf("name %s not allowed for token", name)
+		}
+	}
+	return nil
+}
+
+// recordAllowed writes 403 and returns false when r's token may not change
+// name in zone.
+func recordAllowed(w http.ResponseWriter, r *http.Request, name, zone string) bool {
+	t := requestAPIToken(r)
+	if t.allowsName(name) && t.allowsZone(zone) {
+		return true
+	}
+	writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("token may not change %s in zone %s", name, zone)})
+	return false
+}
+
+// parseAllowedIPs parses a token's allowed addresses and CIDR prefixes,
+// failing on the first invalid entry.
+func parseAllowedIPs(in []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(in))
+	for _, v := range in {
+		p, err := parseAddrOrPrefix(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed ip %q", v)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// buildToken validates req into a token without its secret.
+func buildToken(req createTokenRequest, now time.Time) (apiToken, error) {
+	t := apiToken{Name: strings.TrimSpace(req.Name), CreatedAt: now}
+	if !tokenNamePattern.MatchString(t.Name) {
+		return apiToken{}, errors.New("name must be 1-64 letters, digits, dots, dashes or underscores")
+	}
+
+	for _, scope := range req.Scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope != scopeRead && scope != scopeWrite && scope != scopeZoneAdmin {
+			return apiToken{}, fmt.Errorf("scope %q must be read, write or zone_admin", scope)
+		}
+		if !slices.Contains(t.Scopes, scope) {
+			t.Scopes = append(t.Scopes, scope)
+		}
+	}
+	if len(t.Scopes) == 0 {
+		return apiToken{}, errors.New("at least one scope is required")
+	}
+	slices.Sort(t.Scopes)
+
+	t.Zones = normalizeNames(req.Zones)
+	for _, p := range req.Names {
+		p = normalizeName(p)
+		if p == "." {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return apiToken{}, fmt.Errorf("invalid name pattern %q", p)
+		}
+		t.Names = append(t.Names, p)
+	}
+
+	prefixes, err := parseAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return apiToken{}, err
+	}
+	t.prefixes = prefixes
+	for _, p := range prefixes {
+		t.AllowedIPs = append(t.AllowedIPs, p.String())
+	}
+
+	switch {
+	case req.ExpiresAt != nil && req.ExpiresIn > 0:
+		return apiToken{}, errors.New("expires_at and expires_in are mutually exclusive")
+	case req.ExpiresAt != nil:
+		t.ExpiresAt = req.ExpiresAt.UTC()
+	case req.ExpiresIn > 0:
+		t.ExpiresAt = now.Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+	if !t.ExpiresAt.IsZero() && !t.ExpiresAt.After(now) {
+		return apiToken{}, errors.New("expires_at must be in the future")
+	}
+	return t, nil
+}
+
+// handleTokenCreate issues a named token. The secret is returned once and
+// only its hash is stored. Without API_TOKEN no token is issued, since the
+// first one would close the open API to everyone else.
+func (s *server) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.APIToken == "" {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "API_TOKEN must be set to create named tokens"})
+		return
+	}
+	var req createTokenRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	t, err := buildToken(req, time.Now().UTC())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	secret, err := newTokenSecret()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	t.hash = hashTokenSecret(secret)
+	t.Prefix = secret[:len(tokenSecretPrefix)+6]
+
+	errExists := errors.New("token already exists")
+	err = s.updateTokens(func(m map[string]*apiToken) error {
+		for _, other := range m {
+			if other.Name == t.Name {
+				return errExists
+			}
+		}
+		if err := s.persist.createAPIToken(t); err != nil {
+			return err
+		}
+		m[t.hash] = &t
+		return nil
+	})
+	switch {
+	case errors.Is(err, errExists):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		s.persistFailed("api_token", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		apiToken
+		Token string `json:"token"`
+	}{t, secret})
+}
+
+func (s *server) handleTokens(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	type tokenStatus struct {
+		*apiToken
+		Expired bool `json:"expired"`
+	}
+	out := []tokenStatus{}
+	for _, t := range s.listTokens() {
+		out = append(out, tokenStatus{t, t.expired(now)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tokens": out})
+}
+
+// handleTokenRevoke deletes a named token; requests using it fail at once.
+func (s *server) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	found := false
+	err := s.updateTokens(func(m map[string]*apiToken) error {
+		for hash, t := range m {
+			if t.Name != name {
+				continue
+			}
+			if err := s.persist.deleteAPIToken(name); err != nil {
+				return err
+			}
+			delete(m, hash)
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		s.persistFailed("api_token", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"revoked": name})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestToken(t *testing.T, h http.Handler, body string) string {
+	t.Helper()
+	rr := apiRequest(t, h, http.MethodPost, "/v1/tokens", body, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create token: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || !strings.HasPrefix(resp.Token, tokenSecretPrefix) {
+		t.Fatalf("expected a secret in %s", rr.Body.String())
+	}
+	return resp.Token
+}
+
+func asToken(secret string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + secret}
+}
+
+func TestScopedTokenLimits(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	apiRequest(t, h, http.MethodPut, "/v1/zones/example.net", `{"ns":["ns1.example.net."],"propagate":false}`, nil)
+	apiRequest(t, h, http.MethodPut, "/v1/records/www.example.net", `{"ip":"198.51.100.1","zone":"example.net","propagate":false}`, nil)
+	apiRequest(t, h, http.MethodPut, "/v1/records/example.com", `{"type":"MX","target":"mx.example.com","priority":10,"propagate":false}`, nil)
+
+	acme := createTestToken(t, h, `{"name":"acme","scopes":["write"],"zones":["example.com"],"names":["_acme-challenge.*"]}`)
+
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/_acme-challenge.www.example.com", `{"type":"TXT","text":"proof","propagate":false}`, asToken(acme)); rr.Code != http.StatusOK {
+		t.Fatalf("challenge write: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, c := range []struct{ method, path, body string }{
+		{http.MethodDelete, "/v1/records/example.com?type=MX&propagate=false", ""},
+		{http.MethodPut, "/v1/records/_acme-challenge.example.net", `{"type":"TXT","text":"x","propagate":false}`},
+		{http.MethodPut, "/v1/records/_acme-challenge.www.example.com", `{"type":"TXT","text":"x","zone":"example.net","propagate":false}`},
+		{http.MethodPost, "/v1/changes", `{"changes":[{"op":"set","name":"_acme-challenge.a.example.com","type":"TXT","text":"ok"},{"op":"delete","name":"example.com","type":"MX"}],"propagate":false}`},
+		{http.MethodPut, "/v1/zones/example.com", `{"ns":["ns1.example.com."],"propagate":false}`},
+		{http.MethodGet, "/v1/stats", ""},
+		{http.MethodGet, "/v1/tokens", ""},
+	} {
+		if rr := apiRequest(t, h, c.method, c.path, c.body, asToken(acme)); rr.Code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected 403, got %d: %s", c.method, c.path, rr.Code, rr.Body.String())
+		}
+	}
+	if recs := s.data.view().zoneRecords("example.com."); len(recs) != 2 {
+		t.Fatalf("expected rejected batch to write nothing, got %+v", recs)
+	}
+
+	// Listings only show what the token may see.
+	rr := apiRequest(t, h, http.MethodGet, "/v1/records", "", asToken(acme))
+	var list struct {
+		Records []aRecord `json:"records"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Records) != 1 || list.Records[0].Name != "_acme-challenge.www.example.com." {
+		t.Fatalf("expected only the challenge record, got %s", rr.Body.String())
+	}
+
+	// History needs a filter the token may see.
+	historyEntriesAs(t, h, "?name=_acme-challenge.www.example.com", acme)
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/history", "", asToken(acme)); rr.Code != http.StatusForbidden {
+		t.Fatalf("unfiltered history: expected 403, got %d", rr.Code)
+	}
+
+	reader := createTestToken(t, h, `{"name":"reader","scopes":["read"],"zones":["example.net"]}`)
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records/www.example.net", "", asToken(reader)); rr.Code != http.StatusOK {
+		t.Fatalf("read: expected 200, got %d", rr.Code)
+	}
+	// A name under the token's zone whose records another zone owns.
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/legacy.example.net", `{"ip":"198.51.100.3","zone":"example.com","propagate":false}`, nil); rr.Code != http.StatusOK {
+		t.Fatalf("admin write: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records/legacy.example.net", "", asToken(reader)); rr.Code != http.StatusNotFound {
+		t.Fatalf("read of another zone's record: expected 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/zones/example.com/export", "", asToken(reader)); rr.Code != http.StatusForbidden {
+		t.Fatalf("other zone export: expected 403, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/www.example.net", `{"ip":"198.51.100.2","propagate":false}`, asToken(reader)); rr.Code != http.StatusForbidden {
+		t.Fatalf("write with read scope: expected 403, got %d", rr.Code)
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/v1/zones", "", asToken(reader))
+	var zones struct {
+		Zones []zoneConfig `json:"zones"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &zones); err != nil || len(zones.Zones) != 1 || zones.Zones[0].Zone != "example.net." {
+		t.Fatalf("expected only example.net, got %s", rr.Body.String())
+	}
+
+	admin := createTestToken(t, h, `{"name":"admin","scopes":["zone_admin"]}`)
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/zones/example.org", `{"ns":["ns1.example.org."],"propagate":false}`, asToken(admin)); rr.Code != http.StatusOK {
+		t.Fatalf("zone admin: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/drain", `{}`, asToken(admin)); rr.Code != http.StatusForbidden {
+		t.Fatalf("drain with named token: expected 403, got %d", rr.Code)
+	}
+}
+
+func historyEntriesAs(t *testing.T, h http.Handler, query, secret string) []changeLogEntry {
+	t.Helper()
+	rr := apiRequest(t, h, http.MethodGet, "/v1/history"+query, "", asToken(secret))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("history: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Entries []changeLogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode history: %v", err)
+	}
+	return resp.Entries
+}
+
+func TestTokenExpiryAllowlistAndRevoke(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+
+	expired := createTestToken(t, h, `{"name":"short","scopes":["read"],"expires_in":1}`)
+	if err := s.persist.db.Model(&apiTokenModel{}).Where("name = ?", "short").Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+	if err := s.restoreTokens(); err != nil {
+		t.Fatalf("restoreTokens: %v", err)
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/zones", "", asToken(expired)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token: expected 401, got %d", rr.Code)
+	}
+
+	// httptest requests come from 192.0.2.1.
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/zones", "", asToken(createTestToken(t, h, `{"name":"office","scopes":["read"],"allowed_ips":["203.0.113.0/24"]}`))); rr.Code != http.StatusForbidden {
+		t.Fatalf("outside allowlist: expected 403, got %d", rr.Code)
+	}
+	lab := createTestToken(t, h, `{"name":"lab","scopes":["write"],"allowed_ips":["192.0.2.1"]}`)
+	s.data.subscribe(s.recordChangeLog)
+	if rr := apiRequest(t, h, http.MethodPut, "/v1/records/app.example.com", `{"ip":"192.0.2.10","propagate":false}`, asToken(lab)); rr.Code != http.StatusOK {
+		t.Fatalf("inside allowlist: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if entries := historyEntries(t, h, "?name=app.example.com"); len(entries) != 1 || entries[0].Actor != "token:lab" {
+		t.Fatalf("expected change attributed to the token, got %+v", entries)
+	}
+
+	if rr := apiRequest(t, h, http.MethodPost, "/v1/tokens", `{"name":"lab","scopes":["read"]}`, nil); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate name: expected 409, got %d", rr.Code)
+	}
+	for _, body := range []string{
+		`{"name":"x","scopes":[]}`,
+		`{"name":"x","scopes":["root"]}`,
+		`{"name":"bad name","scopes":["read"]}`,
+		`{"name":"x","scopes":["read"],"allowed_ips":["nope"]}`,
+		`{"name":"x","scopes":["read"],"names":["[a"]}`,
+		`{"name":"x","scopes":["read"],"expires_at":"2000-01-01T00:00:00Z"}`,
+	} {
+		if rr := apiRequest(t, h, http.MethodPost, "/v1/tokens", body, nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	// Tokens survive a restart and only their hash is stored.
+	s.tokens.Store(nil)
+	if err := s.restoreTokens(); err != nil {
+		t.Fatalf("restoreTokens: %v", err)
+	}
+	var row apiTokenModel
+	if err := s.persist.db.First(&row, "name = ?", "lab").Error; err != nil {
+		t.Fatalf("load row: %v", err)
+	}
+	if row.SecretHash == lab || row.SecretHash != hashTokenSecret(lab) || !strings.HasPrefix(lab, row.Prefix) {
+		t.Fatalf("unexpected stored token %+v", row)
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records/app.example.com", "", asToken(lab)); rr.Code != http.StatusOK {
+		t.Fatalf("restored token: expected 200, got %d", rr.Code)
+	}
+
+	rr := apiRequest(t, h, http.MethodGet, "/v1/tokens", "", nil)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), lab) || !strings.Contains(rr.Body.String(), `"expired":true`) {
+		t.Fatalf("unexpected token list: %s", rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/tokens/lab", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/zones", "", asToken(lab)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked token: expected 401, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/tokens/lab", "", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("revoke twice: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestTokensEnforcedWithoutAPIToken(t *testing.T) {
+	s := newTestServer(t)
+	h := s.newRouter()
+	s.cfg.APIToken = ""
+
+	// With the API open, no token can be created to close it.
+	rr := apiRequest(t, h, http.MethodPost, "/v1/tokens", `{"name":"reader","scopes":["read"]}`, nil)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("create without API_TOKEN: expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	s.cfg.APIToken = "token"
+	reader := createTestToken(t, h, `{"name":"reader","scopes":["read"]}`)
+	s.cfg.APIToken = ""
+
+	// API_TOKEN unset and a named token exists: requests need a token.
+	noToken := map[string]string{"Authorization": ""}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records", "", noToken); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("no token: expected 401, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodGet, "/v1/records", "", asToken(reader)); rr.Code != http.StatusOK {
+		t.Fatalf("named token: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/v1/tokens/reader", "", asToken(reader)); rr.Code != http.StatusForbidden {
+		t.Fatalf("revoke with named token: expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// transferEnvelopeSize bounds the wire size of one AXFR message; the 64 KiB
+// TCP limit leaves room for the header and question.
+const transferEnvelopeSize = 32 * 1024
+
+// transferAllowed reports whether the client may transfer zones. An empty
+// AXFR_ALLOW denies everyone.
+func (s *server) transferAllowed(remote net.Addr) bool {
+	addr := addrPort(remote).Addr().Unmap()
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range s.cfg.AXFRAllow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveTransfer answers AXFR, and IXFR with a full transfer, over a stream
+// transport. Member zones are sent SOA first and last, with the apex NS and
+// every live record the zone owns in between.
+func (s *server) serveTransfer(w dns.ResponseWriter, req *dns.Msg, l *dnsListener) error {
+	queryTime := time.Now()
+	zone := normalizeName(req.Question[0].Name)
+
+	var records []dns.RR
+	rcode := dns.RcodeSuccess
+	switch {
+	case !s.transferAllowed(w.RemoteAddr()) || s.drainRefused(req) != nil:
+		rcode = dns.RcodeRefused
+	case s.catalog != nil && zone == s.catalog.name:
+		records = s.catalog.records()
+	default:
+		view := s.data.view()
+		z, ok := view.getZone(zone)
+		if !ok {
+			rcode = dns.RcodeNotAuth
+			break
+		}
+		records = zoneRecords(z, view.liveRecordsByZone(z.Zone)[z.Zone])
+	}
+
+	if rcode != dns.RcodeSuccess {
+		resp := new(dns.Msg)
+		resp.SetRcode(req, rcode)
+		if err := w.WriteMsg(resp); err != nil {
+			return err
+		}
+		s.observeQuery(l.network, req, rcode, 0, queryTime)
+		return nil
+	}
+
+	records = append(records, records[0])
+	ch := make(chan *dns.Envelope)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- new(dns.Transfer).Out(w, req, ch)
+	}()
+	for start := 0; start < len(records); {
+		end, size := start, 0
+		for end < len(records) && (end == start || size+dns.Len(records[end]) <= transferEnvelopeSize) {
+			size += dns.Len(records[end])
+			end++
+		}
+		// Out stops reading once a write fails, for example when the client
+		// hangs up, so a send must not outlive it.
+		select {
+		case ch <- &dns.Envelope{RR: records[start:end]}:
+		case err := <-errCh:
+			if err == nil {
+				err = errors.New("zone transfer ended early")
+			}
+			return err
+		}
+		start = end
+	}
+	close(ch)
+	if err := <-errCh; err != nil {
+		return err
+	}
+	s.observeQuery(l.network, req, rcode, len(records), queryTime)
+	return nil
+}
+
+// zoneRecords returns the zone contents for a transfer or export without
+// the closing SOA: SOA, apex NS, then recs, the live records the zone owns,
+// in canonical order.
+func zoneRecords(z zoneConfig, recs []aRecord) []dns.RR {
+	out := []dns.RR{soaForZone(z)}
+	for _, ns := range z.NS {
+		out = append(out, &dns.NS{
+			Hdr: dns.RR_Header{Name: z.Zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: z.SOATTL},
+			Ns:  ns,
+		})
+	}
+	for _, rec := range recs {
+		if rec.Zone != z.Zone || !dns.IsSubDomain(z.Zone, rec.Name) {
+			continue
+		}
+		if rr := recordRR(rec); rr != nil {
+			out = append(out, rr)
+		}
+	}
+	body := out[1+len(z.NS):]
+	sort.SliceStable(body, func(i, j int) bool { return canonicalRRLess(body[i], body[j]) })
+	return out
+}
+
+// canonicalRRLess orders records by owner name in RFC 4034 section 6.1
+// canonical order, then by type, then by presentation-form data.
+func canonicalRRLess(a, b dns.RR) bool {
+	ha, hb := a.Header(), b.Header()
+	if c := canonicalNameCompare(ha.Name, hb.Name); c != 0 {
+		return c < 0
+	}
+	if ha.Rrtype != hb.Rrtype {
+		return ha.Rrtype < hb.Rrtype
+	}
+	return a.String() < b.String()
+}
+
+// canonicalNameCompare compares names label by label from the root, so a
+// parent sorts before its children.
+func canonicalNameCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// recordRR converts a stored record to its resource record, or nil when the
+// stored value no longer parses.
+func recordRR(rec aRecord) dns.RR {
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: normalizeName(rec.Name), Rrtype: rrtype, Class: dns.ClassINET, Ttl: rec.TTL}
+	}
+	switch rec.Type {
+	case "A":
+		ip, err := netip.ParseAddr(rec.IP)
+		if err != nil || !ip.Is4() {
+			return nil
+		}
+		return &dns.A{Hdr: hdr(dns.TypeA), A: ip.AsSlice()}
+	case "AAAA":
+		ip, err := netip.ParseAddr(rec.IP)
+		if err != nil || !ip.Is6() || ip.Is4In6() {
+			return nil
+		}
+		return &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: ip.AsSlice()}
+	case "TXT":
+		return &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: chunkTXT(rec.Text)}
+	case "CNAME":
+		return &dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: normalizeName(rec.Target)}
+	case "MX":
+		return &dns.MX{Hdr: hdr(dns.TypeMX), Mx: normalizeName(rec.Target), Preference: rec.Priority}
+	}
+	return nil
+}
+
+// handleZoneChange keeps the catalog in step with the zone map and tells
+// NOTIFY_TARGETS about zones whose serial moved. It runs as a store watcher,
+// so NOTIFYs are sent from a separate goroutine.
+func (s *server) handleZoneChange(change storeChange) {
+	if len(change.Zones) == 0 {
+		return
+	}
+	zones := change.Zones
+	if s.catalog != nil && s.catalog.stale(change.After, change.Zones) {
+		if s.refreshCatalog(change.After, time.Now().UTC()) {
+			zones = append(append([]string(nil), zones...), s.catalog.name)
+		}
+	}
+	if len(s.cfg.NotifyTargets) > 0 {
+		go s.notifyZones(zones)
+	}
+}
+
+// notifyZones sends a best-effort NOTIFY for each zone to every target.
+func (s *server) notifyZones(zones []string) {
+	client := &dns.Client{Net: "udp", Timeout: 2 * time.Second}
+	for _, zone := range zones {
+		msg := new(dns.Msg)
+		msg.SetNotify(zone)
+		msg.Authoritative = true
+		for _, target := range s.cfg.NotifyTargets {
+			resp, _, err := client.Exchange(msg, target)
+			if err == nil && resp.Rcode != dns.RcodeSuccess {
+				err = fmt.Errorf("rcode %s", dns.RcodeToString[resp.Rcode])
+			}
+			if err != nil {
+				log.Printf("notify failed zone=%s target=%s: %v", zone, target, err)
+			}
+		}
+	}
+}
+
+// parseAXFRAllow parses AXFR_ALLOW entries, which are CIDR prefixes or bare
+// addresses. Invalid entries are reported and skipped.
+func parseAXFRAllow(entries []string) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseAddrOrPrefix(entry)
+		if err != nil {
+			log.Printf("warning: AXFR_ALLOW entry %q is not an address or prefix; skipping", entry)
+			continue
+		}
+		out = append(out, prefix)
+	}
+	return out
+}
+
+// parseAddrOrPrefix parses a CIDR prefix, masked, or a bare address as the
+// prefix holding only it.
+func parseAddrOrPrefix(entry string) (netip.Prefix, error) {
+	if !strings.Contains(entry, "/") {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
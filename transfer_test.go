@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func startTransferListener(t *testing.T, s *server) string {
+	t.Helper()
+	s.cfg.DNSTCPListen = []string{"127.0.0.1:0"}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.runDNS(ctx, "tcp") }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Errorf("runDNS returned error: %v", err)
+		}
+	})
+	return waitForListeners(t, s, 1)[0].Addr
+}
+
+func transferZone(t *testing.T, addr, zone string) ([]dns.RR, error) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetAxfr(zone)
+	tr := &dns.Transfer{DialTimeout: time.Second, ReadTimeout: time.Second}
+	ch, err := tr.In(req, addr)
+	if err != nil {
+		return nil, err
+	}
+	var out []dns.RR
+	for env := range ch {
+		if env.Error != nil {
+			return out, env.Error
+		}
+		out = append(out, env.RR...)
+	}
+	return out, nil
+}
+
+func TestAXFRMemberAndCatalogZones(t *testing.T) {
+	s := newCatalogTestServer(t)
+	s.cfg.AXFRAllow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 3, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "app.example.com", Type: "A", Zone: "example.com", IP: "198.51.100.10", TTL: 25, Version: 1, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "mail.example.com", Type: "MX", Zone: "example.com", Target: "mx.example.com", Priority: 10, TTL: 25, Version: 1, UpdatedAt: now})
+	addr := startTransferListener(t, s)
+
+	rrs, err := transferZone(t, addr, "example.com.")
+	if err != nil {
+		t.Fatalf("AXFR example.com: %v", err)
+	}
+	if len(rrs) != 5 {
+		t.Fatalf("expected SOA, NS, A, MX, SOA; got %v", rrs)
+	}
+	first, ok1 := rrs[0].(*dns.SOA)
+	last, ok2 := rrs[len(rrs)-1].(*dns.SOA)
+	if !ok1 || !ok2 || first.Serial != 3 || last.Serial != 3 {
+		t.Fatalf("expected zone framed by serial 3 SOAs, got %v", rrs)
+	}
+
+	rrs, err = transferZone(t, addr, "catalog.invalid.")
+	if err != nil {
+		t.Fatalf("AXFR catalog: %v", err)
+	}
+	var members []string
+	for _, rr := range rrs {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			members = append(members, ptr.Ptr)
+		}
+	}
+	if len(members) != 1 || members[0] != "example.com." {
+		t.Fatalf("expected catalog member example.com., got %v", members)
+	}
+
+	if _, err := transferZone(t, addr, "unknown.test."); err == nil {
+		t.Fatal("expected AXFR of an unknown zone to fail")
+	}
+}
+
+func TestAXFRRefusedOutsideAllowList(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.AXFRAllow = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 3, UpdatedAt: time.Now().UTC()})
+	addr := startTransferListener(t, s)
+
+	if _, err := transferZone(t, addr, "example.com."); err == nil {
+		t.Fatal("expected AXFR from a client outside AXFR_ALLOW to be refused")
+	}
+
+	req := new(dns.Msg)
+	req.SetAxfr("example.com.")
+	res, err := s.answerDNS(req)
+	if err != nil {
+		t.Fatalf("answerDNS: %v", err)
+	}
+	if res.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED for AXFR outside a stream transport, got %d", res.Rcode)
+	}
+}
+
+func TestParseAXFRAllow(t *testing.T) {
+	got := parseAXFRAllow([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::1", "nonsense", "10.1.2.3/33"})
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i, p := range got {
+		if p.String() != want[i] {
+			t.Fatalf("entry %d: expected %s, got %s", i, want[i], p)
+		}
+	}
+}
+
+// closedConnWriter is a stream client that hangs up after the first message
+// of a transfer.
+type closedConnWriter struct {
+	writes int
+}
+
+func (w *closedConnWriter) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *closedConnWriter) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func (w *closedConnWriter) WriteMsg(*dns.Msg) error {
+	if w.writes++; w.writes > 1 {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (w *closedConnWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *closedConnWriter) Close() error                { return nil }
+func (w *closedConnWriter) TsigStatus() error           { return nil }
+func (w *closedConnWriter) TsigTimersOnly(bool)         {}
+func (w *closedConnWriter) Hijack()                     {}
+
+func TestAXFRClientClosesMidTransfer(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.AXFRAllow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.com", NS: []string{"ns1.example.com"}, SOATTL: 60, Serial: 3, UpdatedAt: now})
+	s.data.update(func(tx *storeTxn) {
+		for i := range 4000 {
+			tx.addRecord(aRecord{Name: fmt.Sprintf("host%d.example.com.", i), Type: "TXT", Zone: "example.com.", Text: strings.Repeat("x", 40), TTL: 25, Version: 1, UpdatedAt: now})
+		}
+	})
+
+	req := new(dns.Msg)
+	req.SetAxfr("example.com.")
+	done := make(chan error, 1)
+	go func() { done <- s.serveTransfer(&closedConnWriter{}, req, &dnsListener{network: "tcp"}) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected the write error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer did not return after the client went away")
+	}
+}
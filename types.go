@@ -1,18 +1,40 @@
 package main
 
 import (
+	"container/list"
+	"crypto/tls"
 	"net/http"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	"gorm.io/gorm"
 )
 
 type config struct {
 	NodeID         string
 	HTTPListen     string
-	DNSUDPListen   string
-	DNSTCPListen   string
+	DNSUDPListen   []string
+	DNSTCPListen   []string
+	DNSUDPWorkers  int
+	DNSTLSListen   []string
+	TLSCertFile    string
+	TLSKeyFile     string
+	DoTIdleTimeout time.Duration
+	DoTKeepAlive   time.Duration
+	DoTMaxQueries  int
+	DNSQUICListen  []string
+	DoQIdleTimeout time.Duration
+	HTTP3Listen    []string
+	DoHCORSOrigins []string
+	DNSTapOutput   string
+	DNSTapBuffer   uint32
+	Metrics        bool
+	MetricsListen  string
+	Stats          bool
 	DBPath         string
 	MigrationsDir  string
 	DebugLog       bool
@@ -22,15 +44,31 @@ type config struct {
 	DefaultTTL     uint32
 	DefaultZone    string
 	DefaultNS      []string
+	DNSCache       bool
+	DNSCacheSize   uint32
+	SerialScheme   string
+	ExpirySweep    time.Duration
+	DrainGrace     time.Duration
+	DrainHook      string
+	CatalogZone    string
+	AXFRAllow      []netip.Prefix
+	NotifyTargets  []string
 	SyncHTTPClient *http.Client
 }
 
 type zoneConfig struct {
-	Zone      string    `json:"zone"`
-	NS        []string  `json:"ns"`
-	SOATTL    uint32    `json:"soa_ttl"`
-	Serial    uint32    `json:"serial"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Zone         string    `json:"zone"`
+	NS           []string  `json:"ns"`
+	SOATTL       uint32    `json:"soa_ttl"`
+	Serial       uint32    `json:"serial"`
+	Refresh      uint32    `json:"refresh"`
+	Retry        uint32    `json:"retry"`
+	Expire       uint32    `json:"expire"`
+	NegativeTTL  uint32    `json:"negative_ttl"`
+	Mbox         string    `json:"mbox,omitempty"`
+	MName        string    `json:"mname,omitempty"`
+	SerialScheme string    `json:"serial_scheme"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type aRecord struct {
@@ -46,6 +84,7 @@ type aRecord struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	Version   int64     `json:"version"`
 	Source    string    `json:"source"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
 }
 
 type syncEvent struct {
@@ -58,54 +97,297 @@ type syncEvent struct {
 	Version    int64       `json:"version"`
 	EventTime  time.Time   `json:"event_time"`
 	ZoneConfig *zoneConfig `json:"zone_config,omitempty"`
+	Cascade    bool        `json:"cascade,omitempty"`
+	// Actor is the identity that made the change on the origin node, for
+	// the change log.
+	Actor string `json:"actor,omitempty"`
+	// Changes and Zones carry a batch: its record changes and the
+	// origin's resulting zone configs.
+	Changes []recordChange `json:"changes,omitempty"`
+	Zones   []zoneConfig   `json:"zones,omitempty"`
+}
+
+// recordChange is one validated record operation of a batch: set, add and
+// remove carry Record; delete carries Name and an optional Type.
+type recordChange struct {
+	Op      string   `json:"op"`
+	Record  *aRecord `json:"record,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Zone    string   `json:"zone,omitempty"`
+	Version int64    `json:"version"`
+}
+
+// changeRequest is one operation of a POST /v1/changes batch.
+type changeRequest struct {
+	Op        string     `json:"op"`
+	Name      string     `json:"name"`
+	Type      string     `json:"type,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Target    string     `json:"target,omitempty"`
+	Priority  uint16     `json:"priority,omitempty"`
+	TTL       uint32     `json:"ttl,omitempty"`
+	Zone      string     `json:"zone,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LeaseTTL  uint32     `json:"lease_ttl,omitempty"`
+	// IfMatch and IfZoneMatch guard the change like the If-Match and
+	// If-Zone-Match headers of the single-record endpoints.
+	IfMatch     string `json:"if_match,omitempty"`
+	IfZoneMatch string `json:"if_zone_match,omitempty"`
+}
+
+// rollbackRequest restores a zone, or a name's RRsets (one type when Type
+// is set), to how they were at At.
+type rollbackRequest struct {
+	Zone      string    `json:"zone,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	At        time.Time `json:"at"`
+	DryRun    bool      `json:"dry_run,omitempty"`
+	Propagate *bool     `json:"propagate,omitempty"`
+}
+
+type changesRequest struct {
+	Changes   []changeRequest `json:"changes"`
+	Propagate *bool           `json:"propagate,omitempty"`
 }
 
 type upsertRecordRequest struct {
-	IP        string `json:"ip"`
-	Type      string `json:"type,omitempty"`
-	Text      string `json:"text,omitempty"`
-	Target    string `json:"target,omitempty"`
-	Priority  uint16 `json:"priority,omitempty"`
-	TTL       uint32 `json:"ttl"`
-	Zone      string `json:"zone"`
-	Propagate *bool  `json:"propagate,omitempty"`
+	IP        string     `json:"ip"`
+	Type      string     `json:"type,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Target    string     `json:"target,omitempty"`
+	Priority  uint16     `json:"priority,omitempty"`
+	TTL       uint32     `json:"ttl"`
+	Zone      string     `json:"zone"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LeaseTTL  uint32     `json:"lease_ttl,omitempty"`
+	Propagate *bool      `json:"propagate,omitempty"`
+}
+
+// importIssue reports a resource record an import skipped and why.
+type importIssue struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
 }
 
 type upsertZoneRequest struct {
-	NS        []string `json:"ns"`
-	SOATTL    uint32   `json:"soa_ttl"`
-	Propagate *bool    `json:"propagate,omitempty"`
+	NS           []string `json:"ns"`
+	SOATTL       uint32   `json:"soa_ttl"`
+	Refresh      uint32   `json:"refresh,omitempty"`
+	Retry        uint32   `json:"retry,omitempty"`
+	Expire       uint32   `json:"expire,omitempty"`
+	NegativeTTL  uint32   `json:"negative_ttl,omitempty"`
+	Mbox         string   `json:"mbox,omitempty"`
+	MName        string   `json:"mname,omitempty"`
+	SerialScheme string   `json:"serial_scheme,omitempty"`
+	Propagate    *bool    `json:"propagate,omitempty"`
 }
 
 type store struct {
-	mu      sync.RWMutex
-	records map[string]aRecord
-	zones   map[string]zoneConfig
+	mu       sync.Mutex
+	snap     atomic.Pointer[storeView]
+	watchers []func(storeChange)
+}
+
+type storeChange struct {
+	Names []string
+	Zones []string
+	// Author attributes the transaction; Before and After are the snapshots
+	// on either side of it.
+	Author changeAuthor
+	Before *storeView
+	After  *storeView
+}
+
+// changeAuthor attributes a store transaction for the change log: the actor
+// (API token identity; empty for the node itself), the client address, the
+// node the change originated on, and the operation that caused it.
+type changeAuthor struct {
+	Actor    string
+	ClientIP string
+	Origin   string
+	Reason   string
+}
+
+// changeLogEntry is one change to an RRset or a zone config, with its state
+// before and after. Kind is "rrset" or "zone"; Op is create, update or
+// delete.
+type changeLogEntry struct {
+	ID         uint64      `json:"id"`
+	At         time.Time   `json:"at"`
+	Actor      string      `json:"actor"`
+	ClientIP   string      `json:"client_ip,omitempty"`
+	Origin     string      `json:"origin_node"`
+	Reason     string      `json:"reason,omitempty"`
+	Kind       string      `json:"kind"`
+	Op         string      `json:"op"`
+	Zone       string      `json:"zone,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Before     []aRecord   `json:"before,omitempty"`
+	After      []aRecord   `json:"after,omitempty"`
+	ZoneBefore *zoneConfig `json:"zone_before,omitempty"`
+	ZoneAfter  *zoneConfig `json:"zone_after,omitempty"`
+}
+
+// changeLogModel is a change log row. Times are unix nanoseconds so range
+// queries compare numerically; before and after are JSON.
+type changeLogModel struct {
+	ID       uint64 `gorm:"primaryKey;autoIncrement"`
+	At       int64  `gorm:"not null"`
+	Actor    string `gorm:"size:255"`
+	ClientIP string `gorm:"size:45"`
+	Origin   string `gorm:"size:255"`
+	Reason   string `gorm:"size:32"`
+	Kind     string `gorm:"size:8"`
+	Op       string `gorm:"size:8"`
+	Zone     string `gorm:"size:255"`
+	Name     string `gorm:"size:255"`
+	Type     string `gorm:"size:10"`
+	Before   string `gorm:"type:text"`
+	After    string `gorm:"type:text"`
+}
+
+// changeLogQuery selects change log entries. Since and Until are inclusive;
+// BeforeID pages backwards from a previous result.
+type changeLogQuery struct {
+	Zone      string
+	Name      string
+	Type      string
+	Kind      string
+	Actor     string
+	Since     time.Time
+	Until     time.Time
+	BeforeID  uint64
+	Limit     int
+	Ascending bool
+}
+
+func (changeLogModel) TableName() string {
+	return "change_log"
+}
+
+const storeShards = 256
+
+type storeView struct {
+	records    [storeShards]map[string]map[string]rrset
+	zones      map[string]zoneConfig
+	zoneTree   *zoneNode
+	tombstones map[string]zoneTombstone
+	names      *nameIndex
+}
+
+// nameIndex holds a snapshot's owner names in record key order. It is built
+// the first time a listing walks the snapshot. A snapshot published right
+// after one whose index was built derives its own from that index and the
+// names the write touched instead of sorting every name again.
+type nameIndex struct {
+	once    sync.Once
+	built   atomic.Bool
+	names   []string
+	base    *nameIndex
+	touched []string
+}
+
+// zoneTombstone remembers the serial a zone was deleted at, so replicated
+// upserts that predate the delete cannot bring it back.
+type zoneTombstone struct {
+	Zone      string    `json:"zone"`
+	Serial    uint32    `json:"serial"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type storeTxn struct {
+	view            *storeView
+	ownedShards     [storeShards]bool
+	ownedZones      bool
+	ownedTombstones bool
+	changed         bool
+	names           map[string]struct{}
+	zones           map[string]struct{}
+}
+
+type rrset map[string]aRecord
+
+type zoneNode struct {
+	children map[string]*zoneNode
+	zone     *zoneConfig
 }
 
 type recordModel struct {
-	ID        uint64    `gorm:"primaryKey;autoIncrement"`
-	Name      string    `gorm:"size:255;index:idx_records_name_type,priority:1"`
-	Type      string    `gorm:"size:10;index:idx_records_name_type,priority:2"`
-	IP        string    `gorm:"size:45"`
-	Text      string    `gorm:"type:text"`
-	Target    string    `gorm:"size:255"`
-	Priority  uint16    `gorm:"not null;default:0"`
-	TTL       uint32    `gorm:"not null"`
-	Zone      string    `gorm:"size:255;not null"`
-	UpdatedAt time.Time `gorm:"not null"`
-	Version   int64     `gorm:"not null;index"`
-	Source    string    `gorm:"size:128;not null"`
+	ID        uint64     `gorm:"primaryKey;autoIncrement"`
+	Name      string     `gorm:"size:255;index:idx_records_name_type,priority:1"`
+	Type      string     `gorm:"size:10;index:idx_records_name_type,priority:2"`
+	IP        string     `gorm:"size:45"`
+	Text      string     `gorm:"type:text"`
+	Target    string     `gorm:"size:255"`
+	Priority  uint16     `gorm:"not null;default:0"`
+	TTL       uint32     `gorm:"not null"`
+	Zone      string     `gorm:"size:255;not null"`
+	UpdatedAt time.Time  `gorm:"not null"`
+	Version   int64      `gorm:"not null;index"`
+	Source    string     `gorm:"size:128;not null"`
+	ExpiresAt *time.Time `gorm:"index"`
 }
 
 type zoneModel struct {
-	Zone      string    `gorm:"primaryKey;size:255"`
-	NSJSON    string    `gorm:"type:text;not null"`
-	SOATTL    uint32    `gorm:"not null"`
-	Serial    uint32    `gorm:"not null;index"`
+	Zone         string    `gorm:"primaryKey;size:255"`
+	NSJSON       string    `gorm:"type:text;not null"`
+	SOATTL       uint32    `gorm:"not null"`
+	Serial       uint32    `gorm:"not null;index"`
+	Refresh      uint32    `gorm:"not null;default:0"`
+	Retry        uint32    `gorm:"not null;default:0"`
+	Expire       uint32    `gorm:"not null;default:0"`
+	NegativeTTL  uint32    `gorm:"not null;default:0"`
+	Mbox         string    `gorm:"size:255;not null;default:''"`
+	MName        string    `gorm:"column:mname;size:255;not null;default:''"`
+	SerialScheme string    `gorm:"size:16;not null;default:'unix'"`
+	UpdatedAt    time.Time `gorm:"not null"`
+}
+
+// nodeStateModel holds node-local settings that must survive a restart but
+// are never replicated, such as drain mode.
+type nodeStateModel struct {
+	Key       string    `gorm:"primaryKey;size:64"`
+	Value     string    `gorm:"type:text;not null"`
 	UpdatedAt time.Time `gorm:"not null"`
 }
 
+type zoneTombstoneModel struct {
+	Zone      string    `gorm:"primaryKey;size:255"`
+	Serial    uint32    `gorm:"not null"`
+	DeletedAt time.Time `gorm:"not null"`
+}
+
+// apiTokenModel stores a named API token. Only the SHA-256 of the secret is
+// kept; lists are JSON arrays.
+type apiTokenModel struct {
+	Name           string `gorm:"primaryKey;size:64"`
+	SecretHash     string `gorm:"size:64;not null;uniqueIndex"`
+	Prefix         string `gorm:"size:16;not null"`
+	ScopesJSON     string `gorm:"type:text;not null"`
+	ZonesJSON      string `gorm:"type:text;not null"`
+	NamesJSON      string `gorm:"type:text;not null"`
+	AllowedIPsJSON string `gorm:"column:allowed_ips_json;type:text;not null"`
+	ExpiresAt      *time.Time
+	CreatedAt      time.Time `gorm:"not null"`
+}
+
+func (apiTokenModel) TableName() string {
+	return "api_tokens"
+}
+
+func (zoneTombstoneModel) TableName() string {
+	return "zone_tombstones"
+}
+
+func (nodeStateModel) TableName() string {
+	return "node_state"
+}
+
 func (recordModel) TableName() string {
 	return "records"
 }
@@ -118,9 +400,294 @@ type persistence struct {
 	db *gorm.DB
 }
 
+// writeQueue runs database writes on one goroutine in the order they were
+// queued, so store watchers can hand writes off without holding the store
+// lock during I/O.
+type writeQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []func()
+	busy    bool
+}
+
 type server struct {
 	cfg     config
 	data    *store
 	persist *persistence
+	cache   *responseCache
+	certs   *certReloader
+	tap     *dnstapLogger
+	metrics *metrics
+	stats   *queryStats
+	catalog *catalogZone
 	start   time.Time
+	drain   atomic.Pointer[drainState]
+	drainMu sync.Mutex
+	writes  *writeQueue
+
+	// tokens maps secret hashes to named API tokens; writers hold tokensMu
+	// and publish a new map.
+	tokens   atomic.Pointer[map[string]*apiToken]
+	tokensMu sync.Mutex
+
+	listenersMu sync.Mutex
+	listeners   []*dnsListener
+}
+
+// apiToken is a named API token. Scopes grant access by route; Zones and
+// Names, when set, limit it to records in those zones and names matching
+// those patterns. The secret itself is only known to the client.
+type apiToken struct {
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	Zones      []string  `json:"zones,omitempty"`
+	Names      []string  `json:"names,omitempty"`
+	AllowedIPs []string  `json:"allowed_ips,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	hash     string
+	prefixes []netip.Prefix
+}
+
+type createTokenRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Zones      []string   `json:"zones,omitempty"`
+	Names      []string   `json:"names,omitempty"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn  uint32     `json:"expires_in,omitempty"`
+}
+
+// drainState is the persisted drain mode of this node. While draining,
+// /healthz reports not ready; DNS keeps answering until GraceUntil and
+// refuses queries afterwards.
+type drainState struct {
+	Draining   bool      `json:"draining"`
+	Reason     string    `json:"reason,omitempty"`
+	Since      time.Time `json:"since"`
+	GraceUntil time.Time `json:"grace_until"`
+}
+
+type drainRequest struct {
+	Reason   string  `json:"reason,omitempty"`
+	GraceSec *uint32 `json:"grace_sec,omitempty"`
+}
+
+type catalogZone struct {
+	name   string
+	scheme string
+	mu     sync.Mutex
+	snap   atomic.Pointer[catalogSnapshot]
+}
+
+// catalogSnapshot is one published version of the catalog zone. Members is
+// sorted; members indexes it for membership checks.
+type catalogSnapshot struct {
+	Serial  uint32   `json:"serial"`
+	Members []string `json:"members"`
+	members map[string]struct{}
+}
+
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+type dnsListener struct {
+	network   string
+	addr      string
+	worker    int
+	pad       bool
+	queries   atomic.Uint64
+	responses atomic.Uint64
+	errors    atomic.Uint64
+}
+
+type dnsListenerStats struct {
+	Network   string `json:"network"`
+	Addr      string `json:"addr"`
+	Worker    int    `json:"worker"`
+	Queries   uint64 `json:"queries"`
+	Responses uint64 `json:"responses"`
+	Errors    uint64 `json:"errors"`
+}
+
+type queryStats struct {
+	data    *store
+	events  chan statsEvent
+	dropped atomic.Uint64
+
+	mu      sync.Mutex
+	buckets [statsBuckets]*statsBucket
+}
+
+type statsEvent struct {
+	name    string
+	qtype   uint16
+	rcode   int
+	answers int
+	at      time.Time
+}
+
+// statsBucket holds one minute of bounded-memory query sketches.
+type statsBucket struct {
+	minute     int64
+	queries    uint64
+	names      *topK
+	nxdomain   *topK
+	records    *topK
+	recordHits countMin
+	zones      map[string]map[string]uint64
+}
+
+type topK struct {
+	capacity int
+	items    map[string]*topKItem
+	heap     topKHeap
+}
+
+type topKItem struct {
+	key   string
+	count uint64
+	err   uint64
+	index int
+}
+
+type topKHeap []*topKItem
+
+type countMin struct {
+	rows [statsCMSDepth][statsCMSWidth]uint32
+}
+
+type statsReport struct {
+	NodeID     string                       `json:"node_id"`
+	Window     string                       `json:"window"`
+	From       time.Time                    `json:"from"`
+	To         time.Time                    `json:"to"`
+	Queries    uint64                       `json:"queries"`
+	Dropped    uint64                       `json:"dropped"`
+	TopNames   []statsCount                 `json:"top_names"`
+	NXDomain   []statsCount                 `json:"nxdomain"`
+	TopRecords []statsRecordCount           `json:"top_records"`
+	Zones      map[string]map[string]uint64 `json:"zones"`
+}
+
+type statsCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+type statsRecordCount struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"`
+}
+
+type metrics struct {
+	registry      *prometheus.Registry
+	dnsQueries    *prometheus.CounterVec
+	dnsDuration   *prometheus.HistogramVec
+	syncEvents    *prometheus.CounterVec
+	persistErrors *prometheus.CounterVec
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+}
+
+type dnstapLogger struct {
+	output   string
+	identity []byte
+	events   chan dnstapEvent
+	stop     chan struct{}
+	done     chan struct{}
+
+	closeOnce sync.Once
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	errors    atomic.Uint64
+}
+
+type dnstapEvent struct {
+	network      string
+	client       netip.AddrPort
+	server       netip.AddrPort
+	query        *dns.Msg
+	queryTime    time.Time
+	response     []byte
+	responseTime time.Time
+}
+
+type dnstapStats struct {
+	Output  string `json:"output"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+	Errors  uint64 `json:"errors"`
+}
+
+type dnsResult struct {
+	Wire    []byte
+	Rcode   int
+	Answers int
+	Cached  bool
+}
+
+// dnsJSONResponse is the Google/Cloudflare JSON DNS API shape served by
+// /resolve.
+type dnsJSONResponse struct {
+	Status    int               `json:"Status"`
+	TC        bool              `json:"TC"`
+	RD        bool              `json:"RD"`
+	RA        bool              `json:"RA"`
+	AD        bool              `json:"AD"`
+	CD        bool              `json:"CD"`
+	Question  []dnsJSONQuestion `json:"Question"`
+	Answer    []dnsJSONRR       `json:"Answer,omitempty"`
+	Authority []dnsJSONRR       `json:"Authority,omitempty"`
+}
+
+type dnsJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dnsJSONRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type responseCacheKey struct {
+	Name   string
+	Qtype  uint16
+	Qclass uint16
+	DO     bool
+}
+
+type responseCacheEntry struct {
+	key      responseCacheKey
+	variants [][]byte
+	rcode    int
+	answers  int
+	expires  time.Time
+	elem     *list.Element
+}
+
+type responseCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[responseCacheKey]*responseCacheEntry
+	byName  map[string]map[responseCacheKey]struct{}
+	lru     *list.List
 }
@@ -71,3 +71,12 @@ func validToken(r *http.Request, expected string) bool {
 	header := strings.TrimSpace(r.Header.Get("X-API-Token"))
 	return header != "" && header == expected
 }
+
+// requestToken returns the token r presents, from the bearer header or
+// X-API-Token.
+func requestToken(r *http.Request) string {
+	if bearer := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); bearer != "" {
+		return bearer
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Token"))
+}
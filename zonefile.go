@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+// maxZoneFileBytes bounds an imported master file.
+const maxZoneFileBytes = 16 << 20
+
+const (
+	importModeMerge   = "merge"
+	importModeReplace = "replace"
+)
+
+// zoneFile is a parsed master file: the apex SOA and NS, which configure the
+// zone, and the records the store can hold.
+type zoneFile struct {
+	SOA     *dns.SOA
+	NS      []string
+	Records []aRecord
+	Skipped []importIssue
+}
+
+// parseZoneFile reads an RFC 1035 master file for zone. Relative names are
+// relative to zone unless $ORIGIN changes it, and records without a TTL use
+// $TTL or defaultTTL. $INCLUDE is refused. Records the store cannot hold are
+// reported in Skipped rather than failing the parse.
+func parseZoneFile(zone string, r io.Reader, defaultTTL uint32) (zoneFile, error) {
+	zp := dns.NewZoneParser(r, zone, "")
+	zp.SetDefaultTTL(defaultTTL)
+
+	var out zoneFile
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		out.add(zone, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return zoneFile{}, err
+	}
+	return out, nil
+}
+
+// add files rr under the apex SOA, the apex NS, the records, or Skipped.
+func (f *zoneFile) add(zone string, rr dns.RR) {
+	hdr := rr.Header()
+	name := normalizeName(hdr.Name)
+	switch {
+	case !dns.IsSubDomain(zone, name):
+		f.skip(rr, "owner is outside the zone")
+		return
+	case hdr.Class != dns.ClassINET:
+		f.skip(rr, "only class IN is supported")
+		return
+	case strings.HasPrefix(name, "*."):
+		f.skip(rr, "wildcard owners are not supported")
+		return
+	}
+
+	switch v := rr.(type) {
+	case *dns.SOA:
+		switch {
+		case name != zone:
+			f.skip(rr, "SOA is only allowed at the zone apex")
+		case f.SOA != nil:
+			f.skip(rr, "duplicate SOA")
+		default:
+			f.SOA = v
+		}
+	case *dns.NS:
+		if name != zone {
+			f.skip(rr, "delegations are not supported")
+			return
+		}
+		f.NS = append(f.NS, normalizeName(v.Ns))
+	default:
+		rec, reason := recordFromRR(rr)
+		if reason != "" {
+			f.skip(rr, reason)
+			return
+		}
+		f.Records = append(f.Records, rec)
+	}
+}
+
+func (f *zoneFile) skip(rr dns.RR, reason string) {
+	f.Skipped = append(f.Skipped, importIssue{
+		Name:   normalizeName(rr.Header().Name),
+		Type:   dns.TypeToString[rr.Header().Rrtype],
+		Reason: reason,
+	})
+}
+
+// recordFromRR converts a resource record to a stored record, or returns why
+// it cannot be stored. It is the inverse of recordRR.
+func recordFromRR(rr dns.RR) (aRecord, string) {
+	rec := aRecord{Name: normalizeName(rr.Header().Name), TTL: rr.Header().Ttl}
+	switch v := rr.(type) {
+	case *dns.A:
+		rec.Type, rec.IP = "A", v.A.String()
+	case *dns.AAAA:
+		rec.Type, rec.IP = "AAAA", v.AAAA.String()
+	case *dns.TXT:
+		rec.Type, rec.Text = "TXT", strings.Join(v.Txt, "")
+	case *dns.CNAME:
+		rec.Type, rec.Target = "CNAME", v.Target
+	case *dns.MX:
+		if v.Preference == 0 {
+			return rec, "MX preference 0 is not supported"
+		}
+		rec.Type, rec.Target, rec.Priority = "MX", v.Mx, v.Preference
+	default:
+		return rec, "record type is not supported"
+	}
+	return rec, ""
+}
+
+// handleZoneImport loads records into zone from an RFC 1035 master file or,
+// with format=cloudflare or format=route53, a provider's JSON export.
+// mode=merge (the default) adds the records to the zone; mode=replace also
+// removes the zone's records the import does not contain. A master file's
+// apex SOA and NS update the zone. Skipped records are reported, and
+// dry_run=true returns the preview without writing anything.
+func (s *server) handleZoneImport(w http.ResponseWriter, r *http.Request) {
+	zone := normalizeName(chi.URLParam(r, "zone"))
+	if zone == "." {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing zone name"})
+		return
+	}
+	if s.catalog.owns(zone) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "zone is inside the catalog zone"})
+		return
+	}
+	q := r.URL.Query()
+	mode := strings.ToLower(strings.TrimSpace(q.Get("mode")))
+	if mode == "" {
+		mode = importModeMerge
+	}
+	if mode != importModeMerge && mode != importModeReplace {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "mode must be merge or replace"})
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format == "" {
+		format = importFormatZone
+	}
+	dryRun := jsonQueryFlag(q.Get("dry_run"))
+
+	parsed, err := parseImport(format, zone, http.MaxBytesReader(w, r.Body, maxZoneFileBytes), s.cfg.DefaultTTL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "parse " + format + " import: " + err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	z, skipped, err := s.importZoneConfig(zone, parsed, now)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	skipped = append(skipped, parsed.Skipped...)
+
+	version := now.UnixNano()
+	records := make([]aRecord, 0, len(parsed.Records))
+	for _, rec := range parsed.Records {
+		rec.Zone = zone
+		norm, err := s.normalizeRecordInput(rec)
+		if err != nil {
+			skipped = append(skipped, importIssue{Name: rec.Name, Type: rec.Type, Reason: err.Error()})
+			continue
+		}
+		norm.Version = version
+		norm.UpdatedAt = now
+		norm.Source = s.cfg.NodeID
+		records = append(records, norm)
+	}
+	if skipped == nil {
+		skipped = []importIssue{}
+	}
+
+	if dryRun {
+		remove := []aRecord{}
+		if mode == importModeReplace {
+			remove = importRemovals(s.data.view(), zone, records)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"zone":    zone,
+			"mode":    mode,
+			"format":  format,
+			"dry_run": true,
+			"serial":  z.Serial,
+			"records": records,
+			"remove":  remove,
+			"skipped": skipped,
+		})
+		return
+	}
+
+	var added, removed []aRecord
+	changed := false
+	replace := mode == importModeReplace
+	if !s.data.updateIf(s.requestAuthor(r, "import"), zoneGuards(r, zone), func(tx *storeTxn) {
+		// A write that landed since the config was built may have moved the
+		// serial; stay ahead of it so the import's config still applies.
+		if cur, ok := tx.view.zones[zone]; ok && !serialGreater(z.Serial, cur.Serial) {
+			z.Serial = nextSerial(z.SerialScheme, cur.Serial, now)
+		}
+		changed = tx.upsertZone(z)
+		added, removed = tx.importRecords(zone, records, replace)
+	}) {
+		writePreconditionFailed(w, precondition{Zone: zone}.etag(s.data.view()))
+		return
+	}
+	var persisted *zoneConfig
+	if changed {
+		persisted = &z
+	}
+	if err := s.persist.importRecords(persisted, added, removed); err != nil {
+		s.persistFailed("import_records", err)
+	}
+
+	setETag(w, zoneETag(z))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"zone":     zone,
+		"mode":     mode,
+		"format":   format,
+		"serial":   z.Serial,
+		"imported": len(added),
+		"removed":  len(removed),
+		"skipped":  skipped,
+	})
+
+	if !strings.EqualFold(q.Get("propagate"), "false") {
+		go s.propagateImport(requestActor(r), z, added, removed, version, now)
+	}
+}
+
+// importRemovals lists the records a replace import of records into zone
+// would remove.
+func importRemovals(view *storeView, zone string, records []aRecord) []aRecord {
+	keep := make(map[string]struct{}, len(records))
+	for _, rec := range records {
+		keep[recordKey(rec)] = struct{}{}
+	}
+	out := []aRecord{}
+	for _, prev := range view.zoneRecords(zone) {
+		if _, ok := keep[recordKey(prev)]; !ok {
+			out = append(out, prev)
+		}
+	}
+	return out
+}
+
+// importZoneConfig returns the zone config after an import: the existing or
+// default zone with the file's apex NS and SOA fields. A file SOA outside
+// the RFC 1912 bounds is reported and the current fields kept. The serial
+// moves past both the current and the file's serial.
+func (s *server) importZoneConfig(zone string, parsed zoneFile, now time.Time) (zoneConfig, []importIssue, error) {
+	z := zoneConfig{Zone: zone, NS: parsed.NS, UpdatedAt: now}
+	base := s.deletedSerial(zone)
+	if existing, ok := s.data.getZone(zone); ok {
+		base = existing.Serial
+	}
+	if parsed.SOA != nil {
+		z.SOATTL = parsed.SOA.Hdr.Ttl
+	}
+	if err := s.ensureZoneDefaults(&z, now); err != nil {
+		return z, nil, err
+	}
+
+	var skipped []importIssue
+	if soa := parsed.SOA; soa != nil {
+		candidate := z
+		candidate.Refresh = soa.Refresh
+		candidate.Retry = soa.Retry
+		candidate.Expire = soa.Expire
+		candidate.NegativeTTL = soa.Minttl
+		candidate.Mbox = normalizeName(soa.Mbox)
+		candidate.MName = normalizeName(soa.Ns)
+		if err := validateSOA(candidate); err != nil {
+			skipped = append(skipped, importIssue{Name: zone, Type: "SOA", Reason: "kept current SOA fields: " + err.Error()})
+		} else {
+			z = candidate
+		}
+		if serialGreater(soa.Serial, base) {
+			base = soa.Serial
+		}
+	}
+	z.Serial = nextSerial(z.SerialScheme, base, now)
+	return z, skipped, nil
+}
+
+// propagateImport replicates an import as one batch event carrying the zone
+// config and the removed and added records, so peers apply the import
+// atomically and never see the records before the zone they belong to.
+func (s *server) propagateImport(actor string, z zoneConfig, added, removed []aRecord, version int64, now time.Time) {
+	changes := make([]recordChange, 0, len(removed)+len(added))
+	for i := range removed {
+		changes = append(changes, recordChange{Op: "remove", Record: &removed[i], Zone: z.Zone, Version: version})
+	}
+	for i := range added {
+		changes = append(changes, recordChange{Op: "add", Record: &added[i], Zone: z.Zone, Version: added[i].Version})
+	}
+	s.propagate(syncEvent{OriginNode: s.cfg.NodeID, Op: "batch", Version: version, EventTime: now, Changes: changes, Zones: []zoneConfig{z}, Actor: actor})
+}
+
+// renderZoneFile writes records as an RFC 1035 master file, one record per
+// line with fully qualified owners, ending with a newline.
+func renderZoneFile(records []dns.RR) []byte {
+	var b bytes.Buffer
+	for _, rr := range records {
+		b.WriteString(rr.String())
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// exportZone renders zone from view: its SOA, apex NS and live records, or
+// the catalog when zone is the catalog zone.
+func (s *server) exportZone(view *storeView, zone string) ([]byte, bool) {
+	if s.catalog != nil && zone == s.catalog.name {
+		return renderZoneFile(s.catalog.records()), true
+	}
+	z, ok := view.getZone(zone)
+	if !ok {
+		return nil, false
+	}
+	return renderZoneFile(zoneRecords(z, view.liveRecordsByZone(z.Zone)[z.Zone])), true
+}
+
+// handleZoneExport returns zone as a master file.
+func (s *server) handleZoneExport(w http.ResponseWriter, r *http.Request) {
+	zone := normalizeName(chi.URLParam(r, "zone"))
+	view := s.data.view()
+	body, ok := s.exportZone(view, zone)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "zone not found"})
+		return
+	}
+	if z, ok := view.getZone(zone); ok {
+		setETag(w, zoneETag(z))
+	}
+	w.Header().Set("Content-Type", "text/dns")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+zoneFileName(zone)+`"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// handleZonesExport returns every zone as a tar archive of master files,
+// all rendered from one store snapshot, followed by the catalog zone when
+// one is configured.
+func (s *server) handleZonesExport(w http.ResponseWriter, _ *http.Request) {
+	view := s.data.view()
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", `attachment; filename="zones.tar"`)
+	w.WriteHeader(http.StatusOK)
+
+	tw := tar.NewWriter(w)
+	byZone := view.liveRecordsByZone("")
+	for _, z := range view.listZones() {
+		body := renderZoneFile(zoneRecords(z, byZone[z.Zone]))
+		if err := writeZoneTarEntry(tw, z.Zone, body, z.UpdatedAt); err != nil {
+			log.Printf("zone export failed: %v", err)
+			return
+		}
+	}
+	if s.catalog != nil {
+		body, _ := s.exportZone(view, s.catalog.name)
+		if err := writeZoneTarEntry(tw, s.catalog.name, body, time.Now().UTC()); err != nil {
+			log.Printf("zone export failed: %v", err)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.Printf("zone export failed: %v", err)
+	}
+}
+
+// writeZoneTarEntry adds zone's master file body to tw.
+func writeZoneTarEntry(tw *tar.Writer, zone string, body []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    zoneFileName(zone),
+		Mode:    0o644,
+		Size:    int64(len(body)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(body)
+	return err
+}
+
+// zoneFileName is the file name an exported zone is saved under. Characters
+// that would break a path or header are replaced.
+func zoneFileName(zone string) string {
+	name := strings.NewReplacer("/", "_", `"`, "_", `\`, "_").Replace(strings.TrimSuffix(zone, "."))
+	return name + ".zone"
+}
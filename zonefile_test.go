@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testZoneFile = `$ORIGIN example.net.
+$TTL 300
+@	IN	SOA	ns1.example.net. admin.example.net. (
+			2024010101 ; serial
+			7200       ; refresh
+			900        ; retry
+			1209600    ; expire
+			60 )       ; minimum
+	IN	NS	ns1
+	IN	NS	ns2.example.org.
+	IN	MX	10 mail
+www	600	IN	A	198.51.100.10
+www		IN	AAAA	2001:db8::10
+mail		IN	A	198.51.100.20
+alias		IN	CNAME	www
+txt		IN	TXT	"v=spf1 " "-all"
+_sip._tcp	IN	SRV	10 5 5060 sip
+sub		IN	NS	ns.sub
+other.example.org.	IN	A	198.51.100.30
+`
+
+func TestParseZoneFile(t *testing.T) {
+	parsed, err := parseZoneFile("example.net.", strings.NewReader(testZoneFile), 20)
+	if err != nil {
+		t.Fatalf("parseZoneFile: %v", err)
+	}
+	if parsed.SOA == nil || parsed.SOA.Serial != 2024010101 || parsed.SOA.Refresh != 7200 {
+		t.Fatalf("unexpected SOA: %v", parsed.SOA)
+	}
+	if len(parsed.NS) != 2 || parsed.NS[0] != "ns1.example.net." || parsed.NS[1] != "ns2.example.org." {
+		t.Fatalf("unexpected apex NS: %v", parsed.NS)
+	}
+	if len(parsed.Records) != 6 {
+		t.Fatalf("expected 6 records, got %d: %+v", len(parsed.Records), parsed.Records)
+	}
+	byName := make(map[string]aRecord)
+	for _, rec := range parsed.Records {
+		byName[rec.Name+"/"+rec.Type] = rec
+	}
+	if rec := byName["www.example.net./A"]; rec.IP != "198.51.100.10" || rec.TTL != 600 {
+		t.Fatalf("unexpected www A: %+v", rec)
+	}
+	if rec := byName["mail.example.net./A"]; rec.TTL != 300 {
+		t.Fatalf("expected $TTL to apply, got %+v", rec)
+	}
+	if rec := byName["txt.example.net./TXT"]; rec.Text != "v=spf1 -all" {
+		t.Fatalf("expected joined TXT strings, got %q", rec.Text)
+	}
+	if rec := byName["example.net./MX"]; rec.Target != "mail.example.net." || rec.Priority != 10 {
+		t.Fatalf("unexpected MX: %+v", rec)
+	}
+	if len(parsed.Skipped) != 3 {
+		t.Fatalf("expected SRV, delegation and out-of-zone records skipped, got %+v", parsed.Skipped)
+	}
+
+	if _, err := parseZoneFile("example.net.", strings.NewReader("www IN A not-an-ip\n"), 20); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
+
+func TestHTTPZoneImport(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	now := time.Now().UTC()
+	s.data.upsertZone(zoneConfig{Zone: "example.net", NS: []string{"old.example.net"}, SOATTL: 60, Serial: 5, SerialScheme: serialSchemeCounter, UpdatedAt: now})
+	s.data.setRecord(aRecord{Name: "legacy.example.net", Type: "A", Zone: "example.net", IP: "198.51.100.99", TTL: 20, Version: 1, UpdatedAt: now})
+
+	importZone := func(mode, body string) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/zones/example.net/import?propagate=false&mode="+mode, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Content-Type", "text/dns")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		var out map[string]any
+		_ = json.Unmarshal(resp.Body.Bytes(), &out)
+		return resp, out
+	}
+
+	resp, out := importZone("merge", testZoneFile)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for merge import, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if out["imported"].(float64) != 6 || len(out["skipped"].([]any)) != 3 {
+		t.Fatalf("unexpected import report: %v", out)
+	}
+	z, _ := s.data.getZone("example.net")
+	if z.Serial != 2024010102 || z.Refresh != 7200 || z.NegativeTTL != 60 || len(z.NS) != 2 {
+		t.Fatalf("expected zone to take the file's SOA and NS, got %+v", z)
+	}
+	if !s.data.hasName("legacy.example.net") || !s.data.hasName("www.example.net") {
+		t.Fatal("expected merge to keep existing records and add new ones")
+	}
+
+	resp, out = importZone("replace", "$ORIGIN example.net.\nwww 300 IN A 198.51.100.11\n")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for replace import, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if out["removed"].(float64) != 7 {
+		t.Fatalf("expected the other seven records removed, got %v", out)
+	}
+	if s.data.hasName("legacy.example.net") || len(s.data.getRecords("www.example.net", 1)) != 1 {
+		t.Fatal("expected replace to leave only the imported record")
+	}
+
+	loaded := newStore()
+	if err := s.persist.loadIntoStore(loaded); err != nil {
+		t.Fatalf("loadIntoStore: %v", err)
+	}
+	if got := loaded.listRecords(); len(got) != 1 || got[0].IP != "198.51.100.11" {
+		t.Fatalf("expected persisted zone to match the replace import, got %+v", got)
+	}
+
+	if resp, _ := importZone("overwrite", testZoneFile); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown mode, got %d", resp.Code)
+	}
+	if resp, _ := importZone("merge", "www IN A nope\n"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad zone file, got %d", resp.Code)
+	}
+}
+
+func TestHTTPZoneExportRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter()
+	now := time.Now().UTC()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/zones/example.net/import?propagate=false", strings.NewReader(testZoneFile))
+	req.Header.Set("Authorization", "Bearer token")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for import, got %d: %s", resp.Code, resp.Body.String())
+	}
+	s.data.upsertZone(zoneConfig{Zone: "example.org", NS: []string{"ns1.example.org"}, SOATTL: 60, Serial: 3, UpdatedAt: now})
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/zones/example.net/export", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "text/dns" {
+		t.Fatalf("expected text/dns export, got %d %q", resp.Code, resp.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	if !strings.Contains(lines[0], "\tSOA\t") || !strings.Contains(lines[1], "\tNS\t") {
+		t.Fatalf("expected SOA then NS first, got %q", lines[:2])
+	}
+
+	parsed, err := parseZoneFile("example.net.", strings.NewReader(resp.Body.String()), 20)
+	if err != nil {
+		t.Fatalf("export does not parse: %v", err)
+	}
+	z, _ := s.data.getZone("example.net")
+	if parsed.SOA == nil || parsed.SOA.Serial != z.Serial || len(parsed.NS) != 2 || len(parsed.Records) != 6 || len(parsed.Skipped) != 0 {
+		t.Fatalf("export does not round-trip: soa=%v ns=%v records=%d skipped=%v", parsed.SOA, parsed.NS, len(parsed.Records), parsed.Skipped)
+	}
+	for _, rec := range parsed.Records {
+		if rec.Name == "www.example.net." && rec.Type == "A" && rec.TTL != 600 {
+			t.Fatalf("expected record TTL to survive export, got %d", rec.TTL)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/zones/missing.test/export", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown zone, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/zones/export", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for tar export, got %d", resp.Code)
+	}
+	tr := tar.NewReader(bytes.NewReader(resp.Body.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "example.net.zone,example.org.zone" {
+		t.Fatalf("unexpected tar entries: %v", names)
+	}
+}
+
+func TestCanonicalNameOrder(t *testing.T) {
+	names := []string{"z.example.", "example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "*.z.example."}
+	want := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "z.example.", "*.z.example."}
+	sort.Slice(names, func(i, j int) bool { return canonicalNameCompare(names[i], names[j]) < 0 })
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+}